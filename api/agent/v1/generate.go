@@ -0,0 +1,4 @@
+// Package agentv1 holds the gRPC API of the agent, generated from agent.proto.
+package agentv1
+
+//go:generate protoc --proto_path=../../.. --go_out=../../.. --go_opt=paths=source_relative --go-grpc_out=../../.. --go-grpc_opt=paths=source_relative api/agent/v1/agent.proto
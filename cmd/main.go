@@ -1,6 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/blog/conversational-agent/internal/agents"
 	"github.com/blog/conversational-agent/internal/config"
 	"github.com/blog/conversational-agent/internal/handlers"
@@ -8,6 +17,7 @@ import (
 	"github.com/blog/conversational-agent/internal/middleware"
 	"github.com/blog/conversational-agent/internal/router"
 	"github.com/labstack/echo/v4"
+	"google.golang.org/grpc"
 )
 
 func main() {
@@ -37,6 +47,77 @@ func main() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to initialize agent manager")
 	}
+
+	// Apply retrieval defaults from configuration
+	agentManager.Retrieval = agents.RetrievalConfig{
+		KnowledgeEnabled:   cfg.KnowledgeRetrievalEnabled,
+		KnowledgeTopK:      cfg.KnowledgeTopK,
+		KnowledgeHalfLife:  cfg.KnowledgeHalfLife,
+		MMREnabled:         cfg.MMREnabled,
+		MMRLambda:          cfg.MMRLambda,
+		CorrectionTopK:     cfg.CorrectionTopK,
+		CorrectionBoost:    cfg.CorrectionBoost,
+		Expansion:          cfg.KnowledgeExpansion,
+		NeighborWindow:     cfg.NeighborWindow,
+		ContextTokenBudget: cfg.ContextTokenBudget,
+		MemoryEnabled:      cfg.MemoryRetrievalEnabled,
+		MemoryTopK:         cfg.MemoryTopK,
+		MemoryScope:        cfg.MemoryScope,
+		MemoryHalfLife:     cfg.MemoryHalfLife,
+	}
+	agentManager.ChunkWords = cfg.ChunkWords
+	agentManager.MaxAgentIterations = cfg.AgentMaxIterations
+	agentManager.ApprovalWebhookURL = cfg.ApprovalWebhookURL
+	agentManager.RoutingEnabled = cfg.RoutingEnabled
+	agentManager.StructuredOutputRetries = cfg.StructuredOutputRetries
+	agentManager.Generations = agents.NewGenerationStore(cfg.StreamBufferTTL, cfg.MaxGenerationsPerOrg)
+	agentManager.Batches = agents.NewBatchStore(cfg.BatchConcurrency, cfg.MaxBatchesPerOrg)
+
+	// Keep org personas, prompt versions and feedback in the data directory
+	if cfg.DataDir != "" {
+		agentManager.Personas, err = agents.NewPersonaStore(cfg.DataDir)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load personas")
+		}
+		agentManager.Prompts, err = agents.NewPromptRegistry(cfg.DataDir)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load prompt registry")
+		}
+		agentManager.Feedback, err = agents.NewFeedbackStore(cfg.DataDir)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load feedback")
+		}
+	}
+
+	// Replace the built-in sub-agents with the configured routes
+	if cfg.AgentRoutesFile != "" {
+		router, err := agents.LoadAgentRouter(cfg.AgentRoutesFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load agent routes")
+		}
+		agentManager.Router = router
+	}
+
+	// Register the per-org HTTP tools
+	if cfg.HTTPToolsFile != "" {
+		err := agentManager.LoadHTTPTools(cfg.HTTPToolsFile, agents.HTTPToolPolicy{
+			AllowedHosts:     cfg.HTTPToolAllowedHosts,
+			Timeout:          cfg.HTTPToolTimeout,
+			MaxResponseBytes: cfg.HTTPToolMaxResponseBytes,
+			Secrets:          agents.EnvSecretResolver,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load HTTP tools")
+		}
+	}
+
+	// Connect the per-org MCP servers
+	if cfg.MCPServersFile != "" {
+		if err := agentManager.LoadMCPServers(context.Background(), cfg.MCPServersFile); err != nil {
+			log.Fatal().Err(err).Msg("Failed to load MCP servers")
+		}
+	}
+
 	// Initialize handlers
 	agentHandler := &handlers.AgentHandler{
 		AgentManager: agentManager,
@@ -45,9 +126,57 @@ func main() {
 	// Register routes
 	router.RegisterRoutes(e, agentHandler)
 
+	// Serve the gRPC API alongside REST
+	var grpcServer *grpc.Server
+	if cfg.GRPCAddress != "" {
+		grpcServer = grpc.NewServer(
+			grpc.ChainUnaryInterceptor(middleware.GRPCUnaryLoggingInterceptor()),
+			grpc.ChainStreamInterceptor(middleware.GRPCStreamLoggingInterceptor()),
+		)
+		router.RegisterGRPCServices(grpcServer, agentHandler)
+
+		listener, err := net.Listen("tcp", cfg.GRPCAddress)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to listen for gRPC")
+		}
+		go func() {
+			log.Info().Msgf("gRPC server is starting on %s", cfg.GRPCAddress)
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Fatal().Err(err).Msg("gRPC server failed to start")
+			}
+		}()
+	}
+
 	// Start the server
-	log.Info().Msg("Server is starting on port 8080")
-	if err := e.Start(":8080"); err != nil {
-		log.Fatal().Err(err).Msg("Server failed to start")
+	go func() {
+		log.Info().Msg("Server is starting on port 8080")
+		if err := e.Start(":8080"); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal().Err(err).Msg("Server failed to start")
+		}
+	}()
+
+	// Stop serving on interrupt, then end the MCP sessions so stdio servers exit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Info().Msg("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("Failed to shut down the server")
+	}
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			grpcServer.Stop()
+		}
 	}
+	agentManager.CloseMCPServers()
 }
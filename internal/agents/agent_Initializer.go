@@ -2,7 +2,9 @@ package agents
 
 import (
 	"context"
+	"fmt"
 
+	agentprompts "github.com/blog/conversational-agent/internal/prompts"
 	"github.com/tmc/langchaingo/chains"
 	"github.com/tmc/langchaingo/embeddings"
 	"github.com/tmc/langchaingo/llms"
@@ -10,6 +12,8 @@ import (
 	"github.com/tmc/langchaingo/memory"
 	"github.com/tmc/langchaingo/prompts"
 	"github.com/tmc/langchaingo/vectorstores/weaviate"
+	weaviateclient "github.com/weaviate/weaviate-go-client/v4/weaviate"
+	"github.com/weaviate/weaviate/entities/models"
 )
 
 // InitializeLLM sets up the OpenAI LLM.
@@ -26,16 +30,14 @@ func InitializeMemory() *memory.ConversationBuffer {
 		memory.WithMemoryKey("history"),
 		memory.WithReturnMessages(false), // Return buffer as a string
 		memory.WithHumanPrefix("You"),
-		memory.WithAIPrefix("StarOps"),
+		memory.WithAIPrefix("AI"),
 	)
 }
 
 func InitializeChain(llm llms.Model, memory *memory.ConversationBuffer) (*chains.LLMChain, error) {
 	// Define the prompt template with a single 'context' field
-	prompt := prompts.NewPromptTemplate(`
-{{.context}}
-
-AI Response:`,
+	prompt := prompts.NewPromptTemplate(
+		agentprompts.ChainTemplate,
 		[]string{"context"}, // Explicitly define 'context' as the input key
 	)
 	// Create the LLMChain with the prompt and memory
@@ -55,10 +57,12 @@ func InitializeEmbedder(llm *openai.LLM) (*embeddings.EmbedderImpl, error) {
 	return embeddings.NewEmbedder(client)
 }
 
-// InitializeVectorStore sets up the Weaviate vector store.
+// InitializeVectorStore sets up the Weaviate vector store, reading the given additional
+// fields back with every search result.
 func InitializeVectorStore(
 	weaviateHost, weaviateApiKey, weaviateIndex string,
 	embedder *embeddings.EmbedderImpl,
+	additionalFields ...string,
 ) (weaviate.Store, error) {
 	return weaviate.New(
 		weaviate.WithHost(weaviateHost),
@@ -67,5 +71,72 @@ func InitializeVectorStore(
 		weaviate.WithIndexName(weaviateIndex),
 		weaviate.WithTextKey("text"),
 		weaviate.WithEmbedder(embedder),
+		weaviate.WithQueryAttrs(documentPropertyNames()),
+		// Creation time is used for recency weighting of documents without a timestamp
+		weaviate.WithAdditionalFields(append([]string{"creationTimeUnix"}, additionalFields...)),
 	)
 }
+
+// documentProperties lists the properties read back with every search result.
+// They must exist in the index class for queries to succeed.
+var documentProperties = []*models.Property{
+	{Name: "text", DataType: []string{"text"}},
+	{Name: "nameSpace", DataType: []string{"text"}},
+	{Name: "source", DataType: []string{"text"}},
+	{Name: "timestamp", DataType: []string{"date"}},
+	{Name: "user_id", DataType: []string{"text"}},
+	{Name: "org_id", DataType: []string{"text"}},
+	{Name: "thread_id", DataType: []string{"text"}},
+	{Name: "parent_id", DataType: []string{"text"}},
+	{Name: "chunk_index", DataType: []string{"int"}},
+	{Name: "feedback_id", DataType: []string{"text"}},
+}
+
+func documentPropertyNames() []string {
+	names := make([]string, 0, len(documentProperties))
+	for _, property := range documentProperties {
+		names = append(names, property.Name)
+	}
+	return names
+}
+
+// EnsureVectorStoreSchema creates the index class, or adds any missing document properties to it.
+func EnsureVectorStoreSchema(ctx context.Context, weaviateHost, weaviateApiKey, weaviateIndex string) error {
+	client := weaviateclient.New(weaviateclient.Config{
+		Scheme:  "https",
+		Host:    weaviateHost,
+		Headers: map[string]string{"Authorization": fmt.Sprintf("Bearer %s", weaviateApiKey)},
+	})
+
+	exists, err := client.Schema().ClassExistenceChecker().WithClassName(weaviateIndex).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check class %s: %w", weaviateIndex, err)
+	}
+	if !exists {
+		// Vectors are computed by the embedder, so the class needs no vectorizer
+		return client.Schema().ClassCreator().WithClass(&models.Class{
+			Class:      weaviateIndex,
+			Vectorizer: "none",
+			Properties: documentProperties,
+		}).Do(ctx)
+	}
+
+	class, err := client.Schema().ClassGetter().WithClassName(weaviateIndex).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get class %s: %w", weaviateIndex, err)
+	}
+	existing := make(map[string]bool, len(class.Properties))
+	for _, property := range class.Properties {
+		existing[property.Name] = true
+	}
+	for _, property := range documentProperties {
+		if existing[property.Name] {
+			continue
+		}
+		if err := client.Schema().PropertyCreator().WithClassName(weaviateIndex).WithProperty(property).Do(ctx); err != nil {
+			return fmt.Errorf("failed to add property %s to class %s: %w", property.Name, weaviateIndex, err)
+		}
+	}
+
+	return nil
+}
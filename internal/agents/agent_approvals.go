@@ -0,0 +1,220 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/google/uuid"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/tools"
+)
+
+// StepTypeApproval reports that the agent paused for approval.
+const StepTypeApproval = "approval_required"
+
+// approvalWebhookTimeout bounds a single webhook notification.
+const approvalWebhookTimeout = 10 * time.Second
+
+var (
+	// ErrPendingAction is returned when a thread is paused waiting for approval.
+	ErrPendingAction = errors.New("thread has a pending action awaiting approval")
+	// ErrNoPendingAction is returned when there is no pending action to resolve.
+	ErrNoPendingAction = errors.New("thread has no pending action")
+)
+
+// PendingToolCall is a tool call waiting in a pending action.
+type PendingToolCall struct {
+	ID               string `json:"id"`
+	Tool             string `json:"tool"`
+	Arguments        string `json:"arguments"`
+	RequiresApproval bool   `json:"requires_approval"`
+}
+
+// PendingAction is a tool-calling run paused until the tool calls are approved or rejected.
+// Pending actions are held in memory with the thread's chat history, which the run resumes
+// from, so neither survives a restart.
+type PendingAction struct {
+	ID        string            `json:"id"`
+	ThreadID  string            `json:"thread_id"`
+	UserID    string            `json:"user_id"`
+	OrgID     string            `json:"org_id"`
+	ToolCalls []PendingToolCall `json:"tool_calls"`
+	CreatedAt time.Time         `json:"created_at"`
+
+	run   toolCallingRun
+	calls []llms.ToolCall
+}
+
+// ApprovalRequiredError is returned by Query when the agent paused for approval.
+type ApprovalRequiredError struct {
+	Action *PendingAction
+}
+
+func (e *ApprovalRequiredError) Error() string {
+	return fmt.Sprintf("tool calls in thread %s require approval", e.Action.ThreadID)
+}
+
+// ApprovalTool is implemented by tools that declare whether they need approval before running.
+type ApprovalTool interface {
+	tools.Tool
+	RequiresApproval() bool
+}
+
+// pauseForApproval stores the remaining tool calls with the thread and notifies the client.
+func (am *AgentManager) pauseForApproval(ctx context.Context, run toolCallingRun, calls []llms.ToolCall) error {
+	action := &PendingAction{
+		ID:        uuid.NewString(),
+		ThreadID:  run.threadID,
+		UserID:    run.userID,
+		OrgID:     run.orgID,
+		CreatedAt: time.Now().UTC(),
+		run:       run,
+		calls:     calls,
+	}
+	for _, call := range calls {
+		if call.FunctionCall == nil {
+			continue
+		}
+		action.ToolCalls = append(action.ToolCalls, PendingToolCall{
+			ID:               call.ID,
+			Tool:             call.FunctionCall.Name,
+			Arguments:        call.FunctionCall.Arguments,
+			RequiresApproval: am.Tools.RequiresApproval(run.orgID, call.FunctionCall.Name),
+		})
+	}
+
+	am.memoryMutex.Lock()
+	if am.pendingActions == nil {
+		am.pendingActions = make(map[string]*PendingAction)
+	}
+	am.pendingActions[run.threadID] = action
+	am.memoryMutex.Unlock()
+
+	if am.ApprovalWebhookURL != "" {
+		go am.notifyApprovalWebhook(action)
+	}
+	return &ApprovalRequiredError{Action: action}
+}
+
+// PendingAction returns the action the thread is waiting on.
+func (am *AgentManager) PendingAction(threadID string) (*PendingAction, bool) {
+	am.memoryMutex.Lock()
+	defer am.memoryMutex.Unlock()
+
+	action, ok := am.pendingActions[threadID]
+	return action, ok
+}
+
+// takePendingAction removes and returns the thread's pending action, so it is resolved only
+// once. The action must have the given ID and belong to the given user and org.
+func (am *AgentManager) takePendingAction(userID, orgID, threadID, actionID string) (*PendingAction, error) {
+	am.memoryMutex.Lock()
+	defer am.memoryMutex.Unlock()
+
+	action, ok := am.pendingActions[threadID]
+	if !ok || action.ID != actionID {
+		return nil, ErrNoPendingAction
+	}
+	if action.UserID != userID || action.OrgID != orgID {
+		return nil, ErrThreadNotOwned
+	}
+	delete(am.pendingActions, threadID)
+	return action, nil
+}
+
+// ResolvePendingAction approves or rejects the pending tool calls of one of the user's
+// threads and resumes the run. Rejected calls are reported to the model as refused, with the
+// reason if given.
+func (am *AgentManager) ResolvePendingAction(
+	ctx context.Context,
+	userID, orgID, threadID, actionID string,
+	approved bool,
+	reason string,
+	chunkCallback func([]byte),
+	options ...QueryOption,
+) (string, error) {
+	log := logger.GetLogger()
+
+	action, err := am.takePendingAction(userID, orgID, threadID, actionID)
+	if err != nil {
+		return "", err
+	}
+	opts := am.getQueryOptions(options...)
+	opts.Mode = ModeTools
+	ctx = withQueryScope(ctx, queryScope{UserID: action.UserID, OrgID: action.OrgID, ThreadID: threadID})
+
+	log.Info().Msgf("Pending action %s in thread %s resolved: approved=%t", action.ID, threadID, approved)
+	if opts.StepCallback != nil {
+		opts.StepCallback(AgentStep{Type: StepTypeApproval, Log: fmt.Sprintf("approved=%t", approved)})
+	}
+
+	toolsByName := make(map[string]tools.Tool)
+	for _, tool := range filterTools(am.Tools.ForOrg(action.OrgID), action.run.toolNames) {
+		toolsByName[tool.Name()] = tool
+	}
+
+	threadMemory := am.GetThreadMemory(threadID)
+	for _, call := range action.calls {
+		var result string
+		if !approved && call.FunctionCall != nil && am.Tools.RequiresApproval(action.OrgID, call.FunctionCall.Name) {
+			result = "error: the user rejected this action"
+			if reason != "" {
+				result += ": " + reason
+			}
+		} else {
+			result = am.executeToolCall(ctx, toolsByName, call, opts)
+		}
+		if err := threadMemory.ChatHistory.AddMessage(ctx, llms.ToolChatMessage{ID: call.ID, Content: result}); err != nil {
+			return "", fmt.Errorf("failed to record tool result: %w", err)
+		}
+	}
+
+	response, err := am.continueToolCalling(ctx, threadMemory, action.run, opts, chunkCallback)
+	if err != nil {
+		return "", err
+	}
+
+	am.addToBuffer(threadID, action.run.input, response, action.UserID, action.OrgID)
+
+	record := action.run.record
+	record.Answer = response
+	am.recordAnswer(ctx, record, opts)
+	return response, nil
+}
+
+// notifyApprovalWebhook posts the pending action to the configured webhook.
+func (am *AgentManager) notifyApprovalWebhook(action *PendingAction) {
+	log := logger.GetLogger()
+
+	payload, err := json.Marshal(map[string]any{"event": StepTypeApproval, "action": action})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode approval webhook payload.")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), approvalWebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, am.ApprovalWebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to build approval webhook request.")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to notify approval webhook for action %s.", action.ID)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Warn().Msgf("Approval webhook returned status %d for action %s.", resp.StatusCode, action.ID)
+	}
+}
@@ -0,0 +1,47 @@
+package agents
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTakePendingAction(t *testing.T) {
+	tests := []struct {
+		name     string
+		userID   string
+		orgID    string
+		actionID string
+		wantErr  error
+	}{
+		{name: "missing action ID", userID: "alice", orgID: "acme", actionID: "", wantErr: ErrNoPendingAction},
+		{name: "other action ID", userID: "alice", orgID: "acme", actionID: "a2", wantErr: ErrNoPendingAction},
+		{name: "other user", userID: "bob", orgID: "acme", actionID: "a1", wantErr: ErrThreadNotOwned},
+		{name: "other org", userID: "alice", orgID: "globex", actionID: "a1", wantErr: ErrThreadNotOwned},
+		{name: "owner", userID: "alice", orgID: "acme", actionID: "a1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			am := &AgentManager{pendingActions: map[string]*PendingAction{
+				"t1": {ID: "a1", ThreadID: "t1", UserID: "alice", OrgID: "acme"},
+			}}
+
+			action, err := am.takePendingAction(tt.userID, tt.orgID, "t1", tt.actionID)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				if _, ok := am.PendingAction("t1"); !ok {
+					t.Fatal("a refused request removed the pending action")
+				}
+				return
+			}
+			if err != nil || action.ID != "a1" {
+				t.Fatalf("unexpected result %v, %v", action, err)
+			}
+			if _, ok := am.PendingAction("t1"); ok {
+				t.Fatal("the pending action was not removed")
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/google/uuid"
+)
+
+// Batch statuses.
+const (
+	BatchRunning   = "running"
+	BatchCompleted = "completed"
+	BatchCancelled = "cancelled"
+)
+
+// ErrTooManyBatches is returned when an org already has its maximum of running batches.
+var ErrTooManyBatches = errors.New("too many batches running for this organization")
+
+const (
+	// defaultBatchConcurrency is the number of batch queries answered at once.
+	defaultBatchConcurrency = 4
+	// defaultMaxBatchesPerOrg is the number of batches an org can run at once.
+	defaultMaxBatchesPerOrg = 2
+	// batchTTL is how long a finished batch's results stay available.
+	batchTTL = 24 * time.Hour
+)
+
+// BatchQuery is a query of a batch. Without a thread ID it is answered in a thread of its own.
+// Its org is the batch's.
+type BatchQuery struct {
+	ID       string `json:"id,omitempty"`
+	OrgID    string `json:"org_id"`
+	UserID   string `json:"user_id"`
+	ThreadID string `json:"thread_id,omitempty"`
+	Query    string `json:"query"`
+}
+
+// BatchResult is the answer to a batch query, or the error that stopped it.
+type BatchResult struct {
+	Index     int    `json:"index"`
+	ID        string `json:"id,omitempty"`
+	OrgID     string `json:"org_id"`
+	UserID    string `json:"user_id"`
+	ThreadID  string `json:"thread_id"`
+	Query     string `json:"query"`
+	Response  string `json:"response,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// BatchInfo describes a batch and its progress.
+type BatchInfo struct {
+	ID         string     `json:"id"`
+	OrgID      string     `json:"org_id"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Completed  int        `json:"completed"`
+	Failed     int        `json:"failed"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// BatchJob answers a batch of queries of an org in the background.
+type BatchJob struct {
+	ID    string
+	OrgID string
+
+	mutex      sync.Mutex
+	status     string
+	createdAt  time.Time
+	finishedAt time.Time
+	results    []BatchResult
+	completed  int
+	failed     int
+	cancel     context.CancelFunc
+}
+
+// Info returns the batch's current state.
+func (j *BatchJob) Info() BatchInfo {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	info := BatchInfo{
+		ID:        j.ID,
+		OrgID:     j.OrgID,
+		Status:    j.status,
+		Total:     len(j.results),
+		Completed: j.completed,
+		Failed:    j.failed,
+		CreatedAt: j.createdAt,
+	}
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		info.FinishedAt = &finishedAt
+	}
+	return info
+}
+
+// Results returns the results in the order of the queries, once the batch has finished.
+func (j *BatchJob) Results() ([]BatchResult, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.status == BatchRunning {
+		return nil, false
+	}
+	return append([]BatchResult(nil), j.results...), true
+}
+
+// Cancel stops a running batch, reporting whether it was running. Queries not answered yet
+// end with an error.
+func (j *BatchJob) Cancel() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.status != BatchRunning {
+		return false
+	}
+	j.cancel()
+	return true
+}
+
+func (j *BatchJob) setResult(result BatchResult) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.results[result.Index] = result
+	if result.Error != "" {
+		j.failed++
+	} else {
+		j.completed++
+	}
+}
+
+func (j *BatchJob) finish(ctx context.Context) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.status = BatchCompleted
+	if ctx.Err() != nil {
+		j.status = BatchCancelled
+	}
+	j.finishedAt = time.Now().UTC()
+}
+
+// BatchStore runs batches with bounded concurrency and keeps them until their results expire.
+// The concurrency is shared by all its batches, and an org runs a bounded number of batches at
+// once.
+type BatchStore struct {
+	mutex       sync.Mutex
+	concurrency int
+	maxPerOrg   int
+	slots       chan struct{}
+	jobs        map[string]*BatchJob
+}
+
+// NewBatchStore answers up to concurrency queries at once across all batches and runs at most
+// maxPerOrg batches at once for an org; zero uses the defaults.
+func NewBatchStore(concurrency, maxPerOrg int) *BatchStore {
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	if maxPerOrg <= 0 {
+		maxPerOrg = defaultMaxBatchesPerOrg
+	}
+	return &BatchStore{
+		concurrency: concurrency,
+		maxPerOrg:   maxPerOrg,
+		slots:       make(chan struct{}, concurrency),
+		jobs:        make(map[string]*BatchJob),
+	}
+}
+
+// acquire waits for a free slot, reporting false if the context ends first.
+func (s *BatchStore) acquire(ctx context.Context) bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *BatchStore) release() {
+	<-s.slots
+}
+
+// Get returns a running batch, or a finished one whose results have not expired.
+func (s *BatchStore) Get(id string) (*BatchJob, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prune()
+	job, ok := s.jobs[id]
+	return job, ok
+}
+
+// prune drops the batches finished longer ago than the TTL. The caller holds the lock.
+func (s *BatchStore) prune() {
+	cutoff := time.Now().Add(-batchTTL)
+	for id, job := range s.jobs {
+		job.mutex.Lock()
+		expired := job.status != BatchRunning && job.finishedAt.Before(cutoff)
+		job.mutex.Unlock()
+		if expired {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// running counts the org's running batches. The caller holds the lock.
+func (s *BatchStore) running(orgID string) int {
+	count := 0
+	for _, job := range s.jobs {
+		job.mutex.Lock()
+		if job.OrgID == orgID && job.status == BatchRunning {
+			count++
+		}
+		job.mutex.Unlock()
+	}
+	return count
+}
+
+// StartBatch answers the org's queries through Query in the background, within the store's
+// concurrency. Queries sharing a thread are answered one after the other, in order.
+// ErrTooManyBatches is returned when the org already has its maximum of running batches.
+func (am *AgentManager) StartBatch(orgID string, queries []BatchQuery, options ...QueryOption) (*BatchJob, error) {
+	log := logger.GetLogger()
+
+	// Queries get the batch's org, and a thread if they have none, without changing the
+	// caller's slice
+	queries = append([]BatchQuery(nil), queries...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &BatchJob{
+		ID:        uuid.NewString(),
+		OrgID:     orgID,
+		status:    BatchRunning,
+		createdAt: time.Now().UTC(),
+		results:   make([]BatchResult, len(queries)),
+		cancel:    cancel,
+	}
+
+	// Group the queries by thread, keeping the order of the batch
+	var groups [][]int
+	threadGroups := make(map[string]int)
+	for i := range queries {
+		queries[i].OrgID = orgID
+		if queries[i].ThreadID == "" {
+			queries[i].ThreadID = uuid.NewString()
+		}
+		group, ok := threadGroups[queries[i].ThreadID]
+		if !ok {
+			group = len(groups)
+			threadGroups[queries[i].ThreadID] = group
+			groups = append(groups, nil)
+		}
+		groups[group] = append(groups[group], i)
+	}
+
+	store := am.Batches
+	store.mutex.Lock()
+	store.prune()
+	if store.running(orgID) >= store.maxPerOrg {
+		store.mutex.Unlock()
+		cancel()
+		return nil, ErrTooManyBatches
+	}
+	store.jobs[job.ID] = job
+	store.mutex.Unlock()
+
+	log.Info().Msgf("Starting batch %s with %d queries in %d threads", job.ID, len(queries), len(groups))
+	go func() {
+		defer cancel()
+
+		work := make(chan []int)
+		var workers sync.WaitGroup
+		for w := 0; w < store.concurrency && w < len(groups); w++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for group := range work {
+					for _, index := range group {
+						// A cancelled batch records its remaining queries as failed without a slot
+						acquired := store.acquire(ctx)
+						job.setResult(am.answerBatchQuery(ctx, index, queries[index], options))
+						if acquired {
+							store.release()
+						}
+					}
+				}
+			}()
+		}
+		for _, group := range groups {
+			work <- group
+		}
+		close(work)
+		workers.Wait()
+
+		job.finish(ctx)
+		log.Info().Msgf("Batch %s finished: %+v", job.ID, job.Info())
+	}()
+	return job, nil
+}
+
+// answerBatchQuery answers a query of a batch, recording any error in the result.
+func (am *AgentManager) answerBatchQuery(ctx context.Context, index int, query BatchQuery, options []QueryOption) BatchResult {
+	result := BatchResult{
+		Index:    index,
+		ID:       query.ID,
+		OrgID:    query.OrgID,
+		UserID:   query.UserID,
+		ThreadID: query.ThreadID,
+		Query:    query.Query,
+	}
+	if err := ctx.Err(); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	options = append(options[:len(options):len(options)], WithMessageCallback(func(messageID string) {
+		result.MessageID = messageID
+	}))
+	response, err := am.Query(ctx, query.UserID, query.OrgID, query.ThreadID, query.Query, nil, options...)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Response = response
+	return result
+}
@@ -0,0 +1,31 @@
+package agents
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStartBatchLimitsRunningPerOrg(t *testing.T) {
+	am := &AgentManager{Batches: NewBatchStore(1, 2)}
+	for _, job := range []*BatchJob{
+		{ID: "b1", OrgID: "acme", status: BatchRunning},
+		{ID: "b2", OrgID: "acme", status: BatchRunning},
+		{ID: "b3", OrgID: "other", status: BatchRunning},
+	} {
+		am.Batches.jobs[job.ID] = job
+	}
+
+	queries := []BatchQuery{{UserID: "u1", Query: "q1"}}
+	if _, err := am.StartBatch("acme", queries); !errors.Is(err, ErrTooManyBatches) {
+		t.Fatalf("expected ErrTooManyBatches, got %v", err)
+	}
+	if len(am.Batches.jobs) != 3 {
+		t.Fatalf("rejected batch was stored, %d batches kept", len(am.Batches.jobs))
+	}
+
+	// A finished batch frees its place
+	am.Batches.jobs["b1"].status = BatchCompleted
+	if got := am.Batches.running("acme"); got != 1 {
+		t.Fatalf("acme runs %d batches, want 1", got)
+	}
+}
@@ -0,0 +1,306 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/google/uuid"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Roles of the messages of a thread's history.
+const (
+	RoleUser = "user"
+	RoleAI   = "ai"
+)
+
+// Branching errors.
+var (
+	ErrMessageNotFound = errors.New("message not found in thread")
+	ErrNotUserMessage  = errors.New("only user messages can be edited")
+	ErrNoAnswer        = errors.New("thread has no answer to regenerate")
+)
+
+// ThreadMessage is a message of a thread's history tree. Editing a question or regenerating
+// an answer adds a sibling, so earlier branches stay retrievable.
+type ThreadMessage struct {
+	ID        string    `json:"id"`
+	ParentID  string    `json:"parent_id,omitempty"`
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// The chat messages behind it, including the tool calls of an answer
+	messages []llms.ChatMessage
+}
+
+// threadTree is a thread's history as a tree of messages. The thread's chat history holds the
+// active branch, the path from the root to the active leaf; messages added to it are synced
+// into the tree before the tree is read or the branch changes.
+type threadTree struct {
+	messages map[string]*ThreadMessage
+	order    []string
+	leaf     string
+}
+
+// path returns the messages from the root to the given message.
+func (t *threadTree) path(id string) []*ThreadMessage {
+	var path []*ThreadMessage
+	for id != "" {
+		message := t.messages[id]
+		path = append([]*ThreadMessage{message}, path...)
+		id = message.ParentID
+	}
+	return path
+}
+
+// chatMessages returns the chat history of the branch ending at the given message.
+func (t *threadTree) chatMessages(id string) []llms.ChatMessage {
+	var history []llms.ChatMessage
+	for _, message := range t.path(id) {
+		history = append(history, message.messages...)
+	}
+	return history
+}
+
+// latestLeaf follows the most recent replies from the given message down to a leaf.
+func (t *threadTree) latestLeaf(id string) string {
+	for {
+		next := ""
+		for _, childID := range t.order {
+			if t.messages[childID].ParentID == id {
+				next = childID
+			}
+		}
+		if next == "" {
+			return id
+		}
+		id = next
+	}
+}
+
+// add records a chat message at the active leaf. A question starts a user message, unless
+// the same question was already asked there, so a regenerated answer becomes a sibling of
+// the previous one. Answers, tool calls and tool results make up an AI message.
+func (t *threadTree) add(chatMessage llms.ChatMessage) {
+	leaf := t.messages[t.leaf]
+	content := chatMessage.GetContent()
+
+	if chatMessage.GetType() == llms.ChatMessageTypeHuman {
+		for _, id := range t.order {
+			message := t.messages[id]
+			if message.ParentID == t.leaf && message.Role == RoleUser && message.Content == content {
+				t.leaf = id
+				return
+			}
+		}
+		t.insert(&ThreadMessage{Role: RoleUser, Content: content, messages: []llms.ChatMessage{chatMessage}})
+		return
+	}
+
+	if leaf == nil || leaf.Role != RoleAI {
+		leaf = &ThreadMessage{Role: RoleAI}
+		t.insert(leaf)
+	}
+	leaf.messages = append(leaf.messages, chatMessage)
+	if chatMessage.GetType() == llms.ChatMessageTypeAI && content != "" {
+		leaf.Content = content
+	}
+}
+
+// insert adds a message under the active leaf and makes it the leaf.
+func (t *threadTree) insert(message *ThreadMessage) {
+	message.ID = uuid.NewString()
+	message.ParentID = t.leaf
+	message.CreatedAt = time.Now().UTC()
+	t.messages[message.ID] = message
+	t.order = append(t.order, message.ID)
+	t.leaf = message.ID
+}
+
+// syncThreadTree adds the messages appended to the thread's chat history since the last sync
+// to its tree. The caller holds the tree lock.
+func (am *AgentManager) syncThreadTree(ctx context.Context, threadID string) (*threadTree, error) {
+	if am.threadTrees == nil {
+		am.threadTrees = make(map[string]*threadTree)
+	}
+	tree := am.threadTrees[threadID]
+	if tree == nil {
+		tree = &threadTree{messages: make(map[string]*ThreadMessage)}
+		am.threadTrees[threadID] = tree
+	}
+
+	history, err := am.GetThreadMemory(threadID).ChatHistory.Messages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of thread %s: %w", threadID, err)
+	}
+	synced := len(tree.chatMessages(tree.leaf))
+	if synced > len(history) {
+		return nil, fmt.Errorf("history of thread %s is shorter than its active branch", threadID)
+	}
+	for _, chatMessage := range history[synced:] {
+		tree.add(chatMessage)
+	}
+	return tree, nil
+}
+
+// setActiveBranch makes the branch ending at the given message the thread's chat history.
+// The caller holds the tree lock.
+func (am *AgentManager) setActiveBranch(ctx context.Context, threadID string, tree *threadTree, id string) error {
+	if err := am.GetThreadMemory(threadID).ChatHistory.SetMessages(ctx, tree.chatMessages(id)); err != nil {
+		return fmt.Errorf("failed to switch branch of thread %s: %w", threadID, err)
+	}
+	tree.leaf = id
+	return nil
+}
+
+// ThreadTree returns every message of the thread's history, oldest first, and the ID of the
+// last message of the active branch.
+func (am *AgentManager) ThreadTree(ctx context.Context, threadID string) ([]ThreadMessage, string, error) {
+	am.treeMutex.Lock()
+	defer am.treeMutex.Unlock()
+
+	tree, err := am.syncThreadTree(ctx, threadID)
+	if err != nil {
+		return nil, "", err
+	}
+	messages := make([]ThreadMessage, 0, len(tree.order))
+	for _, id := range tree.order {
+		messages = append(messages, *tree.messages[id])
+	}
+	return messages, tree.leaf, nil
+}
+
+// activeMessageID returns the ID of the last message of the thread's active branch.
+func (am *AgentManager) activeMessageID(ctx context.Context, threadID string) (string, error) {
+	am.treeMutex.Lock()
+	defer am.treeMutex.Unlock()
+
+	tree, err := am.syncThreadTree(ctx, threadID)
+	if err != nil {
+		return "", err
+	}
+	return tree.leaf, nil
+}
+
+// ThreadBranch returns the messages from the root of the thread's history to the given message.
+func (am *AgentManager) ThreadBranch(ctx context.Context, threadID, messageID string) ([]ThreadMessage, error) {
+	am.treeMutex.Lock()
+	defer am.treeMutex.Unlock()
+
+	tree, err := am.syncThreadTree(ctx, threadID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tree.messages[messageID]; !ok {
+		return nil, ErrMessageNotFound
+	}
+	return copyMessages(tree.path(messageID)), nil
+}
+
+// ActivateBranch makes the branch through the given message active, following its latest
+// replies, and returns the branch.
+func (am *AgentManager) ActivateBranch(ctx context.Context, threadID, messageID string) ([]ThreadMessage, error) {
+	if _, pending := am.PendingAction(threadID); pending {
+		return nil, ErrPendingAction
+	}
+
+	am.treeMutex.Lock()
+	defer am.treeMutex.Unlock()
+
+	tree, err := am.syncThreadTree(ctx, threadID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tree.messages[messageID]; !ok {
+		return nil, ErrMessageNotFound
+	}
+	leaf := tree.latestLeaf(messageID)
+	if err := am.setActiveBranch(ctx, threadID, tree, leaf); err != nil {
+		return nil, err
+	}
+	return copyMessages(tree.path(leaf)), nil
+}
+
+// RegenerationPoint returns where the last answer branches off and the question it answered,
+// for regenerating it with WithBranchFrom.
+func (am *AgentManager) RegenerationPoint(ctx context.Context, threadID string) (parentID, input string, err error) {
+	am.treeMutex.Lock()
+	defer am.treeMutex.Unlock()
+
+	tree, err := am.syncThreadTree(ctx, threadID)
+	if err != nil {
+		return "", "", err
+	}
+	answer := tree.messages[tree.leaf]
+	if answer == nil || answer.Role != RoleAI || answer.ParentID == "" {
+		return "", "", ErrNoAnswer
+	}
+	question := tree.messages[answer.ParentID]
+	return question.ParentID, question.Content, nil
+}
+
+// EditPoint returns where an edited user message branches off, for asking the edited question
+// with WithBranchFrom.
+func (am *AgentManager) EditPoint(ctx context.Context, threadID, messageID string) (string, error) {
+	am.treeMutex.Lock()
+	defer am.treeMutex.Unlock()
+
+	tree, err := am.syncThreadTree(ctx, threadID)
+	if err != nil {
+		return "", err
+	}
+	message, ok := tree.messages[messageID]
+	if !ok {
+		return "", ErrMessageNotFound
+	}
+	if message.Role != RoleUser {
+		return "", ErrNotUserMessage
+	}
+	return message.ParentID, nil
+}
+
+// branchThread rewinds the thread's chat history to the given message, so the query answers
+// on a new branch. It returns the previous leaf, to restore if the query fails.
+func (am *AgentManager) branchThread(ctx context.Context, threadID, parentID string) (string, error) {
+	am.treeMutex.Lock()
+	defer am.treeMutex.Unlock()
+
+	tree, err := am.syncThreadTree(ctx, threadID)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := tree.messages[parentID]; parentID != "" && !ok {
+		return "", ErrMessageNotFound
+	}
+	previousLeaf := tree.leaf
+	if err := am.setActiveBranch(ctx, threadID, tree, parentID); err != nil {
+		return "", err
+	}
+	return previousLeaf, nil
+}
+
+// restoreBranch makes the given leaf active again, dropping what a failed query left in the
+// chat history.
+func (am *AgentManager) restoreBranch(ctx context.Context, threadID, leaf string) {
+	am.treeMutex.Lock()
+	defer am.treeMutex.Unlock()
+
+	if tree := am.threadTrees[threadID]; tree != nil {
+		if err := am.setActiveBranch(ctx, threadID, tree, leaf); err != nil {
+			log := logger.GetLogger()
+			log.Error().Err(err).Msgf("Failed to restore the active branch of thread %s.", threadID)
+		}
+	}
+}
+
+func copyMessages(messages []*ThreadMessage) []ThreadMessage {
+	copied := make([]ThreadMessage, 0, len(messages))
+	for _, message := range messages {
+		copied = append(copied, *message)
+	}
+	return copied
+}
@@ -0,0 +1,268 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/memory"
+)
+
+const branchTestThread = "t1"
+
+// askInThread appends a question and its answer to the thread's chat history, as a query does.
+func askInThread(t *testing.T, am *AgentManager, question, answer string) {
+	t.Helper()
+
+	ctx := context.Background()
+	history := am.GetThreadMemory(branchTestThread).ChatHistory
+	if err := history.AddUserMessage(ctx, question); err != nil {
+		t.Fatalf("AddUserMessage: %v", err)
+	}
+	if err := history.AddAIMessage(ctx, answer); err != nil {
+		t.Fatalf("AddAIMessage: %v", err)
+	}
+}
+
+// regenerateInThread answers the last question again on a new branch, as the regenerate
+// route does.
+func regenerateInThread(t *testing.T, am *AgentManager, answer string) {
+	t.Helper()
+
+	ctx := context.Background()
+	parentID, question, err := am.RegenerationPoint(ctx, branchTestThread)
+	if err != nil {
+		t.Fatalf("RegenerationPoint: %v", err)
+	}
+	if _, err := am.branchThread(ctx, branchTestThread, parentID); err != nil {
+		t.Fatalf("branchThread: %v", err)
+	}
+	askInThread(t, am, question, answer)
+}
+
+// editInThread asks an edited version of a past question on a new branch, as the edit route does.
+func editInThread(t *testing.T, am *AgentManager, original, question, answer string) {
+	t.Helper()
+
+	ctx := context.Background()
+	parentID, err := am.EditPoint(ctx, branchTestThread, treeMessageID(t, am, original))
+	if err != nil {
+		t.Fatalf("EditPoint: %v", err)
+	}
+	if _, err := am.branchThread(ctx, branchTestThread, parentID); err != nil {
+		t.Fatalf("branchThread: %v", err)
+	}
+	askInThread(t, am, question, answer)
+}
+
+// treeMessageID returns the ID of the latest tree message with the given content.
+func treeMessageID(t *testing.T, am *AgentManager, content string) string {
+	t.Helper()
+
+	messages, _, err := am.ThreadTree(context.Background(), branchTestThread)
+	if err != nil {
+		t.Fatalf("ThreadTree: %v", err)
+	}
+	id := ""
+	for _, message := range messages {
+		if message.Content == content {
+			id = message.ID
+		}
+	}
+	if id == "" {
+		t.Fatalf("no message %q in the tree", content)
+	}
+	return id
+}
+
+func TestThreadBranches(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, am *AgentManager)
+		// Contents of the active branch and the number of messages in the tree
+		wantBranch   []string
+		wantMessages int
+		// Contents of messages that must share a parent
+		wantSiblings []string
+	}{
+		{
+			name: "linear history",
+			run: func(t *testing.T, am *AgentManager) {
+				askInThread(t, am, "q1", "a1")
+				askInThread(t, am, "q2", "a2")
+			},
+			wantBranch:   []string{"q1", "a1", "q2", "a2"},
+			wantMessages: 4,
+		},
+		{
+			name: "regenerate adds a sibling answer",
+			run: func(t *testing.T, am *AgentManager) {
+				askInThread(t, am, "q1", "a1")
+				askInThread(t, am, "q2", "a2")
+				regenerateInThread(t, am, "a2 again")
+			},
+			wantBranch:   []string{"q1", "a1", "q2", "a2 again"},
+			wantMessages: 5,
+			wantSiblings: []string{"a2", "a2 again"},
+		},
+		{
+			name: "edit adds a sibling question",
+			run: func(t *testing.T, am *AgentManager) {
+				askInThread(t, am, "q1", "a1")
+				askInThread(t, am, "q2", "a2")
+				editInThread(t, am, "q2", "q2 edited", "a2 edited")
+			},
+			wantBranch:   []string{"q1", "a1", "q2 edited", "a2 edited"},
+			wantMessages: 6,
+			wantSiblings: []string{"q2", "q2 edited"},
+		},
+		{
+			name: "edit of the first question starts a new root",
+			run: func(t *testing.T, am *AgentManager) {
+				askInThread(t, am, "q1", "a1")
+				editInThread(t, am, "q1", "q1 edited", "a1 edited")
+			},
+			wantBranch:   []string{"q1 edited", "a1 edited"},
+			wantMessages: 4,
+			wantSiblings: []string{"q1", "q1 edited"},
+		},
+		{
+			name: "activate follows the latest leaf",
+			run: func(t *testing.T, am *AgentManager) {
+				askInThread(t, am, "q1", "a1")
+				askInThread(t, am, "q2", "a2")
+				regenerateInThread(t, am, "a2 again")
+				editInThread(t, am, "q1", "q1 edited", "a1 edited")
+				if _, err := am.ActivateBranch(context.Background(), branchTestThread, treeMessageID(t, am, "q1")); err != nil {
+					t.Fatalf("ActivateBranch: %v", err)
+				}
+			},
+			wantBranch:   []string{"q1", "a1", "q2", "a2 again"},
+			wantMessages: 7,
+		},
+		{
+			name: "activate an earlier answer",
+			run: func(t *testing.T, am *AgentManager) {
+				askInThread(t, am, "q1", "a1")
+				regenerateInThread(t, am, "a1 again")
+				if _, err := am.ActivateBranch(context.Background(), branchTestThread, treeMessageID(t, am, "a1")); err != nil {
+					t.Fatalf("ActivateBranch: %v", err)
+				}
+			},
+			wantBranch:   []string{"q1", "a1"},
+			wantMessages: 3,
+		},
+		{
+			name: "restore after a failed query",
+			run: func(t *testing.T, am *AgentManager) {
+				ctx := context.Background()
+				askInThread(t, am, "q1", "a1")
+				askInThread(t, am, "q2", "a2")
+
+				// The regenerated query fails after its question reached the chat history
+				parentID, question, err := am.RegenerationPoint(ctx, branchTestThread)
+				if err != nil {
+					t.Fatalf("RegenerationPoint: %v", err)
+				}
+				previousLeaf, err := am.branchThread(ctx, branchTestThread, parentID)
+				if err != nil {
+					t.Fatalf("branchThread: %v", err)
+				}
+				if err := am.GetThreadMemory(branchTestThread).ChatHistory.AddUserMessage(ctx, question); err != nil {
+					t.Fatalf("AddUserMessage: %v", err)
+				}
+				am.restoreBranch(ctx, branchTestThread, previousLeaf)
+			},
+			wantBranch:   []string{"q1", "a1", "q2", "a2"},
+			wantMessages: 4,
+		},
+		{
+			name: "tool calls and results belong to the answer",
+			run: func(t *testing.T, am *AgentManager) {
+				ctx := context.Background()
+				history := am.GetThreadMemory(branchTestThread).ChatHistory
+				messages := []llms.ChatMessage{
+					llms.HumanChatMessage{Content: "q1"},
+					llms.AIChatMessage{ToolCalls: []llms.ToolCall{{ID: "c1", Type: "function", FunctionCall: &llms.FunctionCall{Name: "calculator", Arguments: `{"input":"2+2"}`}}}},
+					llms.ToolChatMessage{ID: "c1", Content: "4"},
+					llms.AIChatMessage{Content: "a1"},
+				}
+				for _, message := range messages {
+					if err := history.AddMessage(ctx, message); err != nil {
+						t.Fatalf("AddMessage: %v", err)
+					}
+				}
+			},
+			wantBranch:   []string{"q1", "a1"},
+			wantMessages: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			am := &AgentManager{AgentMemory: map[string]*memory.ConversationBuffer{}}
+			tt.run(t, am)
+
+			ctx := context.Background()
+			messages, leafID, err := am.ThreadTree(ctx, branchTestThread)
+			if err != nil {
+				t.Fatalf("ThreadTree: %v", err)
+			}
+			if len(messages) != tt.wantMessages {
+				t.Errorf("tree has %d messages, want %d", len(messages), tt.wantMessages)
+			}
+			parents := make(map[string]string)
+			for _, message := range messages {
+				parents[message.Content] = message.ParentID
+			}
+			for _, content := range tt.wantSiblings {
+				if parent, ok := parents[content]; !ok || parent != parents[tt.wantSiblings[0]] {
+					t.Errorf("%q is not a sibling of %q", content, tt.wantSiblings[0])
+				}
+			}
+
+			branch, err := am.ThreadBranch(ctx, branchTestThread, leafID)
+			if err != nil {
+				t.Fatalf("ThreadBranch: %v", err)
+			}
+			var contents []string
+			for _, message := range branch {
+				contents = append(contents, message.Content)
+			}
+			if !reflect.DeepEqual(contents, tt.wantBranch) {
+				t.Errorf("active branch is %q, want %q", contents, tt.wantBranch)
+			}
+
+			// The chat history holds the active branch
+			history, err := am.GetThreadMemory(branchTestThread).ChatHistory.Messages(ctx)
+			if err != nil {
+				t.Fatalf("Messages: %v", err)
+			}
+			if want := len(am.threadTrees[branchTestThread].chatMessages(leafID)); len(history) != want {
+				t.Errorf("chat history has %d messages, want %d", len(history), want)
+			}
+		})
+	}
+}
+
+func TestThreadBranchErrors(t *testing.T) {
+	ctx := context.Background()
+	am := &AgentManager{AgentMemory: map[string]*memory.ConversationBuffer{}}
+
+	if _, _, err := am.RegenerationPoint(ctx, branchTestThread); !errors.Is(err, ErrNoAnswer) {
+		t.Errorf("RegenerationPoint on an empty thread returned %v, want ErrNoAnswer", err)
+	}
+
+	askInThread(t, am, "q1", "a1")
+	if _, err := am.EditPoint(ctx, branchTestThread, treeMessageID(t, am, "a1")); !errors.Is(err, ErrNotUserMessage) {
+		t.Errorf("EditPoint on an answer returned %v, want ErrNotUserMessage", err)
+	}
+	if _, err := am.EditPoint(ctx, branchTestThread, "missing"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("EditPoint on a missing message returned %v, want ErrMessageNotFound", err)
+	}
+	if _, err := am.ActivateBranch(ctx, branchTestThread, "missing"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("ActivateBranch on a missing message returned %v, want ErrMessageNotFound", err)
+	}
+}
@@ -0,0 +1,136 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/vectorstores"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+)
+
+// SourceCorrection is the source of the Q&A documents curated from corrected answers.
+const SourceCorrection = "correction"
+
+// Correction retrieval defaults.
+const (
+	defaultCorrectionTopK  = 2
+	defaultCorrectionBoost = 1.5
+)
+
+// ErrNoCorrection is returned when feedback without a correction is added to the knowledge base.
+var ErrNoCorrection = errors.New("feedback has no correction")
+
+// AddCorrection turns the question of the feedback's answer and its corrected answer into a
+// Q&A document in the org's namespace. The answer must have been given to the user in the org.
+// It returns the document ID.
+func (am *AgentManager) AddCorrection(ctx context.Context, userID, orgID string, feedback Feedback) (string, error) {
+	log := logger.GetLogger()
+
+	if feedback.Answer.UserID != userID || feedback.Answer.OrgID != orgID || feedback.OrgID != orgID {
+		return "", ErrAnswerNotOwned
+	}
+	correction := strings.TrimSpace(feedback.Correction)
+	if correction == "" {
+		return "", ErrNoCorrection
+	}
+
+	doc := schema.Document{
+		PageContent: fmt.Sprintf("Question: %s\nAnswer: %s", feedback.Answer.Question, correction),
+		Metadata: map[string]any{
+			"source":      SourceCorrection,
+			"org_id":      feedback.OrgID,
+			"user_id":     feedback.UserID,
+			"thread_id":   feedback.Answer.ThreadID,
+			"feedback_id": feedback.ID,
+			"timestamp":   time.Now().Format(time.RFC3339),
+		},
+	}
+	chunks := ChunkDocuments([]schema.Document{doc}, am.ChunkWords)
+	if _, err := am.VectorStore.AddDocuments(ctx, chunks, vectorstores.WithNameSpace(feedback.OrgID)); err != nil {
+		return "", fmt.Errorf("failed to add correction: %w", err)
+	}
+
+	documentID, _ := chunks[0].Metadata["parent_id"].(string)
+	log.Info().Msgf("Added correction %s from feedback %s to the knowledge base of org %s", documentID, feedback.ID, feedback.OrgID)
+	am.Events.Publish(Event{
+		Type:  EventIngestionComplete,
+		OrgID: feedback.OrgID,
+		Data:  map[string]any{"user_id": feedback.UserID, "documents": 1, "source": SourceCorrection},
+	})
+	return documentID, nil
+}
+
+// retrieveCorrections searches the org's curated corrections apart from its other documents,
+// so they are candidates even when other documents are closer to the input.
+func (am *AgentManager) retrieveCorrections(ctx context.Context, orgID, input string, opts QueryOptions) ([]schema.Document, error) {
+	log := logger.GetLogger()
+
+	if !opts.Retrieval.KnowledgeEnabled || opts.Retrieval.CorrectionTopK <= 0 {
+		return nil, nil
+	}
+	// Corrections live in the org namespace, which a sub-agent may not search
+	searchesOrg := false
+	for _, namespace := range knowledgeNamespaces(orgID, opts.namespaces) {
+		searchesOrg = searchesOrg || namespace == orgID
+	}
+	if !searchesOrg {
+		return nil, nil
+	}
+
+	where := filters.Where().WithPath([]string{"source"}).WithOperator(filters.Equal).WithValueString(SourceCorrection)
+	if opts.Filter != nil {
+		where = filters.Where().WithOperator(filters.And).WithOperands([]*filters.WhereBuilder{where, opts.Filter.ToWeaviate()})
+	}
+
+	log.Debug().Msgf("Searching corrections in namespace: %s", orgID)
+	docs, err := am.VectorStore.SimilaritySearch(ctx, input, opts.Retrieval.CorrectionTopK,
+		vectorstores.WithNameSpace(orgID),
+		vectorstores.WithFilters(where),
+	)
+	if err != nil && err.Error() != "empty response" {
+		log.Error().Err(err).Msg("Failed to search corrections.")
+		return nil, fmt.Errorf("correction search failed: %w", err)
+	}
+	return docs, nil
+}
+
+// mergeCorrections adds the corrections not already among the documents, boosts the score of
+// every correction and sorts the documents by score.
+func mergeCorrections(docs, corrections []schema.Document, boost float64) []schema.Document {
+	seen := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		seen[documentKey(doc)] = true
+	}
+	for _, doc := range corrections {
+		if !seen[documentKey(doc)] {
+			docs = append(docs, doc)
+		}
+	}
+	if boost <= 0 || len(docs) == 0 {
+		return docs
+	}
+
+	for i := range docs {
+		if source, _ := docs[i].Metadata["source"].(string); source == SourceCorrection {
+			docs[i].Score *= float32(boost)
+		}
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Score > docs[j].Score
+	})
+	return docs
+}
+
+// documentKey identifies a chunk by its parent and position, or by its content.
+func documentKey(doc schema.Document) string {
+	if parentID, ok := doc.Metadata["parent_id"].(string); ok && parentID != "" {
+		return fmt.Sprintf("%s#%v", parentID, doc.Metadata["chunk_index"])
+	}
+	return doc.PageContent
+}
@@ -0,0 +1,94 @@
+package agents
+
+import (
+	"sync"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/logger"
+)
+
+// Event types published by the agent. Other types can be published through the events API.
+const (
+	EventIngestionComplete = "ingestion_complete"
+	EventHandoff           = "handoff"
+)
+
+// eventBufferSize is how many events a slow subscriber may fall behind before events are dropped.
+const eventBufferSize = 32
+
+// Event is pushed to the clients connected to a thread. An event without a thread goes to
+// every connected thread of the org.
+type Event struct {
+	Type      string    `json:"type"`
+	OrgID     string    `json:"org_id"`
+	ThreadID  string    `json:"thread_id,omitempty"`
+	Data      any       `json:"data,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EventBus delivers events to the subscribers of a thread or org.
+type EventBus struct {
+	mutex       sync.Mutex
+	nextID      int
+	subscribers map[int]eventSubscriber
+}
+
+type eventSubscriber struct {
+	orgID    string
+	threadID string
+	events   chan Event
+}
+
+// NewEventBus creates an event bus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[int]eventSubscriber)}
+}
+
+// Subscribe receives the events of a thread and the org-wide events of its org. The returned
+// function unsubscribes and closes the channel.
+func (b *EventBus) Subscribe(orgID, threadID string) (<-chan Event, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	events := make(chan Event, eventBufferSize)
+	b.subscribers[id] = eventSubscriber{orgID: orgID, threadID: threadID, events: events}
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+
+			delete(b.subscribers, id)
+			close(events)
+		})
+	}
+}
+
+// Publish delivers the event without blocking; subscribers that fall behind miss it.
+func (b *EventBus) Publish(event Event) {
+	log := logger.GetLogger()
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, subscriber := range b.subscribers {
+		if subscriber.orgID != event.OrgID {
+			continue
+		}
+		if event.ThreadID != "" && subscriber.threadID != event.ThreadID {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			log.Warn().Msgf("Dropped %s event for thread %s: subscriber is behind.", event.Type, subscriber.threadID)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/google/uuid"
+)
+
+// feedbackFile is the file under the data directory holding the feedback on answers.
+const feedbackFile = "feedback.json"
+
+// Feedback values and limits.
+const (
+	ThumbsUp   = "up"
+	ThumbsDown = "down"
+
+	minFeedbackRating        = 1
+	maxFeedbackRating        = 5
+	maxFeedbackCommentLength = 4000
+	maxFeedbackCorrection    = 16000
+
+	// Answers can be given feedback for answerRecordTTL, and at most maxAnswerRecords are
+	// kept; the oldest are dropped first.
+	answerRecordTTL  = 24 * time.Hour
+	maxAnswerRecords = 10000
+)
+
+var (
+	// ErrAnswerNotFound is returned for feedback on a message that is not a recorded answer.
+	ErrAnswerNotFound = errors.New("answer not found")
+	// ErrAnswerNotOwned is returned when a user or org uses an answer given to another.
+	ErrAnswerNotOwned = errors.New("answer belongs to another user")
+)
+
+// AnswerRecord is an AI turn with what produced it: the question, the retrieved sources, the
+// model and the prompt versions.
+type AnswerRecord struct {
+	MessageID      string    `json:"message_id"`
+	ThreadID       string    `json:"thread_id"`
+	UserID         string    `json:"user_id"`
+	OrgID          string    `json:"org_id"`
+	Question       string    `json:"question"`
+	Answer         string    `json:"answer"`
+	Sources        []Source  `json:"sources"`
+	Model          string    `json:"model,omitempty"`
+	Agent          string    `json:"agent,omitempty"`
+	PromptVersions []string  `json:"prompt_versions,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Feedback is a user's judgement of an answer. It keeps a copy of the answer record, so it
+// can be analysed after the thread is gone.
+type Feedback struct {
+	ID         string       `json:"id"`
+	MessageID  string       `json:"message_id"`
+	OrgID      string       `json:"org_id"`
+	UserID     string       `json:"user_id,omitempty"`
+	Thumbs     string       `json:"thumbs,omitempty"`
+	Rating     *int         `json:"rating,omitempty"`
+	Comment    string       `json:"comment,omitempty"`
+	Correction string       `json:"correction,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+	Answer     AnswerRecord `json:"answer"`
+
+	// The Q&A document the correction was turned into, if any
+	KnowledgeDocumentID string `json:"knowledge_document_id,omitempty"`
+}
+
+// Validate checks that the feedback says something and that its fields are within limits.
+func (f Feedback) Validate() error {
+	if f.Thumbs == "" && f.Rating == nil && strings.TrimSpace(f.Comment) == "" && strings.TrimSpace(f.Correction) == "" {
+		return fmt.Errorf("feedback needs a 'thumbs', 'rating', 'comment' or 'correction'")
+	}
+	if f.Thumbs != "" && f.Thumbs != ThumbsUp && f.Thumbs != ThumbsDown {
+		return fmt.Errorf("'thumbs' must be '%s' or '%s'", ThumbsUp, ThumbsDown)
+	}
+	if f.Rating != nil && (*f.Rating < minFeedbackRating || *f.Rating > maxFeedbackRating) {
+		return fmt.Errorf("'rating' must be between %d and %d", minFeedbackRating, maxFeedbackRating)
+	}
+	if len(f.Comment) > maxFeedbackCommentLength {
+		return fmt.Errorf("'comment' must be at most %d characters", maxFeedbackCommentLength)
+	}
+	if len(f.Correction) > maxFeedbackCorrection {
+		return fmt.Errorf("'correction' must be at most %d characters", maxFeedbackCorrection)
+	}
+	return nil
+}
+
+// FeedbackFilter selects an org's feedback. Zero fields match everything.
+type FeedbackFilter struct {
+	ThreadID      string
+	Thumbs        string
+	MaxRating     int
+	HasCorrection bool
+	PromptVersion string
+	Model         string
+	Since         time.Time
+	Limit         int
+}
+
+func (f FeedbackFilter) matches(feedback Feedback) bool {
+	switch {
+	case f.ThreadID != "" && feedback.Answer.ThreadID != f.ThreadID,
+		f.Thumbs != "" && feedback.Thumbs != f.Thumbs,
+		f.MaxRating > 0 && (feedback.Rating == nil || *feedback.Rating > f.MaxRating),
+		f.HasCorrection && feedback.Correction == "",
+		f.Model != "" && feedback.Answer.Model != f.Model,
+		!f.Since.IsZero() && feedback.CreatedAt.Before(f.Since):
+		return false
+	}
+	if f.PromptVersion != "" {
+		for _, version := range feedback.Answer.PromptVersions {
+			if version == f.PromptVersion {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// FeedbackSummary counts the feedback matching a filter.
+type FeedbackSummary struct {
+	Total         int     `json:"total"`
+	ThumbsUp      int     `json:"thumbs_up"`
+	ThumbsDown    int     `json:"thumbs_down"`
+	Rated         int     `json:"rated"`
+	AverageRating float64 `json:"average_rating"`
+	Corrections   int     `json:"corrections"`
+}
+
+// FeedbackStore keeps the recorded answers and the feedback on them. Feedback is persisted to
+// a JSON file when the store has a data directory. Answers are kept in memory only, for
+// answerRecordTTL and up to maxAnswerRecords, so they are lost on restart; feedback keeps its
+// own copy of the answer.
+type FeedbackStore struct {
+	path        string
+	mutex       sync.RWMutex
+	answers     map[string]AnswerRecord
+	answerOrder []string // message IDs, oldest first
+	feedback    []Feedback
+}
+
+// NewFeedbackStore loads the feedback kept in the data directory. An empty directory keeps
+// it in memory only.
+func NewFeedbackStore(dataDir string) (*FeedbackStore, error) {
+	store := &FeedbackStore{answers: make(map[string]AnswerRecord)}
+	if dataDir == "" {
+		return store, nil
+	}
+
+	store.path = filepath.Join(dataDir, feedbackFile)
+	if err := readJSONFile(store.path, &store.feedback); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// RecordAnswer keeps an answer so feedback can be given on it.
+func (s *FeedbackStore) RecordAnswer(answer AnswerRecord) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.answers[answer.MessageID]; !ok {
+		s.answerOrder = append(s.answerOrder, answer.MessageID)
+	}
+	s.answers[answer.MessageID] = answer
+	s.pruneAnswers()
+}
+
+// pruneAnswers drops the expired answers and the oldest ones beyond the limit. The caller
+// holds the lock.
+func (s *FeedbackStore) pruneAnswers() {
+	cutoff := time.Now().Add(-answerRecordTTL)
+	dropped := 0
+	for _, messageID := range s.answerOrder {
+		answer, ok := s.answers[messageID]
+		if ok && len(s.answerOrder)-dropped <= maxAnswerRecords && !answer.CreatedAt.Before(cutoff) {
+			break
+		}
+		delete(s.answers, messageID)
+		dropped++
+	}
+	if dropped > 0 {
+		s.answerOrder = append([]string(nil), s.answerOrder[dropped:]...)
+	}
+}
+
+// Answer returns the recorded answer with the given message ID, unless it has expired.
+func (s *FeedbackStore) Answer(messageID string) (AnswerRecord, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.answer(messageID)
+}
+
+// UserAnswer returns the recorded answer with the given message ID if it was given to the
+// user in the org.
+func (s *FeedbackStore) UserAnswer(userID, orgID, messageID string) (AnswerRecord, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.userAnswer(userID, orgID, messageID)
+}
+
+// userAnswer looks up an answer that has not expired and checks that it was given to the
+// user in the org. The caller holds the lock.
+func (s *FeedbackStore) userAnswer(userID, orgID, messageID string) (AnswerRecord, error) {
+	answer, ok := s.answer(messageID)
+	if !ok {
+		return AnswerRecord{}, ErrAnswerNotFound
+	}
+	if answer.UserID != userID || answer.OrgID != orgID {
+		return AnswerRecord{}, ErrAnswerNotOwned
+	}
+	return answer, nil
+}
+
+// answer looks up an answer that has not expired. The caller holds the lock.
+func (s *FeedbackStore) answer(messageID string) (AnswerRecord, bool) {
+	answer, ok := s.answers[messageID]
+	if !ok || time.Since(answer.CreatedAt) > answerRecordTTL {
+		return AnswerRecord{}, false
+	}
+	return answer, true
+}
+
+// Submit validates and stores the user's feedback on the answer with the given message ID,
+// which must have been given to the user in the org.
+func (s *FeedbackStore) Submit(userID, orgID, messageID string, feedback Feedback) (Feedback, error) {
+	if err := feedback.Validate(); err != nil {
+		return Feedback{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	answer, err := s.userAnswer(userID, orgID, messageID)
+	if err != nil {
+		return Feedback{}, err
+	}
+	feedback.ID = uuid.NewString()
+	feedback.MessageID = messageID
+	feedback.OrgID = answer.OrgID
+	feedback.UserID = answer.UserID
+	feedback.CreatedAt = time.Now().UTC()
+	feedback.Answer = answer
+	feedback.KnowledgeDocumentID = ""
+
+	s.feedback = append(s.feedback, feedback)
+	if err := s.save(); err != nil {
+		s.feedback = s.feedback[:len(s.feedback)-1]
+		return Feedback{}, err
+	}
+	return feedback, nil
+}
+
+// SetKnowledgeDocument records the document a feedback's correction was turned into.
+func (s *FeedbackStore) SetKnowledgeDocument(feedbackID, documentID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := range s.feedback {
+		if s.feedback[i].ID != feedbackID {
+			continue
+		}
+		previous := s.feedback[i].KnowledgeDocumentID
+		s.feedback[i].KnowledgeDocumentID = documentID
+		if err := s.save(); err != nil {
+			s.feedback[i].KnowledgeDocumentID = previous
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("feedback %s not found", feedbackID)
+}
+
+// List returns the org's feedback matching the filter, newest first, and a summary of all of
+// it regardless of the limit.
+func (s *FeedbackStore) List(orgID string, filter FeedbackFilter) ([]Feedback, FeedbackSummary) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	matched := []Feedback{}
+	var summary FeedbackSummary
+	ratingTotal := 0
+	for _, feedback := range s.feedback {
+		if feedback.OrgID != orgID || !filter.matches(feedback) {
+			continue
+		}
+		matched = append(matched, feedback)
+
+		summary.Total++
+		switch feedback.Thumbs {
+		case ThumbsUp:
+			summary.ThumbsUp++
+		case ThumbsDown:
+			summary.ThumbsDown++
+		}
+		if feedback.Rating != nil {
+			summary.Rated++
+			ratingTotal += *feedback.Rating
+		}
+		if feedback.Correction != "" {
+			summary.Corrections++
+		}
+	}
+	if summary.Rated > 0 {
+		summary.AverageRating = float64(ratingTotal) / float64(summary.Rated)
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].CreatedAt.After(matched[j].CreatedAt) })
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[:filter.Limit]
+	}
+	return matched, summary
+}
+
+// save writes the feedback to the store's file. The caller holds the lock.
+func (s *FeedbackStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	return writeJSONFile(s.path, s.feedback)
+}
+
+// recordAnswer gives the thread's latest answer its message ID and keeps it for feedback. The
+// ID is passed to the query's message callback.
+func (am *AgentManager) recordAnswer(ctx context.Context, answer AnswerRecord, opts QueryOptions) {
+	log := logger.GetLogger()
+
+	messageID, err := am.activeMessageID(ctx, answer.ThreadID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to identify the answer of thread %s.", answer.ThreadID)
+		return
+	}
+	answer.MessageID = messageID
+	answer.CreatedAt = time.Now().UTC()
+	if am.Feedback != nil {
+		am.Feedback.RecordAnswer(answer)
+	}
+	if opts.MessageCallback != nil {
+		opts.MessageCallback(messageID)
+	}
+}
+
+// promptVersionIDs lists the IDs of the prompt versions selected for a query.
+func promptVersionIDs(prompts queryPrompts) []string {
+	ids := make([]string, 0, len(prompts))
+	for _, version := range prompts {
+		ids = append(ids, version.ID())
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,113 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFeedbackStoreExpiresAnswers(t *testing.T) {
+	store, err := NewFeedbackStore("")
+	if err != nil {
+		t.Fatalf("NewFeedbackStore: %v", err)
+	}
+
+	store.RecordAnswer(AnswerRecord{MessageID: "old", UserID: "alice", OrgID: "acme", CreatedAt: time.Now().Add(-answerRecordTTL - time.Minute)})
+	store.RecordAnswer(AnswerRecord{MessageID: "new", UserID: "alice", OrgID: "acme", CreatedAt: time.Now()})
+
+	if _, ok := store.Answer("old"); ok {
+		t.Fatal("expired answer is still returned")
+	}
+	if _, err := store.Submit("alice", "acme", "old", Feedback{Thumbs: ThumbsUp}); !errors.Is(err, ErrAnswerNotFound) {
+		t.Fatalf("expected ErrAnswerNotFound for an expired answer, got %v", err)
+	}
+	if _, err := store.Submit("alice", "acme", "new", Feedback{Thumbs: ThumbsUp}); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if len(store.answers) != 1 {
+		t.Fatalf("expected the expired answer to be pruned, %d answers kept", len(store.answers))
+	}
+}
+
+func TestFeedbackStoreChecksAnswerOwner(t *testing.T) {
+	store, err := NewFeedbackStore("")
+	if err != nil {
+		t.Fatalf("NewFeedbackStore: %v", err)
+	}
+	store.RecordAnswer(AnswerRecord{MessageID: "m1", UserID: "alice", OrgID: "acme", CreatedAt: time.Now()})
+
+	tests := []struct {
+		name    string
+		userID  string
+		orgID   string
+		wantErr error
+	}{
+		{name: "owner", userID: "alice", orgID: "acme"},
+		{name: "other user", userID: "bob", orgID: "acme", wantErr: ErrAnswerNotOwned},
+		{name: "other org", userID: "alice", orgID: "globex", wantErr: ErrAnswerNotOwned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := store.UserAnswer(tt.userID, tt.orgID, "m1"); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UserAnswer returned %v, want %v", err, tt.wantErr)
+			}
+			feedback, err := store.Submit(tt.userID, tt.orgID, "m1", Feedback{Thumbs: ThumbsUp, UserID: "mallory"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Submit returned %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (feedback.UserID != "alice" || feedback.OrgID != "acme") {
+				t.Fatalf("feedback is from %s in %s, want alice in acme", feedback.UserID, feedback.OrgID)
+			}
+		})
+	}
+}
+
+func TestFeedbackStoreLimitsAnswers(t *testing.T) {
+	store, err := NewFeedbackStore("")
+	if err != nil {
+		t.Fatalf("NewFeedbackStore: %v", err)
+	}
+
+	for i := 0; i < maxAnswerRecords+5; i++ {
+		store.RecordAnswer(AnswerRecord{MessageID: fmt.Sprintf("m%d", i), CreatedAt: time.Now()})
+	}
+	if len(store.answers) != maxAnswerRecords || len(store.answerOrder) != maxAnswerRecords {
+		t.Fatalf("expected %d answers, got %d (%d ordered)", maxAnswerRecords, len(store.answers), len(store.answerOrder))
+	}
+	if _, ok := store.Answer("m4"); ok {
+		t.Fatal("oldest answer was not dropped")
+	}
+	if _, ok := store.Answer("m5"); !ok {
+		t.Fatal("answer within the limit was dropped")
+	}
+}
+
+func TestAddCorrectionChecksAnswerOwner(t *testing.T) {
+	am := &AgentManager{}
+	feedback := Feedback{
+		OrgID:      "acme",
+		UserID:     "alice",
+		Correction: "Annual plans are refundable within 30 days.",
+		Answer:     AnswerRecord{MessageID: "m1", UserID: "alice", OrgID: "acme"},
+	}
+
+	tests := []struct {
+		name   string
+		userID string
+		orgID  string
+	}{
+		{name: "other user", userID: "bob", orgID: "acme"},
+		{name: "other org", userID: "alice", orgID: "globex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := am.AddCorrection(context.Background(), tt.userID, tt.orgID, feedback); !errors.Is(err, ErrAnswerNotOwned) {
+				t.Fatalf("AddCorrection returned %v, want ErrAnswerNotOwned", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package agents
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+)
+
+// Filter operators accepted in a MetadataFilter.
+const (
+	FilterOpEq  = "eq"
+	FilterOpNe  = "ne"
+	FilterOpGt  = "gt"
+	FilterOpGte = "gte"
+	FilterOpLt  = "lt"
+	FilterOpLte = "lte"
+	FilterOpIn  = "in"
+)
+
+// MetadataFilter is a store-agnostic filter expression on document metadata.
+// A node is either a combinator (And, Or, Not) or a leaf comparing Field to Value with Op.
+type MetadataFilter struct {
+	And   []MetadataFilter `json:"and,omitempty"`
+	Or    []MetadataFilter `json:"or,omitempty"`
+	Not   *MetadataFilter  `json:"not,omitempty"`
+	Field string           `json:"field,omitempty"`
+	Op    string           `json:"op,omitempty"`
+	Value any              `json:"value,omitempty"`
+}
+
+// Validate checks that the filter expression is well formed.
+func (f *MetadataFilter) Validate() error {
+	combinators := 0
+	if len(f.And) > 0 {
+		combinators++
+	}
+	if len(f.Or) > 0 {
+		combinators++
+	}
+	if f.Not != nil {
+		combinators++
+	}
+
+	if combinators > 1 {
+		return fmt.Errorf("filter node must use only one of 'and', 'or' or 'not'")
+	}
+	if combinators == 1 {
+		if f.Field != "" || f.Op != "" || f.Value != nil {
+			return fmt.Errorf("filter node cannot combine 'and', 'or' or 'not' with a field comparison")
+		}
+		for i := range f.And {
+			if err := f.And[i].Validate(); err != nil {
+				return err
+			}
+		}
+		for i := range f.Or {
+			if err := f.Or[i].Validate(); err != nil {
+				return err
+			}
+		}
+		if f.Not != nil {
+			return f.Not.Validate()
+		}
+		return nil
+	}
+
+	if f.Field == "" {
+		return fmt.Errorf("filter node requires a 'field'")
+	}
+
+	switch f.Op {
+	case FilterOpEq, FilterOpNe:
+		if !isScalarFilterValue(f.Value) {
+			return fmt.Errorf("filter on '%s': '%s' requires a string, number or boolean value", f.Field, f.Op)
+		}
+	case FilterOpGt, FilterOpGte, FilterOpLt, FilterOpLte:
+		if !isRangeFilterValue(f.Value) {
+			return fmt.Errorf("filter on '%s': '%s' requires a number or RFC3339 timestamp", f.Field, f.Op)
+		}
+	case FilterOpIn:
+		values, ok := f.Value.([]any)
+		if !ok || len(values) == 0 {
+			return fmt.Errorf("filter on '%s': 'in' requires a non-empty list", f.Field)
+		}
+		for _, v := range values {
+			if !isScalarFilterValue(v) {
+				return fmt.Errorf("filter on '%s': 'in' values must be strings, numbers or booleans", f.Field)
+			}
+		}
+	default:
+		return fmt.Errorf("filter on '%s': unsupported operator '%s'", f.Field, f.Op)
+	}
+
+	// Integer properties can only be compared to whole numbers
+	if isIntegerProperty(f.Field) {
+		values, ok := f.Value.([]any)
+		if !ok {
+			values = []any{f.Value}
+		}
+		for _, v := range values {
+			if _, whole := wholeNumber(v); !whole {
+				return fmt.Errorf("filter on '%s': value must be a whole number", f.Field)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ToWeaviate translates the filter into a Weaviate where clause.
+func (f *MetadataFilter) ToWeaviate() *filters.WhereBuilder {
+	switch {
+	case len(f.And) > 0:
+		return filters.Where().WithOperator(filters.And).WithOperands(weaviateOperands(f.And))
+	case len(f.Or) > 0:
+		return filters.Where().WithOperator(filters.Or).WithOperands(weaviateOperands(f.Or))
+	case f.Not != nil:
+		return filters.Where().WithOperator(filters.Not).WithOperands([]*filters.WhereBuilder{f.Not.ToWeaviate()})
+	}
+
+	if f.Op == FilterOpIn {
+		values := f.Value.([]any)
+		operands := make([]*filters.WhereBuilder, 0, len(values))
+		for _, v := range values {
+			operands = append(operands, weaviateComparison(f.Field, filters.Equal, v))
+		}
+		return filters.Where().WithOperator(filters.Or).WithOperands(operands)
+	}
+
+	operators := map[string]filters.WhereOperator{
+		FilterOpEq:  filters.Equal,
+		FilterOpNe:  filters.NotEqual,
+		FilterOpGt:  filters.GreaterThan,
+		FilterOpGte: filters.GreaterThanEqual,
+		FilterOpLt:  filters.LessThan,
+		FilterOpLte: filters.LessThanEqual,
+	}
+	return weaviateComparison(f.Field, operators[f.Op], f.Value)
+}
+
+func weaviateOperands(nodes []MetadataFilter) []*filters.WhereBuilder {
+	operands := make([]*filters.WhereBuilder, 0, len(nodes))
+	for i := range nodes {
+		operands = append(operands, nodes[i].ToWeaviate())
+	}
+	return operands
+}
+
+// weaviateComparison builds a single comparison, picking the value type from the Go value.
+// Strings that parse as RFC3339 are compared as dates, matching how timestamps are stored,
+// and numbers compared to integer properties are sent as integers, which Weaviate requires.
+func weaviateComparison(field string, operator filters.WhereOperator, value any) *filters.WhereBuilder {
+	where := filters.Where().WithPath([]string{field}).WithOperator(operator)
+	if n, ok := wholeNumber(value); ok && isIntegerProperty(field) {
+		return where.WithValueInt(n)
+	}
+	switch v := value.(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return where.WithValueDate(t)
+		}
+		return where.WithValueString(v)
+	case float64:
+		return where.WithValueNumber(v)
+	case int:
+		return where.WithValueNumber(float64(v))
+	case bool:
+		return where.WithValueBoolean(v)
+	}
+	return where
+}
+
+// isIntegerProperty reports whether the field is a document property stored as an integer.
+func isIntegerProperty(field string) bool {
+	for _, property := range documentProperties {
+		if property.Name == field {
+			return len(property.DataType) == 1 && property.DataType[0] == "int"
+		}
+	}
+	return false
+}
+
+// wholeNumber returns a numeric value as an integer, if it has no fractional part.
+func wholeNumber(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return int64(v), true
+		}
+	}
+	return 0, false
+}
+
+func isScalarFilterValue(value any) bool {
+	switch value.(type) {
+	case string, float64, int, bool:
+		return true
+	}
+	return false
+}
+
+func isRangeFilterValue(value any) bool {
+	switch v := value.(type) {
+	case float64, int:
+		return true
+	case string:
+		_, err := time.Parse(time.RFC3339, v)
+		return err == nil
+	}
+	return false
+}
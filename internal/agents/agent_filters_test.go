@@ -0,0 +1,123 @@
+package agents
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func decodeFilter(t *testing.T, raw string) MetadataFilter {
+	t.Helper()
+	var f MetadataFilter
+	if err := json.Unmarshal([]byte(raw), &f); err != nil {
+		t.Fatalf("decode filter %s: %v", raw, err)
+	}
+	return f
+}
+
+func TestMetadataFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		wantErr string
+	}{
+		{name: "eq string", filter: `{"field":"source","op":"eq","value":"wiki"}`},
+		{name: "ne bool", filter: `{"field":"draft","op":"ne","value":true}`},
+		{name: "gte number", filter: `{"field":"chunk_index","op":"gte","value":2}`},
+		{name: "lt timestamp", filter: `{"field":"timestamp","op":"lt","value":"2024-01-02T15:04:05Z"}`},
+		{name: "in list", filter: `{"field":"source","op":"in","value":["a","b"]}`},
+		{name: "nested and or not", filter: `{"and":[{"field":"source","op":"eq","value":"a"},{"or":[{"not":{"field":"draft","op":"eq","value":true}},{"field":"chunk_index","op":"lte","value":3}]}]}`},
+		{name: "fraction on integer property", filter: `{"field":"chunk_index","op":"gt","value":1.5}`, wantErr: "must be a whole number"},
+		{name: "fraction in integer list", filter: `{"field":"chunk_index","op":"in","value":[1,2.5]}`, wantErr: "must be a whole number"},
+		{name: "fraction on other property", filter: `{"field":"score","op":"gt","value":1.5}`},
+		{name: "missing field", filter: `{"op":"eq","value":"a"}`, wantErr: "requires a 'field'"},
+		{name: "unknown operator", filter: `{"field":"source","op":"like","value":"a"}`, wantErr: "unsupported operator 'like'"},
+		{name: "eq with list", filter: `{"field":"source","op":"eq","value":["a"]}`, wantErr: "requires a string, number or boolean"},
+		{name: "eq without value", filter: `{"field":"source","op":"eq"}`, wantErr: "requires a string, number or boolean"},
+		{name: "range with plain string", filter: `{"field":"timestamp","op":"gt","value":"yesterday"}`, wantErr: "number or RFC3339 timestamp"},
+		{name: "in with scalar", filter: `{"field":"source","op":"in","value":"a"}`, wantErr: "non-empty list"},
+		{name: "in with empty list", filter: `{"field":"source","op":"in","value":[]}`, wantErr: "non-empty list"},
+		{name: "in with object value", filter: `{"field":"source","op":"in","value":[{"a":1}]}`, wantErr: "must be strings, numbers or booleans"},
+		{name: "two combinators", filter: `{"and":[{"field":"a","op":"eq","value":1}],"or":[{"field":"b","op":"eq","value":2}]}`, wantErr: "only one of"},
+		{name: "combinator with comparison", filter: `{"not":{"field":"a","op":"eq","value":1},"field":"b"}`, wantErr: "cannot combine"},
+		{name: "invalid nested operand", filter: `{"or":[{"field":"a","op":"eq","value":1},{"field":"b","op":"between","value":1}]}`, wantErr: "unsupported operator 'between'"},
+		{name: "invalid negated node", filter: `{"not":{"op":"eq","value":1}}`, wantErr: "requires a 'field'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := decodeFilter(t, tt.filter)
+			err := f.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() returned %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() returned %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetadataFilterToWeaviate(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   string
+	}{
+		{
+			name:   "eq string",
+			filter: `{"field":"source","op":"eq","value":"wiki"}`,
+			want:   `where:{operator: Equal path: ["source"] valueString: "wiki"}`,
+		},
+		{
+			name:   "ne bool",
+			filter: `{"field":"draft","op":"ne","value":false}`,
+			want:   `where:{operator: NotEqual path: ["draft"] valueBoolean: false}`,
+		},
+		{
+			name:   "gt number",
+			filter: `{"field":"chunk_index","op":"gt","value":2}`,
+			want:   `where:{operator: GreaterThan path: ["chunk_index"] valueInt: 2}`,
+		},
+		{
+			name:   "chunk_index in compares as ints",
+			filter: `{"field":"chunk_index","op":"in","value":[0,1]}`,
+			want:   `where:{operator: Or operands:[{operator: Equal path: ["chunk_index"] valueInt: 0},{operator: Equal path: ["chunk_index"] valueInt: 1}]}`,
+		},
+		{
+			name:   "whole number on other property stays a number",
+			filter: `{"field":"score","op":"gte","value":3}`,
+			want:   `where:{operator: GreaterThanEqual path: ["score"] valueNumber: 3}`,
+		},
+		{
+			name:   "lte timestamp compares as date",
+			filter: `{"field":"timestamp","op":"lte","value":"2024-01-02T15:04:05Z"}`,
+			want:   `where:{operator: LessThanEqual path: ["timestamp"] valueDate: "2024-01-02T15:04:05Z"}`,
+		},
+		{
+			name:   "in expands to or of equals",
+			filter: `{"field":"source","op":"in","value":["a","b"]}`,
+			want:   `where:{operator: Or operands:[{operator: Equal path: ["source"] valueString: "a"},{operator: Equal path: ["source"] valueString: "b"}]}`,
+		},
+		{
+			name:   "and with not",
+			filter: `{"and":[{"field":"source","op":"eq","value":"a"},{"not":{"field":"chunk_index","op":"gte","value":1}}]}`,
+			want:   `where:{operator: And operands:[{operator: Equal path: ["source"] valueString: "a"},{operator: Not operands:[{operator: GreaterThanEqual path: ["chunk_index"] valueInt: 1}]}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := decodeFilter(t, tt.filter)
+			if err := f.Validate(); err != nil {
+				t.Fatalf("Validate() returned %v", err)
+			}
+			if got := f.ToWeaviate().String(); got != tt.want {
+				t.Fatalf("ToWeaviate() = %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
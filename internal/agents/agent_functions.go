@@ -0,0 +1,223 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/memory"
+	"github.com/tmc/langchaingo/tools"
+)
+
+// toolCallingRun is the state of a tool-calling agent run, kept across approval pauses.
+type toolCallingRun struct {
+	threadID      string
+	userID        string
+	orgID         string
+	input         string
+	systemContext string
+	iterations    int
+
+	// Model and tools of the routed sub-agent
+	llm       llms.Model
+	toolNames []string
+
+	// The answer's record, completed when the run finishes
+	record AnswerRecord
+}
+
+// runToolCallingAgent answers the input with the model's native tool calling, executing the
+// requested tools until the model returns a final answer. The input, every tool call and
+// result, and the answer are recorded in the thread memory. Tools that require approval
+// pause the run with an ApprovalRequiredError.
+func (am *AgentManager) runToolCallingAgent(
+	ctx context.Context,
+	threadMemory *memory.ConversationBuffer,
+	run toolCallingRun,
+	opts QueryOptions,
+	chunkCallback func([]byte),
+) (string, error) {
+	if err := threadMemory.ChatHistory.AddUserMessage(ctx, run.input); err != nil {
+		return "", fmt.Errorf("failed to record user message: %w", err)
+	}
+	return am.continueToolCalling(ctx, threadMemory, run, opts, chunkCallback)
+}
+
+// continueToolCalling runs the tool-calling loop from the conversation recorded in the thread memory.
+func (am *AgentManager) continueToolCalling(
+	ctx context.Context,
+	threadMemory *memory.ConversationBuffer,
+	run toolCallingRun,
+	opts QueryOptions,
+	chunkCallback func([]byte),
+) (string, error) {
+	log := logger.GetLogger()
+
+	// Expose the org's tools as function definitions
+	orgTools := filterTools(am.Tools.ForOrg(run.orgID), run.toolNames)
+	toolsByName := make(map[string]tools.Tool, len(orgTools))
+	definitions := make([]llms.Tool, 0, len(orgTools))
+	for _, tool := range orgTools {
+		toolsByName[tool.Name()] = tool
+		definitions = append(definitions, llms.Tool{
+			Type: "function",
+			Function: &llms.FunctionDefinition{
+				Name:        tool.Name(),
+				Description: tool.Description(),
+				Parameters:  toolSchema(tool),
+			},
+		})
+	}
+
+	var llm llms.Model = am.LLM
+	if run.llm != nil {
+		llm = run.llm
+	}
+
+	var callOptions []llms.CallOption
+	if len(definitions) > 0 {
+		callOptions = append(callOptions, llms.WithTools(definitions))
+	}
+
+	// Build the conversation from the thread history
+	history, err := threadMemory.ChatHistory.Messages(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve thread history: %w", err)
+	}
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeSystem, run.systemContext)}
+	messages = append(messages, chatMessagesToContent(history)...)
+
+	log.Debug().Msgf("Running tool-calling agent with %d tools and max %d iterations", len(definitions), opts.MaxIterations)
+	for ; run.iterations < opts.MaxIterations; run.iterations++ {
+		response, err := llm.GenerateContent(ctx, messages, callOptions...)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate content: %w", err)
+		}
+		if len(response.Choices) == 0 {
+			return "", fmt.Errorf("model returned no choices")
+		}
+		choice := response.Choices[0]
+
+		// No tool calls means the model has answered
+		if len(choice.ToolCalls) == 0 {
+			if err := threadMemory.ChatHistory.AddAIMessage(ctx, choice.Content); err != nil {
+				return "", fmt.Errorf("failed to record answer: %w", err)
+			}
+			if chunkCallback != nil && choice.Content != "" {
+				chunkCallback([]byte(choice.Content))
+			}
+			return choice.Content, nil
+		}
+
+		// Record the requested calls before executing them
+		parts := make([]llms.ContentPart, 0, len(choice.ToolCalls))
+		for _, call := range choice.ToolCalls {
+			parts = append(parts, call)
+		}
+		messages = append(messages, llms.MessageContent{Role: llms.ChatMessageTypeAI, Parts: parts})
+		if err := threadMemory.ChatHistory.AddMessage(ctx, llms.AIChatMessage{
+			Content:   choice.Content,
+			ToolCalls: choice.ToolCalls,
+		}); err != nil {
+			return "", fmt.Errorf("failed to record tool calls: %w", err)
+		}
+
+		for i, call := range choice.ToolCalls {
+			// Pause before the first call that needs approval, keeping it and the rest for later
+			if call.FunctionCall != nil && am.Tools.RequiresApproval(run.orgID, call.FunctionCall.Name) {
+				run.iterations++
+				return "", am.pauseForApproval(ctx, run, choice.ToolCalls[i:])
+			}
+
+			result := am.executeToolCall(ctx, toolsByName, call, opts)
+			messages = append(messages, toolResultMessage(call, result))
+			if err := threadMemory.ChatHistory.AddMessage(ctx, llms.ToolChatMessage{ID: call.ID, Content: result}); err != nil {
+				return "", fmt.Errorf("failed to record tool result: %w", err)
+			}
+		}
+	}
+
+	return "", fmt.Errorf("agent did not finish within %d iterations", opts.MaxIterations)
+}
+
+// toolResultMessage returns the message reporting a tool call's result to the model.
+func toolResultMessage(call llms.ToolCall, result string) llms.MessageContent {
+	name := ""
+	if call.FunctionCall != nil {
+		name = call.FunctionCall.Name
+	}
+	return llms.MessageContent{
+		Role: llms.ChatMessageTypeTool,
+		Parts: []llms.ContentPart{llms.ToolCallResponse{
+			ToolCallID: call.ID,
+			Name:       name,
+			Content:    result,
+		}},
+	}
+}
+
+// executeToolCall runs a single tool call and returns the result shown to the model.
+// Failures are returned as text so the model can recover.
+func (am *AgentManager) executeToolCall(
+	ctx context.Context,
+	toolsByName map[string]tools.Tool,
+	call llms.ToolCall,
+	opts QueryOptions,
+) string {
+	log := logger.GetLogger()
+
+	if call.FunctionCall == nil {
+		return "error: tool call has no function"
+	}
+	name, arguments := call.FunctionCall.Name, call.FunctionCall.Arguments
+
+	if opts.StepCallback != nil {
+		opts.StepCallback(AgentStep{Type: StepTypeAction, Tool: name, Input: arguments})
+	}
+
+	var result string
+	tool, ok := toolsByName[name]
+	if !ok {
+		result = fmt.Sprintf("error: %s is not a valid tool, try another one", name)
+	} else {
+		output, err := callWithArguments(ctx, tool, arguments)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Tool %s failed.", name)
+			output = fmt.Sprintf("error: %s", err)
+		}
+		result = output
+	}
+
+	if opts.StepCallback != nil {
+		opts.StepCallback(AgentStep{Type: StepTypeObservation, Tool: name, Input: arguments, Output: result})
+	}
+	return result
+}
+
+// chatMessagesToContent converts stored chat history into messages for GenerateContent.
+func chatMessagesToContent(history []llms.ChatMessage) []llms.MessageContent {
+	messages := make([]llms.MessageContent, 0, len(history))
+	for _, msg := range history {
+		switch m := msg.(type) {
+		case llms.AIChatMessage:
+			if len(m.ToolCalls) == 0 {
+				messages = append(messages, llms.TextParts(llms.ChatMessageTypeAI, m.Content))
+				continue
+			}
+			parts := make([]llms.ContentPart, 0, len(m.ToolCalls))
+			for _, call := range m.ToolCalls {
+				parts = append(parts, call)
+			}
+			messages = append(messages, llms.MessageContent{Role: llms.ChatMessageTypeAI, Parts: parts})
+		case llms.ToolChatMessage:
+			messages = append(messages, llms.MessageContent{
+				Role:  llms.ChatMessageTypeTool,
+				Parts: []llms.ContentPart{llms.ToolCallResponse{ToolCallID: m.ID, Content: m.Content}},
+			})
+		default:
+			messages = append(messages, llms.TextParts(msg.GetType(), msg.GetContent()))
+		}
+	}
+	return messages
+}
@@ -0,0 +1,334 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/google/uuid"
+)
+
+// Generation statuses.
+const (
+	GenerationRunning   = "running"
+	GenerationCompleted = "completed"
+	GenerationCancelled = "cancelled"
+	GenerationFailed    = "failed"
+)
+
+// ErrTooManyGenerations is returned when an org already has its maximum of running generations.
+var ErrTooManyGenerations = errors.New("too many generations running for this organization")
+
+const (
+	// defaultGenerationTTL is how long a finished generation's events stay available for replay,
+	// and how long a running generation may go without a follower before it is cancelled.
+	defaultGenerationTTL = 5 * time.Minute
+	// defaultMaxGenerationsPerOrg bounds the generations running at once for an org.
+	defaultMaxGenerationsPerOrg = 10
+	// maxGenerationEvents bounds a generation's buffer; the oldest events are dropped first.
+	maxGenerationEvents = 10000
+)
+
+// GenerationEvent is an event of a generation's stream. IDs start at 1 and increase by one.
+type GenerationEvent struct {
+	ID   int
+	Type string
+	Data any
+}
+
+// GenerationInfo describes a generation.
+type GenerationInfo struct {
+	ID          string     `json:"id"`
+	ThreadID    string     `json:"thread_id"`
+	UserID      string     `json:"user_id"`
+	OrgID       string     `json:"org_id"`
+	Status      string     `json:"status"`
+	LastEventID int        `json:"last_event_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// Generation is a streamed query running apart from the request that started it. Its events
+// are buffered, so a client that disconnects can reconnect and replay the events it missed.
+type Generation struct {
+	ID       string
+	ThreadID string
+	UserID   string
+	OrgID    string
+
+	mutex      sync.Mutex
+	status     string
+	createdAt  time.Time
+	finishedAt time.Time
+	events     []GenerationEvent
+	lastID     int
+	changed    chan struct{}
+	cancel     context.CancelFunc
+
+	// A generation nobody follows for idleTimeout is cancelled
+	followers   int
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+}
+
+// Publish buffers an event and wakes the followers.
+func (g *Generation) Publish(eventType string, data any) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.lastID++
+	g.events = append(g.events, GenerationEvent{ID: g.lastID, Type: eventType, Data: data})
+	if len(g.events) > maxGenerationEvents {
+		g.events = g.events[len(g.events)-maxGenerationEvents:]
+	}
+	g.notify()
+}
+
+// Cancel stops a running generation, reporting whether it was running.
+func (g *Generation) Cancel() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.status != GenerationRunning {
+		return false
+	}
+	g.cancel()
+	return true
+}
+
+// Info returns the generation's current state.
+func (g *Generation) Info() GenerationInfo {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	info := GenerationInfo{
+		ID:          g.ID,
+		ThreadID:    g.ThreadID,
+		UserID:      g.UserID,
+		OrgID:       g.OrgID,
+		Status:      g.status,
+		LastEventID: g.lastID,
+		CreatedAt:   g.createdAt,
+	}
+	if !g.finishedAt.IsZero() {
+		finishedAt := g.finishedAt
+		info.FinishedAt = &finishedAt
+	}
+	return info
+}
+
+// Follow calls send with the events after lastID, including those published later, until
+// the generation finishes or the context is done. Events already dropped from the buffer
+// are skipped.
+func (g *Generation) Follow(ctx context.Context, lastID int, send func(GenerationEvent)) error {
+	g.addFollower()
+	defer g.removeFollower()
+
+	for {
+		events, finished, changed := g.eventsAfter(lastID)
+		for _, event := range events {
+			send(event)
+			lastID = event.ID
+		}
+		if finished {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// eventsAfter returns the buffered events after lastID, whether the generation has finished,
+// and a channel closed on the next change.
+func (g *Generation) eventsAfter(lastID int) ([]GenerationEvent, bool, <-chan struct{}) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	var events []GenerationEvent
+	if len(g.events) > 0 {
+		start := lastID - g.events[0].ID + 1
+		if start < 0 {
+			start = 0
+		}
+		if start < len(g.events) {
+			events = append(events, g.events[start:]...)
+		}
+	}
+	return events, g.status != GenerationRunning, g.changed
+}
+
+func (g *Generation) finish(status string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.status = status
+	g.finishedAt = time.Now().UTC()
+	if g.idleTimer != nil {
+		g.idleTimer.Stop()
+		g.idleTimer = nil
+	}
+	g.notify()
+}
+
+func (g *Generation) addFollower() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.followers++
+	if g.idleTimer != nil {
+		g.idleTimer.Stop()
+		g.idleTimer = nil
+	}
+}
+
+func (g *Generation) removeFollower() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.followers--
+	if g.followers == 0 {
+		g.startIdleTimer()
+	}
+}
+
+// startIdleTimer cancels the generation if it is still running without a follower once the
+// idle timeout has passed. The caller holds the lock.
+func (g *Generation) startIdleTimer() {
+	if g.status != GenerationRunning {
+		return
+	}
+	g.idleTimer = time.AfterFunc(g.idleTimeout, func() {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+
+		if g.followers == 0 && g.status == GenerationRunning {
+			log := logger.GetLogger()
+			log.Info().Msgf("Cancelling generation %s, which has had no follower for %s", g.ID, g.idleTimeout)
+			g.cancel()
+		}
+	})
+}
+
+// notify wakes the followers. The caller holds the lock.
+func (g *Generation) notify() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+// GenerationStore runs generations and keeps them until their buffer expires.
+type GenerationStore struct {
+	mutex       sync.Mutex
+	ttl         time.Duration
+	maxPerOrg   int
+	generations map[string]*Generation
+}
+
+// NewGenerationStore keeps finished generations for the given time and runs at most maxPerOrg
+// generations at once for an org; zero uses the defaults.
+func NewGenerationStore(ttl time.Duration, maxPerOrg int) *GenerationStore {
+	if ttl <= 0 {
+		ttl = defaultGenerationTTL
+	}
+	if maxPerOrg <= 0 {
+		maxPerOrg = defaultMaxGenerationsPerOrg
+	}
+	return &GenerationStore{ttl: ttl, maxPerOrg: maxPerOrg, generations: make(map[string]*Generation)}
+}
+
+// Start runs a generation in the background. Its context is not tied to the request, so it
+// survives the client disconnecting, but it is cancelled when it has had no follower for the
+// TTL. The error run returns sets the status. ErrTooManyGenerations is returned when the org
+// already has its maximum of running generations.
+func (s *GenerationStore) Start(
+	userID, orgID, threadID string,
+	run func(ctx context.Context, generation *Generation) error,
+) (*Generation, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	generation := &Generation{
+		ID:          uuid.NewString(),
+		ThreadID:    threadID,
+		UserID:      userID,
+		OrgID:       orgID,
+		status:      GenerationRunning,
+		createdAt:   time.Now().UTC(),
+		changed:     make(chan struct{}),
+		cancel:      cancel,
+		idleTimeout: s.ttl,
+	}
+
+	s.mutex.Lock()
+	s.prune()
+	if s.running(orgID) >= s.maxPerOrg {
+		s.mutex.Unlock()
+		cancel()
+		return nil, ErrTooManyGenerations
+	}
+	s.generations[generation.ID] = generation
+	s.mutex.Unlock()
+
+	// Covers a generation that is never followed
+	generation.mutex.Lock()
+	generation.startIdleTimer()
+	generation.mutex.Unlock()
+
+	go func() {
+		defer cancel()
+
+		err := run(ctx, generation)
+		var approvalErr *ApprovalRequiredError
+		switch {
+		case err == nil, errors.As(err, &approvalErr):
+			generation.finish(GenerationCompleted)
+		case errors.Is(err, context.Canceled):
+			generation.finish(GenerationCancelled)
+		default:
+			generation.finish(GenerationFailed)
+		}
+	}()
+	return generation, nil
+}
+
+// Get returns a running generation, or a finished one whose buffer has not expired.
+func (s *GenerationStore) Get(id string) (*Generation, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prune()
+	generation, ok := s.generations[id]
+	return generation, ok
+}
+
+// running counts the org's running generations. The caller holds the lock.
+func (s *GenerationStore) running(orgID string) int {
+	count := 0
+	for _, generation := range s.generations {
+		if generation.OrgID != orgID {
+			continue
+		}
+		generation.mutex.Lock()
+		if generation.status == GenerationRunning {
+			count++
+		}
+		generation.mutex.Unlock()
+	}
+	return count
+}
+
+// prune drops the generations finished longer ago than the TTL. The caller holds the lock.
+func (s *GenerationStore) prune() {
+	cutoff := time.Now().Add(-s.ttl)
+	for id, generation := range s.generations {
+		generation.mutex.Lock()
+		expired := generation.status != GenerationRunning && generation.finishedAt.Before(cutoff)
+		generation.mutex.Unlock()
+		if expired {
+			delete(s.generations, id)
+		}
+	}
+}
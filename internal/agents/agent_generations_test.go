@@ -0,0 +1,84 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockUntilCancelled is a generation run that only ends when its context does.
+func blockUntilCancelled(ctx context.Context, _ *Generation) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestGenerationStoreLimitsRunningPerOrg(t *testing.T) {
+	store := NewGenerationStore(time.Minute, 2)
+
+	first, err := store.Start("u1", "acme", "t1", blockUntilCancelled)
+	if err != nil {
+		t.Fatalf("first generation: %v", err)
+	}
+	if _, err := store.Start("u1", "acme", "t2", blockUntilCancelled); err != nil {
+		t.Fatalf("second generation: %v", err)
+	}
+	if _, err := store.Start("u1", "acme", "t3", blockUntilCancelled); !errors.Is(err, ErrTooManyGenerations) {
+		t.Fatalf("expected ErrTooManyGenerations, got %v", err)
+	}
+	if _, err := store.Start("u1", "other", "t1", blockUntilCancelled); err != nil {
+		t.Fatalf("other org: %v", err)
+	}
+
+	// A finished generation frees its slot
+	first.Cancel()
+	waitForStatus(t, first, GenerationCancelled)
+	if _, err := store.Start("u1", "acme", "t3", blockUntilCancelled); err != nil {
+		t.Fatalf("after cancel: %v", err)
+	}
+}
+
+func TestGenerationCancelledWithoutFollower(t *testing.T) {
+	store := NewGenerationStore(20*time.Millisecond, 0)
+
+	// A follower keeps the generation running past the idle timeout
+	followed, err := store.Start("u1", "acme", "t1", blockUntilCancelled)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	followerDone := make(chan struct{})
+	go func() {
+		defer close(followerDone)
+		followed.Follow(ctx, 0, func(GenerationEvent) {})
+	}()
+	time.Sleep(60 * time.Millisecond)
+	if status := followed.Info().Status; status != GenerationRunning {
+		t.Fatalf("followed generation is %s, want running", status)
+	}
+
+	// Once the follower leaves, the generation is cancelled after the timeout
+	stop()
+	<-followerDone
+	waitForStatus(t, followed, GenerationCancelled)
+
+	// A generation nobody ever follows is cancelled too
+	unfollowed, err := store.Start("u1", "acme", "t2", blockUntilCancelled)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitForStatus(t, unfollowed, GenerationCancelled)
+}
+
+func waitForStatus(t *testing.T, generation *Generation, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if generation.Info().Status == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("generation is %s, want %s", generation.Info().Status, want)
+}
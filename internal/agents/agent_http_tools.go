@@ -0,0 +1,524 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/logger"
+)
+
+const (
+	defaultHTTPToolTimeout          = 10 * time.Second
+	defaultHTTPToolMaxResponseBytes = 64 * 1024
+	maxHTTPToolRedirects            = 3
+)
+
+// urlPlaceholder matches {argument} placeholders in an HTTP tool's URL template.
+var urlPlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// HTTPToolConfig declares a tool that calls a REST API.
+//
+// The URL may contain {argument} placeholders, which are filled from the tool's arguments.
+// Placeholders in the path are path-escaped and may not be "." or "..", those in the query
+// string are query-escaped. Remaining arguments are sent as query parameters for GET and
+// DELETE requests and as a JSON body otherwise. Header values may reference secrets as ${NAME}.
+type HTTPToolConfig struct {
+	Name             string            `json:"name"`
+	Description      string            `json:"description"`
+	Method           string            `json:"method"`
+	URL              string            `json:"url"`
+	Headers          map[string]string `json:"headers,omitempty"`
+	Parameters       map[string]any    `json:"parameters,omitempty"`
+	ResponsePath     string            `json:"response_path,omitempty"`
+	Timeout          string            `json:"timeout,omitempty"`
+	MaxResponseBytes int64             `json:"max_response_bytes,omitempty"`
+	RequireApproval  bool              `json:"require_approval,omitempty"`
+}
+
+// SecretResolver returns the value of a secret referenced from an HTTP tool header.
+type SecretResolver func(name string) (string, error)
+
+// EnvSecretResolver resolves secrets from environment variables.
+func EnvSecretResolver(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("secret %s is not set", name)
+	}
+	return value, nil
+}
+
+// HTTPToolPolicy bounds what HTTP tools may do.
+type HTTPToolPolicy struct {
+	// AllowedHosts lists the hosts, optionally with a port, that tools may call.
+	AllowedHosts     []string
+	Timeout          time.Duration
+	MaxResponseBytes int64
+	Secrets          SecretResolver
+	// Client is used for the requests; nil means a client built from the policy.
+	Client *http.Client
+}
+
+// hostAllowed reports whether the URL's host is in the allowlist.
+func (p HTTPToolPolicy) hostAllowed(target *url.URL) bool {
+	for _, allowed := range p.AllowedHosts {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == "" {
+			continue
+		}
+		if allowed == strings.ToLower(target.Host) || allowed == strings.ToLower(target.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPTool is a SchemaTool backed by a declarative HTTP request.
+type HTTPTool struct {
+	config           HTTPToolConfig
+	policy           HTTPToolPolicy
+	client           *http.Client
+	timeout          time.Duration
+	maxResponseBytes int64
+}
+
+var (
+	_ SchemaTool   = (*HTTPTool)(nil)
+	_ ApprovalTool = (*HTTPTool)(nil)
+)
+
+// NewHTTPTool validates the configuration against the policy and creates the tool.
+func NewHTTPTool(config HTTPToolConfig, policy HTTPToolPolicy) (*HTTPTool, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf("http tool name is required")
+	}
+	if config.Description == "" {
+		return nil, fmt.Errorf("http tool %s: description is required", config.Name)
+	}
+
+	config.Method = strings.ToUpper(config.Method)
+	if config.Method == "" {
+		config.Method = http.MethodGet
+	}
+	switch config.Method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return nil, fmt.Errorf("http tool %s: unsupported method %s", config.Name, config.Method)
+	}
+
+	// Validate the host with placeholders removed, so arguments cannot change it
+	target, err := url.Parse(urlPlaceholder.ReplaceAllString(config.URL, "x"))
+	if err != nil || target.Host == "" {
+		return nil, fmt.Errorf("http tool %s: invalid url %q", config.Name, config.URL)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, fmt.Errorf("http tool %s: url scheme must be http or https", config.Name)
+	}
+	if other, err := url.Parse(urlPlaceholder.ReplaceAllString(config.URL, "y")); err != nil || other.Host != target.Host {
+		return nil, fmt.Errorf("http tool %s: placeholders are not allowed in the host", config.Name)
+	}
+	if !policy.hostAllowed(target) {
+		return nil, fmt.Errorf("http tool %s: host %s is not in the allowlist", config.Name, target.Host)
+	}
+
+	if _, err := compileJSONPath(config.ResponsePath); err != nil {
+		return nil, fmt.Errorf("http tool %s: %w", config.Name, err)
+	}
+
+	tool := &HTTPTool{
+		config:           config,
+		policy:           policy,
+		timeout:          policy.Timeout,
+		maxResponseBytes: policy.MaxResponseBytes,
+	}
+	if tool.timeout <= 0 {
+		tool.timeout = defaultHTTPToolTimeout
+	}
+	if config.Timeout != "" {
+		timeout, err := time.ParseDuration(config.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("http tool %s: invalid timeout %q", config.Name, config.Timeout)
+		}
+		// A tool may shorten the policy timeout but not extend it
+		if timeout < tool.timeout {
+			tool.timeout = timeout
+		}
+	}
+	if tool.maxResponseBytes <= 0 {
+		tool.maxResponseBytes = defaultHTTPToolMaxResponseBytes
+	}
+	if config.MaxResponseBytes > 0 && config.MaxResponseBytes < tool.maxResponseBytes {
+		tool.maxResponseBytes = config.MaxResponseBytes
+	}
+
+	tool.client = policy.Client
+	if tool.client == nil {
+		tool.client = &http.Client{}
+	}
+	// Redirects must stay within the allowlist too
+	client := *tool.client
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxHTTPToolRedirects {
+			return fmt.Errorf("too many redirects")
+		}
+		if !policy.hostAllowed(req.URL) {
+			return fmt.Errorf("redirect to host %s is not allowed", req.URL.Host)
+		}
+		return nil
+	}
+	tool.client = &client
+
+	return tool, nil
+}
+
+func (t *HTTPTool) Name() string {
+	return t.config.Name
+}
+
+func (t *HTTPTool) Description() string {
+	return t.config.Description
+}
+
+func (t *HTTPTool) RequiresApproval() bool {
+	return t.config.RequireApproval
+}
+
+func (t *HTTPTool) Schema() map[string]any {
+	if t.config.Parameters != nil {
+		return t.config.Parameters
+	}
+	return map[string]any{"type": "object", "properties": map[string]any{}}
+}
+
+// Call sends the request described by the configuration and returns the extracted response.
+func (t *HTTPTool) Call(ctx context.Context, arguments string) (string, error) {
+	log := logger.GetLogger()
+
+	args := map[string]any{}
+	if strings.TrimSpace(arguments) != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+	}
+
+	req, err := t.buildRequest(ctx, args)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	log.Debug().Msgf("Calling http tool %s: %s %s", t.config.Name, req.Method, req.URL.Redacted())
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read one byte past the cap to detect oversized responses
+	body, err := io.ReadAll(io.LimitReader(resp.Body, t.maxResponseBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if int64(len(body)) > t.maxResponseBytes {
+		return "", fmt.Errorf("response exceeds %d bytes", t.maxResponseBytes)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("request returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if t.config.ResponsePath == "" {
+		return string(body), nil
+	}
+	return extractJSONPath(body, t.config.ResponsePath)
+}
+
+// buildRequest fills the URL template, headers and body from the arguments.
+func (t *HTTPTool) buildRequest(ctx context.Context, args map[string]any) (*http.Request, error) {
+	remaining := make(map[string]any, len(args))
+	for name, value := range args {
+		remaining[name] = value
+	}
+
+	var missing, invalid []string
+	fill := func(template string, inPath bool) string {
+		return urlPlaceholder.ReplaceAllStringFunc(template, func(match string) string {
+			name := match[1 : len(match)-1]
+			value, ok := args[name]
+			if !ok {
+				missing = append(missing, name)
+				return match
+			}
+			delete(remaining, name)
+			text := argumentString(value)
+			if !inPath {
+				return url.QueryEscape(text)
+			}
+			// Dot segments would let an argument move the request to another path
+			if text == "." || text == ".." {
+				invalid = append(invalid, name)
+				return match
+			}
+			return url.PathEscape(text)
+		})
+	}
+	path, query, hasQuery := strings.Cut(t.config.URL, "?")
+	rawURL := fill(path, true)
+	if hasQuery {
+		rawURL += "?" + fill(query, false)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing arguments: %s", strings.Join(missing, ", "))
+	}
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("arguments in the url path cannot be '.' or '..': %s", strings.Join(invalid, ", "))
+	}
+
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if !t.policy.hostAllowed(target) {
+		return nil, fmt.Errorf("host %s is not allowed", target.Host)
+	}
+
+	var body io.Reader
+	if t.config.Method == http.MethodGet || t.config.Method == http.MethodDelete {
+		query := target.Query()
+		for name, value := range remaining {
+			query.Set(name, argumentString(value))
+		}
+		target.RawQuery = query.Encode()
+	} else {
+		payload, err := json.Marshal(remaining)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode body: %w", err)
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, t.config.Method, target.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	for name, value := range t.config.Headers {
+		resolved, err := t.resolveSecrets(value)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(name, resolved)
+	}
+	return req, nil
+}
+
+// resolveSecrets replaces ${NAME} references in a header value.
+func (t *HTTPTool) resolveSecrets(value string) (string, error) {
+	resolved, err := expandSecrets(value, t.policy.Secrets)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve header: %w", err)
+	}
+	return resolved, nil
+}
+
+// expandSecrets replaces ${NAME} references with secrets from the resolver, or from the
+// environment when the resolver is nil.
+func expandSecrets(value string, resolver SecretResolver) (string, error) {
+	if resolver == nil {
+		resolver = EnvSecretResolver
+	}
+
+	var resolveErr error
+	resolved := os.Expand(value, func(name string) string {
+		secret, err := resolver(name)
+		if err != nil && resolveErr == nil {
+			resolveErr = err
+		}
+		return secret
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	return resolved, nil
+}
+
+// argumentString formats an argument value for a URL.
+func argumentString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+}
+
+// jsonPathStep is one segment of a compiled JSONPath: a key, an index, or a wildcard.
+type jsonPathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// compileJSONPath parses the supported JSONPath subset: $, .key, ['key'], [n] and [*].
+func compileJSONPath(path string) ([]jsonPathStep, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("response path must start with $")
+	}
+
+	var steps []jsonPathStep
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key := rest[:end]
+			if key == "" {
+				return nil, fmt.Errorf("invalid response path %q", path)
+			}
+			if key == "*" {
+				steps = append(steps, jsonPathStep{wildcard: true})
+			} else {
+				steps = append(steps, jsonPathStep{key: key})
+			}
+			rest = rest[end:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid response path %q", path)
+			}
+			inner := rest[1:end]
+			switch {
+			case inner == "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, jsonPathStep{key: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid index %q in response path", inner)
+				}
+				steps = append(steps, jsonPathStep{index: index, isIndex: true})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid response path %q", path)
+		}
+	}
+	return steps, nil
+}
+
+// extractJSONPath returns the values selected by the path, encoded as JSON.
+// Strings are returned without quotes so the model sees plain text.
+func extractJSONPath(body []byte, path string) (string, error) {
+	steps, err := compileJSONPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	var document any
+	if err := json.Unmarshal(body, &document); err != nil {
+		return "", fmt.Errorf("response is not JSON: %w", err)
+	}
+
+	current := []any{document}
+	multiple := false
+	for _, step := range steps {
+		var next []any
+		for _, value := range current {
+			switch node := value.(type) {
+			case map[string]any:
+				if step.wildcard {
+					for _, child := range node {
+						next = append(next, child)
+					}
+				} else if child, ok := node[step.key]; ok && !step.isIndex {
+					next = append(next, child)
+				}
+			case []any:
+				if step.wildcard {
+					next = append(next, node...)
+				} else if step.isIndex {
+					index := step.index
+					if index < 0 {
+						index += len(node)
+					}
+					if index >= 0 && index < len(node) {
+						next = append(next, node[index])
+					}
+				}
+			}
+		}
+		if step.wildcard {
+			multiple = true
+		}
+		current = next
+	}
+
+	if len(current) == 0 {
+		return "", fmt.Errorf("response path %s matched nothing", path)
+	}
+	if !multiple {
+		if text, ok := current[0].(string); ok {
+			return text, nil
+		}
+		encoded, err := json.Marshal(current[0])
+		return string(encoded), err
+	}
+	encoded, err := json.Marshal(current)
+	return string(encoded), err
+}
+
+// LoadHTTPTools reads per-org HTTP tool definitions from a JSON file mapping org IDs to
+// tool configurations, and registers them for their orgs.
+func (am *AgentManager) LoadHTTPTools(path string, policy HTTPToolPolicy) error {
+	log := logger.GetLogger()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read http tools file: %w", err)
+	}
+
+	var configs map[string][]HTTPToolConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return fmt.Errorf("failed to parse http tools file: %w", err)
+	}
+
+	for orgID, orgConfigs := range configs {
+		for _, config := range orgConfigs {
+			tool, err := NewHTTPTool(config, policy)
+			if err != nil {
+				return fmt.Errorf("org %s: %w", orgID, err)
+			}
+			am.Tools.RegisterForOrg(orgID, tool)
+		}
+		log.Info().Msgf("Registered %d http tools for org %s", len(orgConfigs), orgID)
+	}
+	return nil
+}
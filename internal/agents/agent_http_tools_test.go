@@ -0,0 +1,323 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// newHTTPToolServer starts a server echoing the request it receives, and returns it with a
+// policy allowing its host.
+func newHTTPToolServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, HTTPToolPolicy) {
+	t.Helper()
+	if handler == nil {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			json.NewEncoder(w).Encode(map[string]string{
+				"method": r.Method,
+				"path":   r.URL.EscapedPath(),
+				"query":  r.URL.RawQuery,
+				"body":   string(body),
+			})
+		}
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	target, _ := url.Parse(server.URL)
+	return server, HTTPToolPolicy{AllowedHosts: []string{target.Hostname()}}
+}
+
+func TestHTTPToolURLTemplate(t *testing.T) {
+	server, policy := newHTTPToolServer(t, nil)
+
+	tests := []struct {
+		name      string
+		method    string
+		url       string
+		arguments string
+		want      map[string]string
+		wantErr   string
+	}{
+		{
+			name:      "path placeholder is path-escaped",
+			url:       "/items/{id}",
+			arguments: `{"id": "a/b c"}`,
+			want:      map[string]string{"path": "/items/a%2Fb%20c", "query": ""},
+		},
+		{
+			name:      "numbers are formatted without exponent",
+			url:       "/items/{id}",
+			arguments: `{"id": 1234567}`,
+			want:      map[string]string{"path": "/items/1234567"},
+		},
+		{
+			name:      "query placeholder is query-escaped",
+			url:       "/search?q={q}",
+			arguments: `{"q": "a&b=c d"}`,
+			want:      map[string]string{"path": "/search", "query": "q=a%26b%3Dc+d"},
+		},
+		{
+			name:      "remaining arguments go to the query for GET",
+			url:       "/items/{id}",
+			arguments: `{"id": "1", "verbose": true}`,
+			want:      map[string]string{"path": "/items/1", "query": "verbose=true"},
+		},
+		{
+			name:      "remaining arguments go to the body for POST",
+			method:    http.MethodPost,
+			url:       "/items/{id}",
+			arguments: `{"id": "1", "name": "x"}`,
+			want:      map[string]string{"method": "POST", "path": "/items/1", "body": `{"name":"x"}`},
+		},
+		{
+			name:      "dot-dot in the path is rejected",
+			url:       "/items/{id}",
+			arguments: `{"id": ".."}`,
+			wantErr:   "cannot be '.' or '..': id",
+		},
+		{
+			name:      "dot in the path is rejected",
+			url:       "/items/{id}/detail",
+			arguments: `{"id": "."}`,
+			wantErr:   "cannot be '.' or '..': id",
+		},
+		{
+			name:      "dot-dot in the query is allowed",
+			url:       "/files?name={name}",
+			arguments: `{"name": ".."}`,
+			want:      map[string]string{"path": "/files", "query": "name=.."},
+		},
+		{
+			name:      "missing argument",
+			url:       "/items/{id}",
+			arguments: `{}`,
+			wantErr:   "missing arguments: id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool, err := NewHTTPTool(HTTPToolConfig{
+				Name:        "lookup",
+				Description: "Looks things up",
+				Method:      tt.method,
+				URL:         server.URL + tt.url,
+			}, policy)
+			if err != nil {
+				t.Fatalf("NewHTTPTool returned %v", err)
+			}
+
+			result, err := tool.Call(context.Background(), tt.arguments)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Call returned %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Call returned %v", err)
+			}
+
+			var got map[string]string
+			if err := json.Unmarshal([]byte(result), &got); err != nil {
+				t.Fatalf("unexpected response %s: %v", result, err)
+			}
+			for key, want := range tt.want {
+				if got[key] != want {
+					t.Errorf("%s = %q, want %q", key, got[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewHTTPToolHostAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		allowed []string
+		wantErr string
+	}{
+		{name: "allowed host", url: "https://api.example.com/v1", allowed: []string{"api.example.com"}},
+		{name: "allowed host and port", url: "https://api.example.com:8443/v1", allowed: []string{"api.example.com:8443"}},
+		{name: "case-insensitive", url: "https://API.example.com/v1", allowed: []string{" api.example.com "}},
+		{name: "host not allowed", url: "https://evil.example.com/v1", allowed: []string{"api.example.com"}, wantErr: "not in the allowlist"},
+		{name: "other port not allowed", url: "https://api.example.com:9999/v1", allowed: []string{"api.example.com:8443"}, wantErr: "not in the allowlist"},
+		{name: "empty allowlist", url: "https://api.example.com/v1", wantErr: "not in the allowlist"},
+		{name: "placeholder in host", url: "https://{tenant}.example.com/v1", allowed: []string{"x.example.com"}, wantErr: "not allowed in the host"},
+		{name: "unsupported scheme", url: "ftp://api.example.com/v1", allowed: []string{"api.example.com"}, wantErr: "scheme must be http or https"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewHTTPTool(HTTPToolConfig{
+				Name:        "lookup",
+				Description: "Looks things up",
+				URL:         tt.url,
+			}, HTTPToolPolicy{AllowedHosts: tt.allowed})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("NewHTTPTool returned %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("NewHTTPTool returned %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPToolRedirects(t *testing.T) {
+	var server *httptest.Server
+	server, policy := newHTTPToolServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same-host":
+			http.Redirect(w, r, "/done", http.StatusFound)
+		case "/other-host":
+			// localhost resolves to the same server, but is not in the allowlist
+			http.Redirect(w, r, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/done", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			w.Write([]byte(`"done"`))
+		}
+	})
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "redirect within the allowlist", path: "/same-host"},
+		{name: "redirect to another host", path: "/other-host", wantErr: "redirect to host localhost"},
+		{name: "too many redirects", path: "/loop", wantErr: "too many redirects"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool, err := NewHTTPTool(HTTPToolConfig{
+				Name:        "lookup",
+				Description: "Looks things up",
+				URL:         server.URL + tt.path,
+			}, policy)
+			if err != nil {
+				t.Fatalf("NewHTTPTool returned %v", err)
+			}
+
+			result, err := tool.Call(context.Background(), "")
+			if tt.wantErr == "" {
+				if err != nil || result != `"done"` {
+					t.Fatalf("Call returned %q, %v", result, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Call returned %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPToolResponseLimit(t *testing.T) {
+	server, policy := newHTTPToolServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 16)))
+	})
+	policy.MaxResponseBytes = 32
+
+	tests := []struct {
+		name     string
+		maxBytes int64
+		wantErr  string
+	}{
+		{name: "within the policy limit", maxBytes: 0},
+		{name: "exactly at the tool limit", maxBytes: 16},
+		{name: "over the tool limit", maxBytes: 15, wantErr: "response exceeds 15 bytes"},
+		{name: "tool cannot raise the policy limit", maxBytes: 1 << 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool, err := NewHTTPTool(HTTPToolConfig{
+				Name:             "lookup",
+				Description:      "Looks things up",
+				URL:              server.URL,
+				MaxResponseBytes: tt.maxBytes,
+			}, policy)
+			if err != nil {
+				t.Fatalf("NewHTTPTool returned %v", err)
+			}
+			if tool.maxResponseBytes > policy.MaxResponseBytes {
+				t.Fatalf("limit %d exceeds the policy limit %d", tool.maxResponseBytes, policy.MaxResponseBytes)
+			}
+
+			_, err = tool.Call(context.Background(), "")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Call returned %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Call returned %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExtractJSONPath(t *testing.T) {
+	body := []byte(`{
+		"data": {"name": "Ada", "tags": ["a", "b", "c"], "count": 3},
+		"items": [{"id": 1, "title": "one"}, {"id": 2, "title": "two"}],
+		"odd key": "spaced"
+	}`)
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr string
+	}{
+		{name: "root", path: "$", want: `{"data":{"count":3,"name":"Ada","tags":["a","b","c"]},"items":[{"id":1,"title":"one"},{"id":2,"title":"two"}],"odd key":"spaced"}`},
+		{name: "string is unquoted", path: "$.data.name", want: "Ada"},
+		{name: "number", path: "$.data.count", want: "3"},
+		{name: "object", path: "$.items[0]", want: `{"id":1,"title":"one"}`},
+		{name: "negative index", path: "$.data.tags[-1]", want: "c"},
+		{name: "bracketed key", path: "$['odd key']", want: "spaced"},
+		{name: "wildcard returns a list", path: "$.items[*].title", want: `["one","two"]`},
+		{name: "dot wildcard", path: "$.items.*.id", want: `[1,2]`},
+		{name: "missing key", path: "$.data.missing", wantErr: "matched nothing"},
+		{name: "index out of range", path: "$.items[5]", wantErr: "matched nothing"},
+		{name: "no leading $", path: "data.name", wantErr: "must start with $"},
+		{name: "bad index", path: "$.items[x]", wantErr: "invalid index"},
+		{name: "unclosed bracket", path: "$.items[0", wantErr: "invalid response path"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractJSONPath(body, tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("extractJSONPath returned %q, %v, want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractJSONPath returned %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("extractJSONPath = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := extractJSONPath([]byte("not json"), "$.a"); err == nil || !strings.Contains(err.Error(), "not JSON") {
+		t.Fatalf("extractJSONPath on a non-JSON body returned %v", err)
+	}
+}
@@ -1,11 +1,13 @@
 package agents
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
 	"github.com/blog/conversational-agent/internal/logger"
 	"github.com/tmc/langchaingo/chains"
+	"github.com/tmc/langchaingo/embeddings"
 	"github.com/tmc/langchaingo/llms/openai"
 	"github.com/tmc/langchaingo/memory"
 	"github.com/tmc/langchaingo/schema"
@@ -13,16 +15,42 @@ import (
 )
 
 type AgentManager struct {
-	LLM                 *openai.LLM
-	VectorStore         weaviate.Store
-	AgentMemory         map[string]*memory.ConversationBuffer
-	ConversationalChain *chains.ConversationalRetrievalQA
-	WeaviateIndex       string
-	LLMChain            *chains.LLMChain
-	messageBuffer       []schema.Document
-	bufferMutex         sync.Mutex
-	maxBufferMessages   int
-	memoryMutex         sync.Mutex
+	LLM                     *openai.LLM
+	Embedder                embeddings.Embedder
+	VectorStore             weaviate.Store
+	mmrVectorStore          weaviate.Store
+	AgentMemory             map[string]*memory.ConversationBuffer
+	threadOwners            map[string]threadOwner
+	pendingActions          map[string]*PendingAction // in memory only, lost on restart
+	ApprovalWebhookURL      string
+	Router                  *AgentRouter
+	RoutingEnabled          bool
+	threadMetadata          map[string]map[string]any
+	openAIApiKey            string
+	models                  modelClients
+	Model                   string
+	Personas                *PersonaStore
+	Prompts                 *PromptRegistry
+	Feedback                *FeedbackStore
+	Events                  *EventBus
+	Generations             *GenerationStore
+	Batches                 *BatchStore
+	StructuredOutputRetries int
+	ConversationalChain     *chains.ConversationalRetrievalQA
+	WeaviateIndex           string
+	Retrieval               RetrievalConfig
+	Tools                   *ToolRegistry
+	MaxAgentIterations      int
+	LLMChain                *chains.LLMChain
+	messageBuffer           []schema.Document
+	bufferMutex             sync.Mutex
+	maxBufferMessages       int
+	ChunkWords              int
+	memoryMutex             sync.Mutex
+	threadTrees             map[string]*threadTree
+	treeMutex               sync.Mutex
+	mcpConnections          []*mcpConnection
+	mcpMutex                sync.Mutex
 }
 
 func NewAgentManager(
@@ -51,8 +79,20 @@ func NewAgentManager(
 		return nil, fmt.Errorf("failed to initialize Weaviate vector store: %w", err)
 	}
 
+	// MMR reranks on the stored vectors instead of embedding the candidates again
+	mmrVectorStore, err := InitializeVectorStore(weaviateHost, weaviateApiKey, weaviateIndex, embedder, "vector")
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Weaviate vector store: %w", err)
+	}
+
 	log.Info().Msg("Weaviate vector store initialized successfully")
 
+	log.Info().Msg("Ensuring Weaviate schema...")
+	if err := EnsureVectorStoreSchema(context.Background(), weaviateHost, weaviateApiKey, weaviateIndex); err != nil {
+		return nil, fmt.Errorf("failed to ensure Weaviate schema: %w", err)
+	}
+	log.Info().Msg("Weaviate schema ensured successfully")
+
 	log.Info().Msg("Initializing ConversationBuffer memory...")
 	agentMemory := InitializeMemory()
 	log.Info().Msg("ConversationBuffer memory initialized successfully")
@@ -64,13 +104,53 @@ func NewAgentManager(
 	}
 	log.Info().Msg("Chain initialized successfully")
 
-	return &AgentManager{
-		LLM:               llm,
-		VectorStore:       vectorStore,
-		AgentMemory:       make(map[string]*memory.ConversationBuffer),
-		LLMChain:          chain,
-		WeaviateIndex:     weaviateIndex,
-		messageBuffer:     []schema.Document{},
-		maxBufferMessages: maxBufferMessages,
-	}, nil
+	// Personas and prompts are kept in memory until a data directory is configured
+	personas, err := NewPersonaStore("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize persona store: %w", err)
+	}
+	prompts, err := NewPromptRegistry("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize prompt registry: %w", err)
+	}
+	feedback, err := NewFeedbackStore("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize feedback store: %w", err)
+	}
+
+	am := &AgentManager{
+		LLM:                llm,
+		Embedder:           embedder,
+		VectorStore:        vectorStore,
+		mmrVectorStore:     mmrVectorStore,
+		AgentMemory:        make(map[string]*memory.ConversationBuffer),
+		threadOwners:       make(map[string]threadOwner),
+		pendingActions:     make(map[string]*PendingAction),
+		Router:             DefaultAgentRouter(),
+		threadMetadata:     make(map[string]map[string]any),
+		openAIApiKey:       openAIApiKey,
+		Model:              openAIModel,
+		Personas:           personas,
+		Prompts:            prompts,
+		Feedback:           feedback,
+		Events:             NewEventBus(),
+		Generations:        NewGenerationStore(0, 0),
+		Batches:            NewBatchStore(0, 0),
+		LLMChain:           chain,
+		WeaviateIndex:      weaviateIndex,
+		Retrieval:          DefaultRetrievalConfig(),
+		Tools:              NewToolRegistry(defaultTools()...),
+		MaxAgentIterations: defaultMaxAgentIterations,
+		messageBuffer:      []schema.Document{},
+		maxBufferMessages:  maxBufferMessages,
+		ChunkWords:         defaultChunkWords,
+
+		StructuredOutputRetries: defaultStructuredOutputRetries,
+	}
+
+	// Knowledge-base search tools need the manager's vector store
+	am.Tools.Register(am.knowledgeSearchTool())
+	am.Tools.Register(am.metadataSearchTool())
+
+	return am, nil
 }
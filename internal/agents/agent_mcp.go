@@ -0,0 +1,480 @@
+package agents
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/blog/conversational-agent/internal/mcp"
+)
+
+// MCP transports.
+const (
+	MCPTransportStdio = "stdio"
+	MCPTransportHTTP  = "http"
+)
+
+const (
+	defaultMCPTimeout = 30 * time.Second
+	// maxToolNameLength is the longest function name the model API accepts.
+	maxToolNameLength = 64
+	// maxListedItems bounds the resources and prompts listed in a tool description.
+	maxListedItems = 25
+)
+
+// invalidToolNameChars matches characters not allowed in function names.
+var invalidToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// mcpClientInfo identifies the agent to MCP servers.
+var mcpClientInfo = mcp.Implementation{Name: "conversational-agent", Version: "1.0.0"}
+
+// MCPServerConfig declares an MCP server an org's agent connects to.
+// Env values and headers may reference secrets as ${NAME}. RequireApproval lists the
+// server's tools that need approval before running; "*" covers all of them.
+type MCPServerConfig struct {
+	Name      string            `json:"name"`
+	Transport string            `json:"transport"`
+	Command   string            `json:"command,omitempty"`
+	Args      []string          `json:"args,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	URL       string            `json:"url,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Timeout   string            `json:"timeout,omitempty"`
+
+	RequireApproval []string `json:"require_approval,omitempty"`
+}
+
+// ConnectMCPServer connects to an MCP server and registers its tools, resources and prompts
+// as tools for the org.
+func (am *AgentManager) ConnectMCPServer(ctx context.Context, orgID string, config MCPServerConfig) error {
+	log := logger.GetLogger()
+
+	if config.Name == "" {
+		return fmt.Errorf("mcp server name is required")
+	}
+	timeout := defaultMCPTimeout
+	if config.Timeout != "" {
+		parsed, err := time.ParseDuration(config.Timeout)
+		if err != nil || parsed <= 0 {
+			return fmt.Errorf("mcp server %s: invalid timeout %q", config.Name, config.Timeout)
+		}
+		timeout = parsed
+	}
+
+	conn := &mcpConnection{config: config, timeout: timeout}
+	connectCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client, err := conn.session(connectCtx)
+	if err != nil {
+		return fmt.Errorf("mcp server %s: %w", config.Name, err)
+	}
+
+	remoteTools, err := client.ListTools(connectCtx)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("mcp server %s: failed to list tools: %w", config.Name, err)
+	}
+	resources, err := client.ListResources(connectCtx)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("mcp server %s: failed to list resources: %w", config.Name, err)
+	}
+	prompts, err := client.ListPrompts(connectCtx)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("mcp server %s: failed to list prompts: %w", config.Name, err)
+	}
+
+	needsApproval := make(map[string]bool, len(config.RequireApproval))
+	for _, name := range config.RequireApproval {
+		needsApproval[name] = true
+	}
+	for _, remote := range remoteTools {
+		am.Tools.RegisterForOrg(orgID, mcpTool{
+			conn:     conn,
+			name:     mcpToolName(config.Name, remote.Name),
+			remote:   remote,
+			timeout:  timeout,
+			approval: needsApproval["*"] || needsApproval[remote.Name],
+		})
+	}
+	if len(resources) > 0 {
+		am.Tools.RegisterForOrg(orgID, mcpResourceTool(conn, config.Name, resources, timeout))
+	}
+	if len(prompts) > 0 {
+		am.Tools.RegisterForOrg(orgID, mcpPromptTool(conn, config.Name, prompts, timeout))
+	}
+
+	am.mcpMutex.Lock()
+	am.mcpConnections = append(am.mcpConnections, conn)
+	am.mcpMutex.Unlock()
+
+	log.Info().Msgf("Connected to MCP server %s (%s) for org %s: %d tools, %d resources, %d prompts",
+		config.Name, client.ServerInfo.Name, orgID, len(remoteTools), len(resources), len(prompts))
+	return nil
+}
+
+// mcpConnection is the session with a configured MCP server. A stdio server that exits is
+// started again on the next call; the call that saw it exit still fails, as it may have run.
+type mcpConnection struct {
+	config  MCPServerConfig
+	timeout time.Duration
+
+	mutex  sync.Mutex
+	client *mcp.Client
+	closed bool
+}
+
+// session returns the connected client, connecting first if there is none or its server exited.
+func (c *mcpConnection) session(ctx context.Context) (*mcp.Client, error) {
+	log := logger.GetLogger()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil, fmt.Errorf("mcp server %s is closed", c.config.Name)
+	}
+	if c.client != nil && !c.client.Exited() {
+		return c.client, nil
+	}
+	if c.client != nil {
+		log.Warn().Msgf("MCP server %s exited, reconnecting.", c.config.Name)
+		_ = c.client.Close()
+		c.client = nil
+	}
+
+	transport, err := newMCPTransport(c.config)
+	if err != nil {
+		return nil, err
+	}
+	client, err := mcp.Connect(ctx, transport, mcpClientInfo)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	c.client = client
+	return client, nil
+}
+
+// Close ends the session and stops a stdio server.
+func (c *mcpConnection) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	if c.client == nil {
+		return nil
+	}
+	err := c.client.Close()
+	c.client = nil
+	return err
+}
+
+// CloseMCPServers ends the sessions with every connected MCP server.
+func (am *AgentManager) CloseMCPServers() {
+	log := logger.GetLogger()
+
+	am.mcpMutex.Lock()
+	connections := am.mcpConnections
+	am.mcpConnections = nil
+	am.mcpMutex.Unlock()
+
+	for _, conn := range connections {
+		if err := conn.Close(); err != nil {
+			log.Warn().Err(err).Msgf("Failed to close MCP server %s.", conn.config.Name)
+		}
+	}
+}
+
+// newMCPTransport creates the transport described by the configuration.
+func newMCPTransport(config MCPServerConfig) (mcp.Transport, error) {
+	switch config.Transport {
+	case MCPTransportStdio, "":
+		if config.Command == "" {
+			return nil, fmt.Errorf("command is required for the stdio transport")
+		}
+		env := make(map[string]string, len(config.Env))
+		for name, value := range config.Env {
+			resolved, err := expandSecrets(value, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve env %s: %w", name, err)
+			}
+			env[name] = resolved
+		}
+		return mcp.NewStdioTransport(config.Command, config.Args, env)
+	case MCPTransportHTTP:
+		if config.URL == "" {
+			return nil, fmt.Errorf("url is required for the http transport")
+		}
+		headers := make(map[string]string, len(config.Headers))
+		for name, value := range config.Headers {
+			resolved, err := expandSecrets(value, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve header %s: %w", name, err)
+			}
+			headers[name] = resolved
+		}
+		return mcp.NewHTTPTransport(config.URL, headers, nil), nil
+	}
+	return nil, fmt.Errorf("unknown transport: %s", config.Transport)
+}
+
+// mcpToolName prefixes a remote tool with its server and makes it a valid function name.
+// Names that are too long are cut and end with a hash of the full name, so they stay distinct.
+func mcpToolName(server, tool string) string {
+	name := invalidToolNameChars.ReplaceAllString(server+"_"+tool, "_")
+	if len(name) > maxToolNameLength {
+		sum := sha256.Sum256([]byte(server + "_" + tool))
+		suffix := "_" + hex.EncodeToString(sum[:4])
+		name = name[:maxToolNameLength-len(suffix)] + suffix
+	}
+	return name
+}
+
+// mcpTool exposes a tool of an MCP server.
+type mcpTool struct {
+	conn     *mcpConnection
+	name     string
+	remote   mcp.Tool
+	timeout  time.Duration
+	approval bool
+}
+
+var (
+	_ SchemaTool   = mcpTool{}
+	_ ApprovalTool = mcpTool{}
+)
+
+func (t mcpTool) Name() string {
+	return t.name
+}
+
+func (t mcpTool) Description() string {
+	if t.remote.Description != "" {
+		return t.remote.Description
+	}
+	return t.remote.Title
+}
+
+func (t mcpTool) RequiresApproval() bool {
+	return t.approval
+}
+
+func (t mcpTool) Schema() map[string]any {
+	if t.remote.InputSchema == nil {
+		return map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	return t.remote.InputSchema
+}
+
+func (t mcpTool) Call(ctx context.Context, arguments string) (string, error) {
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+	if !json.Valid([]byte(arguments)) {
+		return "", fmt.Errorf("arguments must be a JSON object")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	client, err := t.conn.session(ctx)
+	if err != nil {
+		return "", err
+	}
+	result, err := client.CallTool(ctx, t.remote.Name, json.RawMessage(arguments))
+	if err != nil {
+		return "", err
+	}
+	output := formatMCPContent(result.Content)
+	if output == "" && result.StructuredContent != nil {
+		encoded, _ := json.Marshal(result.StructuredContent)
+		output = string(encoded)
+	}
+	if result.IsError {
+		return "", fmt.Errorf("%s", output)
+	}
+	return output, nil
+}
+
+// mcpResourceTool lets the model read the resources of an MCP server.
+func mcpResourceTool(conn *mcpConnection, server string, resources []mcp.Resource, timeout time.Duration) FunctionTool {
+	uris := make([]string, 0, len(resources))
+	var listing strings.Builder
+	for i, resource := range resources {
+		uris = append(uris, resource.URI)
+		if i < maxListedItems {
+			listing.WriteString(fmt.Sprintf("\n- %s: %s", resource.URI, firstNonEmpty(resource.Description, resource.Name)))
+		}
+	}
+	if len(resources) > maxListedItems {
+		listing.WriteString(fmt.Sprintf("\n- and %d more", len(resources)-maxListedItems))
+	}
+
+	return FunctionTool{
+		ToolName:        mcpToolName(server, "read_resource"),
+		ToolDescription: fmt.Sprintf("Read a resource from %s. Available resources:%s", server, listing.String()),
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"uri": map[string]any{"type": "string", "enum": uris, "description": "The URI of the resource."},
+			},
+			"required": []string{"uri"},
+		},
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			var args struct {
+				URI string `json:"uri"`
+			}
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil || args.URI == "" {
+				return "", fmt.Errorf("'uri' is required")
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			client, err := conn.session(ctx)
+			if err != nil {
+				return "", err
+			}
+			result, err := client.ReadResource(ctx, args.URI)
+			if err != nil {
+				return "", err
+			}
+			contents := make([]mcp.Content, 0, len(result.Contents))
+			for i := range result.Contents {
+				contents = append(contents, mcp.Content{Type: "resource", Resource: &result.Contents[i]})
+			}
+			return formatMCPContent(contents), nil
+		},
+	}
+}
+
+// mcpPromptTool lets the model render the prompts of an MCP server.
+func mcpPromptTool(conn *mcpConnection, server string, prompts []mcp.Prompt, timeout time.Duration) FunctionTool {
+	names := make([]string, 0, len(prompts))
+	var listing strings.Builder
+	for i, prompt := range prompts {
+		names = append(names, prompt.Name)
+		if i >= maxListedItems {
+			continue
+		}
+		listing.WriteString(fmt.Sprintf("\n- %s", prompt.Name))
+		if prompt.Description != "" {
+			listing.WriteString(": " + prompt.Description)
+		}
+		for _, argument := range prompt.Arguments {
+			required := ""
+			if argument.Required {
+				required = ", required"
+			}
+			listing.WriteString(fmt.Sprintf(" [%s%s]", argument.Name, required))
+		}
+	}
+	if len(prompts) > maxListedItems {
+		listing.WriteString(fmt.Sprintf("\n- and %d more", len(prompts)-maxListedItems))
+	}
+
+	return FunctionTool{
+		ToolName:        mcpToolName(server, "get_prompt"),
+		ToolDescription: fmt.Sprintf("Get a prompt template from %s, rendered with the given arguments. Available prompts:%s", server, listing.String()),
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"name": map[string]any{"type": "string", "enum": names, "description": "The name of the prompt."},
+				"arguments": map[string]any{
+					"type":                 "object",
+					"additionalProperties": map[string]any{"type": "string"},
+					"description":          "The prompt's arguments.",
+				},
+			},
+			"required": []string{"name"},
+		},
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			var args struct {
+				Name      string            `json:"name"`
+				Arguments map[string]string `json:"arguments"`
+			}
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil || args.Name == "" {
+				return "", fmt.Errorf("'name' is required")
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			client, err := conn.session(ctx)
+			if err != nil {
+				return "", err
+			}
+			result, err := client.GetPrompt(ctx, args.Name, args.Arguments)
+			if err != nil {
+				return "", err
+			}
+			var rendered strings.Builder
+			for _, message := range result.Messages {
+				rendered.WriteString(fmt.Sprintf("%s: %s\n", message.Role, formatMCPContent([]mcp.Content{message.Content})))
+			}
+			return strings.TrimSpace(rendered.String()), nil
+		},
+	}
+}
+
+// formatMCPContent renders content as text for the model. Binary content is described, not included.
+func formatMCPContent(contents []mcp.Content) string {
+	parts := make([]string, 0, len(contents))
+	for _, content := range contents {
+		switch {
+		case content.Type == "text":
+			parts = append(parts, content.Text)
+		case content.Resource != nil && content.Resource.Text != "":
+			parts = append(parts, content.Resource.Text)
+		case content.Resource != nil:
+			parts = append(parts, fmt.Sprintf("[binary resource %s (%s)]", content.Resource.URI, content.Resource.MimeType))
+		default:
+			parts = append(parts, fmt.Sprintf("[%s content (%s)]", content.Type, content.MimeType))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// LoadMCPServers reads per-org MCP server definitions from a JSON file mapping org IDs to
+// server configurations, and connects to them. Servers that cannot be reached are logged
+// and skipped so one unavailable server does not block the others.
+func (am *AgentManager) LoadMCPServers(ctx context.Context, path string) error {
+	log := logger.GetLogger()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read mcp servers file: %w", err)
+	}
+
+	var configs map[string][]MCPServerConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return fmt.Errorf("failed to parse mcp servers file: %w", err)
+	}
+
+	for orgID, servers := range configs {
+		for _, config := range servers {
+			if err := am.ConnectMCPServer(ctx, orgID, config); err != nil {
+				log.Error().Err(err).Msgf("Failed to connect MCP server for org %s.", orgID)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/blog/conversational-agent/internal/mcp"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// threadResourcePrefix is the URI scheme of thread history resources.
+const threadResourcePrefix = "thread://"
+
+// MCPServerInstructions tells MCP clients what the server offers.
+const MCPServerInstructions = "Ask the conversational agent questions, search the organization's knowledge base, " +
+	"and add documents to it. Thread histories are available as resources."
+
+// mcpSession exposes the agent to an MCP client on behalf of one user of one org.
+type mcpSession struct {
+	am     *AgentManager
+	userID string
+	orgID  string
+}
+
+var _ mcp.ServerHandler = (*mcpSession)(nil)
+
+// MCPServerHandler returns the tools and resources exposed to an MCP client acting for the user.
+func (am *AgentManager) MCPServerHandler(orgID, userID string) mcp.ServerHandler {
+	return &mcpSession{am: am, userID: userID, orgID: orgID}
+}
+
+// scope attaches the session's tenant to the context, as Query does for its tools.
+func (s *mcpSession) scope(ctx context.Context) context.Context {
+	return withQueryScope(ctx, queryScope{UserID: s.userID, OrgID: s.orgID})
+}
+
+// tools returns the function tools backing the MCP tools.
+func (s *mcpSession) tools() []FunctionTool {
+	return []FunctionTool{
+		s.queryTool(),
+		s.am.knowledgeSearchTool(),
+		s.am.metadataSearchTool(),
+		s.addDocumentTool(),
+	}
+}
+
+func (s *mcpSession) ListTools(_ context.Context) ([]mcp.Tool, error) {
+	list := []mcp.Tool{}
+	for _, tool := range s.tools() {
+		list = append(list, mcp.Tool{
+			Name:        tool.Name(),
+			Description: tool.Description(),
+			InputSchema: tool.Schema(),
+		})
+	}
+	return list, nil
+}
+
+func (s *mcpSession) CallTool(ctx context.Context, name string, arguments json.RawMessage) (*mcp.CallToolResult, error) {
+	log := logger.GetLogger()
+
+	for _, tool := range s.tools() {
+		if tool.Name() != name {
+			continue
+		}
+
+		if len(arguments) == 0 {
+			arguments = json.RawMessage("{}")
+		}
+		output, err := tool.Call(s.scope(ctx), string(arguments))
+		if err != nil {
+			log.Warn().Err(err).Msgf("MCP tool %s failed for org %s.", name, s.orgID)
+			return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent(err.Error())}, IsError: true}, nil
+		}
+		return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent(output)}}, nil
+	}
+	return nil, &mcp.RPCError{Code: mcp.CodeInvalidParams, Message: "unknown tool: " + name}
+}
+
+func (s *mcpSession) ListResources(_ context.Context) ([]mcp.Resource, error) {
+	resources := []mcp.Resource{}
+	for _, threadID := range s.am.ThreadsForUser(s.orgID, s.userID) {
+		resources = append(resources, mcp.Resource{
+			URI:         threadResourcePrefix + threadID,
+			Name:        "Thread " + threadID,
+			Description: "Chat history of the thread.",
+			MimeType:    "application/json",
+		})
+	}
+	return resources, nil
+}
+
+func (s *mcpSession) ReadResource(ctx context.Context, uri string) (*mcp.ReadResourceResult, error) {
+	threadID := strings.TrimPrefix(uri, threadResourcePrefix)
+	if threadID == uri || threadID == "" {
+		return nil, &mcp.RPCError{Code: mcp.CodeInvalidParams, Message: "unknown resource: " + uri}
+	}
+
+	// Only the thread's owner may read it; unknown and foreign threads look the same
+	userID, orgID, ok := s.am.ThreadOwner(threadID)
+	if !ok || userID != s.userID || orgID != s.orgID {
+		return nil, &mcp.RPCError{Code: mcp.CodeInvalidParams, Message: "unknown resource: " + uri}
+	}
+
+	messages, err := s.am.RetrieveMemory(ctx, threadID)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thread: %w", err)
+	}
+	return &mcp.ReadResourceResult{Contents: []mcp.ResourceContents{{
+		URI:      uri,
+		MimeType: "application/json",
+		Text:     string(encoded),
+	}}}, nil
+}
+
+// queryTool asks the agent a question in one of the user's threads.
+func (s *mcpSession) queryTool() FunctionTool {
+	return FunctionTool{
+		ToolName: "query",
+		ToolDescription: "Ask the conversational agent a question. It answers from the organization's knowledge base " +
+			"and the conversation so far in the thread.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query":     map[string]any{"type": "string", "description": "The question."},
+				"thread_id": map[string]any{"type": "string", "description": "The thread to continue. Defaults to a thread for MCP clients."},
+				"mode": map[string]any{
+					"type":        "string",
+					"enum":        []string{ModeChain, ModeAgent, ModeTools},
+					"description": "How the agent answers. Defaults to chain.",
+				},
+			},
+			"required": []string{"query"},
+		},
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			var args struct {
+				Query    string `json:"query"`
+				ThreadID string `json:"thread_id"`
+				Mode     string `json:"mode"`
+			}
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			if args.Query == "" {
+				return "", fmt.Errorf("'query' is required")
+			}
+			if args.ThreadID == "" {
+				args.ThreadID = "mcp:" + s.userID
+			}
+
+			// Threads belong to the user who started them
+			if userID, orgID, ok := s.am.ThreadOwner(args.ThreadID); ok && (userID != s.userID || orgID != s.orgID) {
+				return "", fmt.Errorf("thread %s belongs to another user", args.ThreadID)
+			}
+
+			var options []QueryOption
+			switch args.Mode {
+			case "", ModeChain:
+			case ModeAgent, ModeTools:
+				options = append(options, WithMode(args.Mode))
+			default:
+				return "", fmt.Errorf("'mode' must be 'chain', 'agent' or 'tools'")
+			}
+
+			return s.am.Query(ctx, s.userID, s.orgID, args.ThreadID, args.Query, nil, options...)
+		},
+	}
+}
+
+// addDocumentTool adds a document to the org's knowledge base.
+func (s *mcpSession) addDocumentTool() FunctionTool {
+	return FunctionTool{
+		ToolName:        "add_document",
+		ToolDescription: "Add a document to the organization's knowledge base. Long documents are split into chunks.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"content": map[string]any{"type": "string", "description": "The text of the document."},
+				"metadata": map[string]any{
+					"type":                 "object",
+					"additionalProperties": map[string]any{"type": "string"},
+					"description":          "Metadata stored with the document, such as its source.",
+				},
+			},
+			"required": []string{"content"},
+		},
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			var args struct {
+				Content  string            `json:"content"`
+				Metadata map[string]string `json:"metadata"`
+			}
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			if strings.TrimSpace(args.Content) == "" {
+				return "", fmt.Errorf("'content' is required")
+			}
+
+			metadata := ConvertMetadata(args.Metadata)
+			metadata["org_id"] = s.orgID
+			doc := schema.Document{PageContent: args.Content, Metadata: metadata}
+			if err := s.am.AddDocuments(ctx, []schema.Document{doc}, s.userID, s.orgID); err != nil {
+				return "", err
+			}
+			return "Document added to the knowledge base.", nil
+		},
+	}
+}
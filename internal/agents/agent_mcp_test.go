@@ -0,0 +1,140 @@
+package agents
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/blog/conversational-agent/internal/mcp"
+)
+
+// mcpTestServerEnv makes the test binary run as a stdio MCP server.
+const mcpTestServerEnv = "AGENTS_MCP_TEST_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(mcpTestServerEnv) == "1" {
+		serveTestMCP()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// testMCPHandler offers an "echo" tool and a "crash" tool that exits the server.
+type testMCPHandler struct{}
+
+func (testMCPHandler) ListTools(context.Context) ([]mcp.Tool, error) {
+	return []mcp.Tool{
+		{Name: "echo", Description: "Echoes its input", InputSchema: map[string]any{"type": "object"}},
+		{Name: "crash", Description: "Exits the server", InputSchema: map[string]any{"type": "object"}},
+	}, nil
+}
+
+func (testMCPHandler) CallTool(_ context.Context, name string, arguments json.RawMessage) (*mcp.CallToolResult, error) {
+	if name == "crash" {
+		os.Exit(1)
+	}
+	return &mcp.CallToolResult{Content: []mcp.Content{{Type: "text", Text: string(arguments)}}}, nil
+}
+
+func (testMCPHandler) ListResources(context.Context) ([]mcp.Resource, error) {
+	return nil, nil
+}
+
+func (testMCPHandler) ReadResource(context.Context, string) (*mcp.ReadResourceResult, error) {
+	return &mcp.ReadResourceResult{}, nil
+}
+
+func serveTestMCP() {
+	server := &mcp.Server{Info: mcp.Implementation{Name: "test", Version: "1"}}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var msg mcp.Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		if response := server.HandleMessage(context.Background(), testMCPHandler{}, &msg); response != nil {
+			encoded, _ := json.Marshal(response)
+			os.Stdout.Write(append(encoded, '\n'))
+		}
+	}
+}
+
+func TestMCPToolName(t *testing.T) {
+	long := strings.Repeat("a", 70)
+
+	tests := []struct {
+		name   string
+		server string
+		tool   string
+		want   string
+	}{
+		{name: "plain", server: "github", tool: "create_issue", want: "github_create_issue"},
+		{name: "invalid characters", server: "my server", tool: "files.read", want: "my_server_files_read"},
+		{name: "exactly the limit", server: "s", tool: strings.Repeat("t", 62), want: "s_" + strings.Repeat("t", 62)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mcpToolName(tt.server, tt.tool); got != tt.want {
+				t.Fatalf("mcpToolName(%q, %q) = %q, want %q", tt.server, tt.tool, got, tt.want)
+			}
+		})
+	}
+
+	first, second := mcpToolName("server", long+"_one"), mcpToolName("server", long+"_two")
+	if len(first) != maxToolNameLength || len(second) != maxToolNameLength {
+		t.Fatalf("truncated names have lengths %d and %d, want %d", len(first), len(second), maxToolNameLength)
+	}
+	if first == second {
+		t.Fatalf("names sharing a long prefix collide: %s", first)
+	}
+	if first != mcpToolName("server", long+"_one") {
+		t.Fatal("truncated names are not stable")
+	}
+}
+
+func TestMCPStdioReconnect(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	am := &AgentManager{Tools: NewToolRegistry()}
+	err = am.ConnectMCPServer(context.Background(), "acme", MCPServerConfig{
+		Name:    "local",
+		Command: executable,
+		Env:     map[string]string{mcpTestServerEnv: "1"},
+	})
+	if err != nil {
+		t.Fatalf("ConnectMCPServer returned %v", err)
+	}
+	t.Cleanup(am.CloseMCPServers)
+
+	echo, ok := am.Tools.Get("acme", "local_echo")
+	if !ok {
+		t.Fatal("echo tool is not registered")
+	}
+	crash, ok := am.Tools.Get("acme", "local_crash")
+	if !ok {
+		t.Fatal("crash tool is not registered")
+	}
+
+	ctx := context.Background()
+	if got, err := echo.Call(ctx, `{"n":1}`); err != nil || got != `{"n":1}` {
+		t.Fatalf("echo returned %q, %v", got, err)
+	}
+	if _, err := crash.Call(ctx, `{}`); err == nil {
+		t.Fatal("call that stopped the server succeeded")
+	}
+	// The next call starts the server again
+	if got, err := echo.Call(ctx, `{"n":2}`); err != nil || got != `{"n":2}` {
+		t.Fatalf("echo after the server exited returned %q, %v", got, err)
+	}
+
+	am.CloseMCPServers()
+	if _, err := echo.Call(ctx, `{"n":3}`); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Fatalf("echo after close returned %v, want a closed error", err)
+	}
+}
@@ -0,0 +1,118 @@
+package agents
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// rerankMMR selects up to k documents balancing relevance to the input against
+// similarity to documents already selected. A lambda of 1 ranks purely by relevance,
+// a lambda of 0 purely by diversity. The documents must come from a search returning
+// their vectors; relevance is taken from their certainty, so no embeddings are requested.
+func rerankMMR(docs []schema.Document, k int, lambda float64) ([]schema.Document, error) {
+	// Exact duplicates never add information
+	docs = deduplicateDocuments(docs)
+	defer stripDocumentVectors(docs)
+	if len(docs) <= 1 {
+		return docs, nil
+	}
+
+	relevance := make([]float64, len(docs))
+	vectors := make([][]float32, len(docs))
+	for i, doc := range docs {
+		vector, ok := documentVector(doc)
+		if !ok {
+			return nil, fmt.Errorf("search result has no vector")
+		}
+		vectors[i] = vector
+		// Certainty is (1 + cosine similarity) / 2 for the cosine distance
+		relevance[i] = 2*float64(doc.Score) - 1
+	}
+
+	selected := maximalMarginalRelevance(relevance, vectors, k, lambda)
+	reranked := make([]schema.Document, 0, len(selected))
+	for _, idx := range selected {
+		reranked = append(reranked, docs[idx])
+	}
+	return reranked, nil
+}
+
+// documentVector returns the vector Weaviate returned with a search result.
+func documentVector(doc schema.Document) ([]float32, bool) {
+	additional, ok := doc.Metadata["_additional"].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	values, ok := additional["vector"].([]any)
+	if !ok || len(values) == 0 {
+		return nil, false
+	}
+	vector := make([]float32, len(values))
+	for i, v := range values {
+		f, ok := v.(float64)
+		if !ok {
+			return nil, false
+		}
+		vector[i] = float32(f)
+	}
+	return vector, true
+}
+
+// stripDocumentVectors removes the search result vectors so they are not returned as
+// source metadata or added to the prompt.
+func stripDocumentVectors(docs []schema.Document) {
+	for _, doc := range docs {
+		if additional, ok := doc.Metadata["_additional"].(map[string]any); ok {
+			delete(additional, "vector")
+		}
+	}
+}
+
+// maximalMarginalRelevance returns the indices of the selected vectors in selection order,
+// given the cosine similarity of each vector to the query.
+func maximalMarginalRelevance(relevance []float64, vectors [][]float32, k int, lambda float64) []int {
+	if k > len(vectors) {
+		k = len(vectors)
+	}
+
+	selected := make([]int, 0, k)
+	used := make([]bool, len(vectors))
+	for len(selected) < k {
+		best, bestScore := -1, math.Inf(-1)
+		for i, v := range vectors {
+			if used[i] {
+				continue
+			}
+			redundancy := 0.0
+			for n, j := range selected {
+				if sim := cosineSimilarity(v, vectors[j]); n == 0 || sim > redundancy {
+					redundancy = sim
+				}
+			}
+			score := lambda*relevance[i] - (1-lambda)*redundancy
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		used[best] = true
+		selected = append(selected, best)
+	}
+
+	return selected
+}
+
+// cosineSimilarity returns the cosine similarity of two vectors, or 0 if either is empty.
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := 0; i < len(a) && i < len(b); i++ {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,68 @@
+package agents
+
+import (
+	"testing"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+func mmrDocument(content string, certainty float32, vector ...float64) schema.Document {
+	values := make([]any, len(vector))
+	for i, v := range vector {
+		values[i] = v
+	}
+	return schema.Document{
+		PageContent: content,
+		Score:       certainty,
+		Metadata:    map[string]any{"_additional": map[string]any{"vector": values, "certainty": float64(certainty)}},
+	}
+}
+
+func TestRerankMMR(t *testing.T) {
+	candidates := func() []schema.Document {
+		return []schema.Document{
+			mmrDocument("a", 0.95, 1, 0),
+			mmrDocument("a-copy", 0.94, 1, 0.01),
+			mmrDocument("b", 0.80, 0, 1),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		lambda float64
+		want   []string
+	}{
+		{name: "relevance only", lambda: 1, want: []string{"a", "a-copy"}},
+		{name: "balanced prefers diverse", lambda: 0.5, want: []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs, err := rerankMMR(candidates(), 2, tt.lambda)
+			if err != nil {
+				t.Fatalf("rerankMMR returned %v", err)
+			}
+			if len(docs) != len(tt.want) {
+				t.Fatalf("got %d documents, want %d", len(docs), len(tt.want))
+			}
+			for i, doc := range docs {
+				if doc.PageContent != tt.want[i] {
+					t.Errorf("document %d = %q, want %q", i, doc.PageContent, tt.want[i])
+				}
+				if _, ok := doc.Metadata["_additional"].(map[string]any)["vector"]; ok {
+					t.Errorf("document %q still carries its vector", doc.PageContent)
+				}
+			}
+		})
+	}
+}
+
+func TestRerankMMRRequiresVectors(t *testing.T) {
+	docs := []schema.Document{
+		{PageContent: "a", Score: 0.9, Metadata: map[string]any{}},
+		{PageContent: "b", Score: 0.8, Metadata: map[string]any{}},
+	}
+	if _, err := rerankMMR(docs, 1, 0.5); err == nil {
+		t.Fatal("rerankMMR accepted documents without vectors")
+	}
+}
@@ -0,0 +1,271 @@
+package agents
+
+import (
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Query modes.
+const (
+	ModeChain = "chain"
+	ModeAgent = "agent"
+	ModeTools = "tools"
+)
+
+// Conversation memory scopes.
+const (
+	MemoryScopeUser   = "user"
+	MemoryScopeThread = "thread"
+)
+
+// RetrievalConfig holds the default settings for each retrieval source.
+// A zero half-life disables recency weighting for that source. Curated corrections are
+// searched apart, and their scores multiplied by the correction boost.
+type RetrievalConfig struct {
+	KnowledgeEnabled   bool
+	KnowledgeTopK      int
+	KnowledgeHalfLife  time.Duration
+	MMREnabled         bool
+	MMRLambda          float64
+	CorrectionTopK     int
+	CorrectionBoost    float64
+	Expansion          string
+	NeighborWindow     int
+	ContextTokenBudget int
+	MemoryEnabled      bool
+	MemoryTopK         int
+	MemoryScope        string
+	MemoryHalfLife     time.Duration
+}
+
+// DefaultRetrievalConfig returns the retrieval settings used when none are configured.
+func DefaultRetrievalConfig() RetrievalConfig {
+	return RetrievalConfig{
+		KnowledgeEnabled:   true,
+		KnowledgeTopK:      5,
+		MMRLambda:          0.5,
+		CorrectionTopK:     defaultCorrectionTopK,
+		CorrectionBoost:    defaultCorrectionBoost,
+		Expansion:          ExpansionParent,
+		NeighborWindow:     1,
+		ContextTokenBudget: 3000,
+		MemoryEnabled:      true,
+		MemoryTopK:         3,
+		MemoryScope:        MemoryScopeUser,
+	}
+}
+
+// QueryOptions holds the per-request settings for Query.
+type QueryOptions struct {
+	Filter        *MetadataFilter
+	MMRLambda     *float64
+	Retrieval     RetrievalConfig
+	Mode          string
+	MaxIterations int
+	StepCallback  func(AgentStep)
+	Routing       bool
+	Agent         string
+
+	// Structured output
+	ResponseSchema    *JSONSchema
+	StructuredRetries int
+
+	// Instructions added after the persona, and callbacks receiving the sources, token usage
+	// and message ID of the answer
+	Instructions    string
+	UsageCallback   func(Usage)
+	SourcesCallback func([]Source)
+	MessageCallback func(messageID string)
+
+	// Message of the thread's history to answer from, on a new branch
+	BranchFrom *string
+
+	// Settings of the routed sub-agent
+	agentName    string
+	systemPrompt string
+	namespaces   []string
+	toolNames    []string
+	llm          llms.Model
+	model        string
+
+	// Prompt versions selected for the query, and the usage of its model calls
+	prompts queryPrompts
+	usage   *usageTracker
+}
+
+// QueryOption configures a single Query call.
+type QueryOption func(*QueryOptions)
+
+// WithBranchFrom answers from the given message of the thread's history instead of its last
+// one, starting a new branch. An empty ID branches from the start of the thread.
+func WithBranchFrom(messageID string) QueryOption {
+	return func(o *QueryOptions) {
+		o.BranchFrom = &messageID
+	}
+}
+
+// WithMetadataFilter restricts retrieval to documents matching the filter.
+func WithMetadataFilter(filter *MetadataFilter) QueryOption {
+	return func(o *QueryOptions) {
+		o.Filter = filter
+	}
+}
+
+// WithMMR diversifies the retrieved documents using maximal marginal relevance.
+func WithMMR(lambda float64) QueryOption {
+	return func(o *QueryOptions) {
+		o.MMRLambda = &lambda
+	}
+}
+
+// mmrLambda returns the MMR lambda of the query, falling back to the configured default.
+func (o QueryOptions) mmrLambda() (float64, bool) {
+	if o.MMRLambda != nil {
+		return *o.MMRLambda, true
+	}
+	return o.Retrieval.MMRLambda, o.Retrieval.MMREnabled
+}
+
+// WithKnowledgeEnabled toggles retrieval from the org and default knowledge bases.
+func WithKnowledgeEnabled(enabled bool) QueryOption {
+	return func(o *QueryOptions) {
+		o.Retrieval.KnowledgeEnabled = enabled
+	}
+}
+
+// WithKnowledgeTopK sets the number of knowledge documents retrieved per namespace.
+func WithKnowledgeTopK(topK int) QueryOption {
+	return func(o *QueryOptions) {
+		o.Retrieval.KnowledgeTopK = topK
+	}
+}
+
+// WithKnowledgeHalfLife sets the recency half-life applied to knowledge document scores.
+func WithKnowledgeHalfLife(halfLife time.Duration) QueryOption {
+	return func(o *QueryOptions) {
+		o.Retrieval.KnowledgeHalfLife = halfLife
+	}
+}
+
+// WithExpansion sets how knowledge hits are expanded: to their parent, their neighbours, or not at all.
+func WithExpansion(mode string) QueryOption {
+	return func(o *QueryOptions) {
+		o.Retrieval.Expansion = mode
+	}
+}
+
+// WithMemoryEnabled toggles retrieval of past conversation memory.
+func WithMemoryEnabled(enabled bool) QueryOption {
+	return func(o *QueryOptions) {
+		o.Retrieval.MemoryEnabled = enabled
+	}
+}
+
+// WithMemoryTopK sets the number of past conversation chunks retrieved.
+func WithMemoryTopK(topK int) QueryOption {
+	return func(o *QueryOptions) {
+		o.Retrieval.MemoryTopK = topK
+	}
+}
+
+// WithMemoryScope restricts conversation memory to the same user or the same thread.
+func WithMemoryScope(scope string) QueryOption {
+	return func(o *QueryOptions) {
+		o.Retrieval.MemoryScope = scope
+	}
+}
+
+// WithMemoryHalfLife sets the recency half-life applied to conversation memory scores.
+func WithMemoryHalfLife(halfLife time.Duration) QueryOption {
+	return func(o *QueryOptions) {
+		o.Retrieval.MemoryHalfLife = halfLife
+	}
+}
+
+// WithMode selects between the single-shot chain, the ReAct agent and the native tool-calling agent.
+func WithMode(mode string) QueryOption {
+	return func(o *QueryOptions) {
+		o.Mode = mode
+	}
+}
+
+// WithStepCallback receives the intermediate steps of the agent loop.
+func WithStepCallback(callback func(AgentStep)) QueryOption {
+	return func(o *QueryOptions) {
+		o.StepCallback = callback
+	}
+}
+
+// WithRouting toggles routing the query to a sub-agent by intent.
+func WithRouting(enabled bool) QueryOption {
+	return func(o *QueryOptions) {
+		o.Routing = enabled
+	}
+}
+
+// WithAgent sends the query to the named sub-agent without classifying it.
+func WithAgent(name string) QueryOption {
+	return func(o *QueryOptions) {
+		o.Agent = name
+	}
+}
+
+// WithResponseSchema asks for a JSON answer matching the schema, validated and retried on mismatch.
+// Structured answers are generated from the chain context whatever the mode.
+func WithResponseSchema(schema *JSONSchema) QueryOption {
+	return func(o *QueryOptions) {
+		o.ResponseSchema = schema
+	}
+}
+
+// WithStructuredRetries sets how many corrections are asked for when an answer does not match the schema.
+func WithStructuredRetries(retries int) QueryOption {
+	return func(o *QueryOptions) {
+		o.StructuredRetries = retries
+	}
+}
+
+// WithInstructions adds instructions for the model after the org's persona.
+func WithInstructions(instructions string) QueryOption {
+	return func(o *QueryOptions) {
+		o.Instructions = instructions
+	}
+}
+
+// WithUsageCallback receives the tokens consumed by the query's model calls once it finishes.
+func WithUsageCallback(callback func(Usage)) QueryOption {
+	return func(o *QueryOptions) {
+		o.UsageCallback = callback
+	}
+}
+
+// WithMessageCallback receives the message ID of the answer, to give feedback on it.
+func WithMessageCallback(callback func(messageID string)) QueryOption {
+	return func(o *QueryOptions) {
+		o.MessageCallback = callback
+	}
+}
+
+// WithSourcesCallback receives the knowledge documents retrieved for the query.
+func WithSourcesCallback(callback func([]Source)) QueryOption {
+	return func(o *QueryOptions) {
+		o.SourcesCallback = callback
+	}
+}
+
+// getQueryOptions applies the given options over the manager's defaults.
+func (am *AgentManager) getQueryOptions(options ...QueryOption) QueryOptions {
+	opts := QueryOptions{
+		Retrieval:     am.Retrieval,
+		Mode:          ModeChain,
+		MaxIterations: am.MaxAgentIterations,
+		Routing:       am.RoutingEnabled,
+
+		StructuredRetries: am.StructuredOutputRetries,
+	}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	return opts
+}
@@ -0,0 +1,157 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/google/uuid"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/vectorstores"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+)
+
+// Expansion modes for knowledge hits.
+const (
+	ExpansionNone      = "none"
+	ExpansionParent    = "parent"
+	ExpansionNeighbors = "neighbors"
+)
+
+const (
+	// defaultChunkWords is the size of the chunks documents are split into at ingestion.
+	defaultChunkWords = 100
+	// maxParentChunks bounds the number of chunks fetched to rebuild a parent document.
+	maxParentChunks = 100
+)
+
+// ChunkDocuments splits each document into chunks of at most maxWords words.
+// Every chunk keeps the document's metadata plus a shared parent_id and its chunk_index.
+func ChunkDocuments(docs []schema.Document, maxWords int) []schema.Document {
+	var chunks []schema.Document
+	for _, doc := range docs {
+		parentID := uuid.NewString()
+		for i, chunk := range ChunkContent(doc.PageContent, maxWords) {
+			metadata := make(map[string]any, len(doc.Metadata)+2)
+			for k, v := range doc.Metadata {
+				metadata[k] = v
+			}
+			metadata["parent_id"] = parentID
+			metadata["chunk_index"] = i
+
+			chunks = append(chunks, schema.Document{
+				PageContent: chunk,
+				Metadata:    metadata,
+			})
+		}
+	}
+	return chunks
+}
+
+// expandToParents replaces chunk hits with their parent document, or with the neighbouring
+// chunks when the parent does not fit, keeping the expanded context within the token budget.
+func (am *AgentManager) expandToParents(ctx context.Context, docs []schema.Document, opts QueryOptions) []schema.Document {
+	log := logger.GetLogger()
+
+	mode := opts.Retrieval.Expansion
+	if mode == ExpansionNone || mode == "" || opts.Retrieval.ContextTokenBudget <= 0 {
+		return docs
+	}
+
+	used := 0
+	expanded := make([]schema.Document, 0, len(docs))
+	seenParents := make(map[string]bool)
+	for _, doc := range docs {
+		candidates := []string{doc.PageContent}
+
+		parentID, _ := doc.Metadata["parent_id"].(string)
+		if parentID != "" {
+			if seenParents[parentID] {
+				continue
+			}
+
+			chunks, err := am.fetchParentChunks(ctx, doc, parentID)
+			if err != nil {
+				log.Warn().Err(err).Msgf("Failed to expand parent %s, keeping the matching chunk.", parentID)
+			} else if index, ok := metadataInt(doc.Metadata["chunk_index"]); ok {
+				neighbors := joinChunks(chunks, index-opts.Retrieval.NeighborWindow, index+opts.Retrieval.NeighborWindow)
+				candidates = []string{neighbors, doc.PageContent}
+				if mode == ExpansionParent {
+					candidates = append([]string{joinChunks(chunks, 0, len(chunks))}, candidates...)
+				}
+			}
+		}
+
+		// Use the widest candidate that still fits in the budget
+		for _, text := range candidates {
+			if text == "" {
+				continue
+			}
+			tokens := estimateTokens(text)
+			if used+tokens > opts.Retrieval.ContextTokenBudget {
+				continue
+			}
+			used += tokens
+			doc.PageContent = text
+			expanded = append(expanded, doc)
+			if parentID != "" {
+				seenParents[parentID] = true
+			}
+			break
+		}
+	}
+
+	log.Debug().Msgf("Expanded %d hits into %d documents using ~%d tokens", len(docs), len(expanded), used)
+	return expanded
+}
+
+// fetchParentChunks returns every chunk sharing the parent_id of doc, ordered by chunk_index.
+func (am *AgentManager) fetchParentChunks(ctx context.Context, doc schema.Document, parentID string) ([]schema.Document, error) {
+	namespace, _ := doc.Metadata["nameSpace"].(string)
+	parentFilter := filters.Where().WithPath([]string{"parent_id"}).WithOperator(filters.Equal).WithValueString(parentID)
+
+	chunks, err := am.VectorStore.MetadataSearch(ctx, maxParentChunks,
+		vectorstores.WithNameSpace(namespace),
+		vectorstores.WithFilters(parentFilter),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chunks for parent %s: %w", parentID, err)
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool {
+		a, _ := metadataInt(chunks[i].Metadata["chunk_index"])
+		b, _ := metadataInt(chunks[j].Metadata["chunk_index"])
+		return a < b
+	})
+	return chunks, nil
+}
+
+// joinChunks joins the chunks whose chunk_index lies within [from, to].
+func joinChunks(chunks []schema.Document, from, to int) string {
+	var parts []string
+	for _, chunk := range chunks {
+		index, ok := metadataInt(chunk.Metadata["chunk_index"])
+		if ok && index >= from && index <= to {
+			parts = append(parts, chunk.PageContent)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// metadataInt reads an integer metadata value, which comes back from the store as a float64.
+func metadataInt(value any) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// estimateTokens approximates the token count of a text at four characters per token.
+func estimateTokens(text string) int {
+	return len([]rune(text)) / 4
+}
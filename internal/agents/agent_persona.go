@@ -0,0 +1,173 @@
+package agents
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Persona field limits.
+const (
+	maxPersonaNameLength   = 100
+	maxPersonaPromptLength = 8000
+	maxPersonaStyleLength  = 1000
+)
+
+// personasFile is the file under the data directory holding the org personas.
+const personasFile = "personas.json"
+
+// Persona is the identity and style the assistant takes for an org.
+type Persona struct {
+	Name         string    `json:"name"`
+	SystemPrompt string    `json:"system_prompt,omitempty"`
+	Tone         string    `json:"tone,omitempty"`
+	AnswerFormat string    `json:"answer_format,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at,omitempty"`
+}
+
+// DefaultPersona returns the persona used for orgs without one.
+func DefaultPersona() Persona {
+	return Persona{
+		Name:         "Assistant",
+		SystemPrompt: "You are a helpful assistant. Answer using the relevant documents and the conversation so far.",
+	}
+}
+
+// Validate checks that the persona has a name and that its fields are within limits.
+func (p Persona) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("persona 'name' is required")
+	}
+	if len(p.Name) > maxPersonaNameLength {
+		return fmt.Errorf("persona 'name' must be at most %d characters", maxPersonaNameLength)
+	}
+	if len(p.SystemPrompt) > maxPersonaPromptLength {
+		return fmt.Errorf("persona 'system_prompt' must be at most %d characters", maxPersonaPromptLength)
+	}
+	if len(p.Tone) > maxPersonaStyleLength || len(p.AnswerFormat) > maxPersonaStyleLength {
+		return fmt.Errorf("persona 'tone' and 'answer_format' must be at most %d characters", maxPersonaStyleLength)
+	}
+	return nil
+}
+
+// Instructions renders the persona as instructions for the model.
+func (p Persona) Instructions() string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Your name is %s.", p.Name))
+	if p.SystemPrompt != "" {
+		b.WriteString("\n" + p.SystemPrompt)
+	}
+	if p.Tone != "" {
+		b.WriteString("\nTone: " + p.Tone)
+	}
+	if p.AnswerFormat != "" {
+		b.WriteString("\nAnswer format: " + p.AnswerFormat)
+	}
+	return b.String()
+}
+
+// PersonaStore keeps the org personas, persisted to a JSON file when it has a data directory.
+type PersonaStore struct {
+	path     string
+	mutex    sync.RWMutex
+	personas map[string]Persona
+}
+
+// NewPersonaStore loads the personas kept in the data directory. An empty directory keeps
+// them in memory only.
+func NewPersonaStore(dataDir string) (*PersonaStore, error) {
+	store := &PersonaStore{personas: make(map[string]Persona)}
+	if dataDir == "" {
+		return store, nil
+	}
+
+	store.path = filepath.Join(dataDir, personasFile)
+	if err := readJSONFile(store.path, &store.personas); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Get returns the org's persona.
+func (s *PersonaStore) Get(orgID string) (Persona, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	persona, ok := s.personas[orgID]
+	return persona, ok
+}
+
+// Set validates and stores the org's persona.
+func (s *PersonaStore) Set(orgID string, persona Persona) (Persona, error) {
+	if err := persona.Validate(); err != nil {
+		return Persona{}, err
+	}
+	persona.Name = strings.TrimSpace(persona.Name)
+	persona.UpdatedAt = time.Now().UTC()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, existed := s.personas[orgID]
+	s.personas[orgID] = persona
+	if err := s.save(); err != nil {
+		// Keep memory and file in agreement
+		if existed {
+			s.personas[orgID] = previous
+		} else {
+			delete(s.personas, orgID)
+		}
+		return Persona{}, err
+	}
+	return persona, nil
+}
+
+// Delete removes the org's persona so the default applies again.
+func (s *PersonaStore) Delete(orgID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, existed := s.personas[orgID]
+	if !existed {
+		return nil
+	}
+	delete(s.personas, orgID)
+	if err := s.save(); err != nil {
+		s.personas[orgID] = previous
+		return err
+	}
+	return nil
+}
+
+// save writes the personas to the store's file. The caller holds the lock.
+func (s *PersonaStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	return writeJSONFile(s.path, s.personas)
+}
+
+// personaFor returns the org's persona, or the default.
+func (am *AgentManager) personaFor(orgID string) Persona {
+	if am.Personas != nil {
+		if persona, ok := am.Personas.Get(orgID); ok {
+			return persona
+		}
+	}
+	return DefaultPersona()
+}
+
+// instructionsFor combines the org's persona with the routed sub-agent's system prompt and
+// the query's own instructions.
+func (am *AgentManager) instructionsFor(orgID string, opts QueryOptions) string {
+	instructions := am.personaFor(orgID).Instructions()
+	if opts.systemPrompt != "" {
+		instructions += "\n\n" + opts.systemPrompt
+	}
+	if opts.Instructions != "" {
+		instructions += "\n\n" + opts.Instructions
+	}
+	return instructions
+}
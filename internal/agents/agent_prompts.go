@@ -0,0 +1,378 @@
+package agents
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/blog/conversational-agent/internal/prompts"
+)
+
+// Prompt templates managed by the registry.
+const (
+	PromptChain             = "chain"
+	PromptReActPrefix       = "react_prefix"
+	PromptReActInstructions = "react_format_instructions"
+	PromptReActSuffix       = "react_suffix"
+)
+
+// promptsFile is the file under the data directory holding the prompt registry.
+const promptsFile = "prompts.json"
+
+// maxPromptTemplateLength bounds the size of a single template.
+const maxPromptTemplateLength = 20000
+
+// reactPromptVariables are the variables of the ReAct prompt, whose parts are rendered together.
+var reactPromptVariables = []string{"instructions", "tool_descriptions", "tool_names", "history", "input", "agent_scratchpad"}
+
+// builtinPrompts are the compiled-in templates, served as version 0 of each prompt, with the
+// variables they may use and those every version must use.
+var builtinPrompts = map[string]struct {
+	template  string
+	variables []string
+	required  []string
+}{
+	PromptChain:             {prompts.ChainTemplate, []string{"context"}, []string{"context"}},
+	PromptReActPrefix:       {prompts.ConversationalPrefix, reactPromptVariables, []string{"instructions", "tool_descriptions"}},
+	PromptReActInstructions: {prompts.FormatInstructions, reactPromptVariables, []string{"tool_names"}},
+	PromptReActSuffix:       {prompts.ConversationalSuffix, reactPromptVariables, []string{"input", "agent_scratchpad"}},
+}
+
+// PromptVersion is one version of a prompt template. Version 0 is the built-in template.
+type PromptVersion struct {
+	Name        string    `json:"name"`
+	Version     int       `json:"version"`
+	Template    string    `json:"template"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitempty"`
+}
+
+// ID identifies the version in logs and feedback, e.g. "chain@v2".
+func (p PromptVersion) ID() string {
+	return fmt.Sprintf("%s@v%d", p.Name, p.Version)
+}
+
+// PromptAssignment is the prompt version an org uses, optionally splitting its threads with a variant.
+type PromptAssignment struct {
+	Version int            `json:"version"`
+	Variant *PromptVariant `json:"variant,omitempty"`
+}
+
+// PromptVariant serves another version to a percentage of the org's threads.
+type PromptVariant struct {
+	Version int `json:"version"`
+	Percent int `json:"percent"`
+}
+
+// PromptRegistry keeps the versioned prompt templates and which versions each org uses,
+// persisted to a JSON file when it has a data directory.
+type PromptRegistry struct {
+	path  string
+	mutex sync.RWMutex
+
+	templates   map[string][]PromptVersion
+	assignments map[string]map[string]PromptAssignment
+}
+
+// promptRegistryFile is the stored form of the registry.
+type promptRegistryFile struct {
+	Templates   map[string][]PromptVersion             `json:"templates"`
+	Assignments map[string]map[string]PromptAssignment `json:"assignments"`
+}
+
+// NewPromptRegistry loads the registry kept in the data directory. An empty directory keeps
+// it in memory only.
+func NewPromptRegistry(dataDir string) (*PromptRegistry, error) {
+	registry := &PromptRegistry{
+		templates:   make(map[string][]PromptVersion),
+		assignments: make(map[string]map[string]PromptAssignment),
+	}
+	if dataDir == "" {
+		return registry, nil
+	}
+
+	registry.path = filepath.Join(dataDir, promptsFile)
+	var stored promptRegistryFile
+	if err := readJSONFile(registry.path, &stored); err != nil {
+		return nil, err
+	}
+	if stored.Templates != nil {
+		registry.templates = stored.Templates
+	}
+	if stored.Assignments != nil {
+		registry.assignments = stored.Assignments
+	}
+	return registry, nil
+}
+
+// Names lists the prompts in the registry.
+func (r *PromptRegistry) Names() []string {
+	names := make([]string, 0, len(builtinPrompts))
+	for name := range builtinPrompts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Versions lists every version of the prompt, starting with the built-in one.
+func (r *PromptRegistry) Versions(name string) ([]PromptVersion, error) {
+	builtin, ok := builtinPromptVersion(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown prompt: %s", name)
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return append([]PromptVersion{builtin}, r.templates[name]...), nil
+}
+
+// Version returns one version of the prompt.
+func (r *PromptRegistry) Version(name string, version int) (PromptVersion, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.version(name, version)
+}
+
+// version looks up a version of the prompt. The caller holds the lock.
+func (r *PromptRegistry) version(name string, version int) (PromptVersion, bool) {
+	if version == 0 {
+		return builtinPromptVersion(name)
+	}
+	for _, candidate := range r.templates[name] {
+		if candidate.Version == version {
+			return candidate, true
+		}
+	}
+	return PromptVersion{}, false
+}
+
+// AddVersion validates the template and stores it as the prompt's next version.
+func (r *PromptRegistry) AddVersion(name, text, description string) (PromptVersion, error) {
+	if err := validatePromptTemplate(name, text); err != nil {
+		return PromptVersion{}, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	previous := r.templates[name]
+	version := PromptVersion{
+		Name:        name,
+		Version:     len(previous) + 1,
+		Template:    text,
+		Description: description,
+		CreatedAt:   time.Now().UTC(),
+	}
+	r.templates[name] = append(previous, version)
+	if err := r.save(); err != nil {
+		r.templates[name] = previous
+		return PromptVersion{}, err
+	}
+	return version, nil
+}
+
+// Assignments returns the version each org uses for the prompt.
+func (r *PromptRegistry) Assignments(name string) map[string]PromptAssignment {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	assignments := make(map[string]PromptAssignment)
+	for orgID, orgAssignments := range r.assignments {
+		if assignment, ok := orgAssignments[name]; ok {
+			assignments[orgID] = assignment
+		}
+	}
+	return assignments
+}
+
+// Assign sets the version, and optional variant, of the prompt for the org.
+func (r *PromptRegistry) Assign(orgID, name string, assignment PromptAssignment) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.version(name, assignment.Version); !ok {
+		return fmt.Errorf("prompt %s has no version %d", name, assignment.Version)
+	}
+	if variant := assignment.Variant; variant != nil {
+		if _, ok := r.version(name, variant.Version); !ok {
+			return fmt.Errorf("prompt %s has no version %d", name, variant.Version)
+		}
+		if variant.Version == assignment.Version {
+			return fmt.Errorf("variant must use a different version than %d", assignment.Version)
+		}
+		if variant.Percent < 0 || variant.Percent > 100 {
+			return fmt.Errorf("variant percent must be between 0 and 100")
+		}
+	}
+
+	previous, existed := r.assignments[orgID][name]
+	if r.assignments[orgID] == nil {
+		r.assignments[orgID] = make(map[string]PromptAssignment)
+	}
+	r.assignments[orgID][name] = assignment
+	if err := r.save(); err != nil {
+		if existed {
+			r.assignments[orgID][name] = previous
+		} else {
+			delete(r.assignments[orgID], name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Unassign makes the org use the built-in version of the prompt again.
+func (r *PromptRegistry) Unassign(orgID, name string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	previous, existed := r.assignments[orgID][name]
+	if !existed {
+		return nil
+	}
+	delete(r.assignments[orgID], name)
+	if err := r.save(); err != nil {
+		r.assignments[orgID][name] = previous
+		return err
+	}
+	return nil
+}
+
+// Select returns the version of the prompt a thread of the org uses. A thread always
+// falls on the same side of a variant split.
+func (r *PromptRegistry) Select(orgID, threadID, name string) PromptVersion {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	assignment, ok := r.assignments[orgID][name]
+	if !ok {
+		builtin, _ := builtinPromptVersion(name)
+		return builtin
+	}
+
+	version := assignment.Version
+	if variant := assignment.Variant; variant != nil && promptBucket(orgID, threadID, name) < variant.Percent {
+		version = variant.Version
+	}
+	selected, _ := r.version(name, version)
+	return selected
+}
+
+// save writes the registry to its file. The caller holds the lock.
+func (r *PromptRegistry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	return writeJSONFile(r.path, promptRegistryFile{Templates: r.templates, Assignments: r.assignments})
+}
+
+// promptBucket maps a thread to a stable bucket between 0 and 99.
+func promptBucket(orgID, threadID, name string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(orgID + ":" + threadID + ":" + name))
+	return int(hash.Sum32() % 100)
+}
+
+// builtinPromptVersion returns the compiled-in template as version 0.
+func builtinPromptVersion(name string) (PromptVersion, bool) {
+	builtin, ok := builtinPrompts[name]
+	if !ok {
+		return PromptVersion{}, false
+	}
+	return PromptVersion{Name: name, Template: builtin.template, Description: "Built-in template"}, true
+}
+
+// validatePromptTemplate checks that the template parses, only uses the prompt's variables and
+// uses all of its required ones.
+func validatePromptTemplate(name, text string) error {
+	builtin, ok := builtinPrompts[name]
+	if !ok {
+		return fmt.Errorf("unknown prompt: %s", name)
+	}
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("template is empty")
+	}
+	if len(text) > maxPromptTemplateLength {
+		return fmt.Errorf("template must be at most %d characters", maxPromptTemplateLength)
+	}
+
+	parsed, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	// Each variable renders as a marker, to find the required ones in the output
+	values := make(map[string]any, len(builtin.variables))
+	for _, variable := range builtin.variables {
+		values[variable] = promptVariableMarker(variable)
+	}
+	var rendered strings.Builder
+	if err := parsed.Execute(&rendered, values); err != nil {
+		return fmt.Errorf("template may only use %s: %w", strings.Join(builtin.variables, ", "), err)
+	}
+
+	var missing []string
+	for _, variable := range builtin.required {
+		if !strings.Contains(rendered.String(), promptVariableMarker(variable)) {
+			missing = append(missing, "{{."+variable+"}}")
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("template must use %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func promptVariableMarker(variable string) string {
+	return "\x00" + variable + "\x00"
+}
+
+// queryPrompts are the prompt versions selected for a query.
+type queryPrompts map[string]PromptVersion
+
+// template returns the selected template, or the built-in one.
+func (p queryPrompts) template(name string) PromptVersion {
+	if version, ok := p[name]; ok {
+		return version
+	}
+	builtin, _ := builtinPromptVersion(name)
+	return builtin
+}
+
+// selectPrompts picks the prompt versions for the mode's prompts and logs them, so answers
+// can be compared across versions.
+func (am *AgentManager) selectPrompts(orgID, threadID, mode string) queryPrompts {
+	log := logger.GetLogger()
+
+	names := []string{PromptChain}
+	switch mode {
+	case ModeAgent:
+		names = []string{PromptReActPrefix, PromptReActInstructions, PromptReActSuffix}
+	case ModeTools:
+		// The tool-calling agent has no prompt template
+		return nil
+	}
+
+	selected := make(queryPrompts, len(names))
+	ids := make([]string, 0, len(names))
+	for _, name := range names {
+		version, _ := builtinPromptVersion(name)
+		if am.Prompts != nil {
+			version = am.Prompts.Select(orgID, threadID, name)
+		}
+		selected[name] = version
+		ids = append(ids, version.ID())
+	}
+
+	log.Info().Msgf("Thread %s uses prompts %s", threadID, strings.Join(ids, ", "))
+	am.SetThreadMetadata(threadID, "prompt_versions", ids)
+	return selected
+}
@@ -0,0 +1,50 @@
+package agents
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePromptTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		prompt   string
+		template string
+		wantErr  string
+	}{
+		{name: "chain with context", prompt: PromptChain, template: "{{.context}}\n\nAnswer briefly."},
+		{name: "chain without context", prompt: PromptChain, template: "Answer briefly.", wantErr: "must use {{.context}}"},
+		{name: "chain with unknown variable", prompt: PromptChain, template: "{{.context}} {{.input}}", wantErr: "may only use context"},
+		{name: "context only in a false branch", prompt: PromptChain, template: `{{if false}}{{.context}}{{end}}`, wantErr: "must use {{.context}}"},
+		{name: "suffix without scratchpad", prompt: PromptReActSuffix, template: "{{.history}}\n{{.input}}", wantErr: "must use {{.agent_scratchpad}}"},
+		{name: "suffix without input or scratchpad", prompt: PromptReActSuffix, template: "{{.history}}", wantErr: "must use {{.input}}, {{.agent_scratchpad}}"},
+		{name: "suffix with both", prompt: PromptReActSuffix, template: "Question: {{.input}}\nThought:{{.agent_scratchpad}}"},
+		{name: "prefix without tools", prompt: PromptReActPrefix, template: "{{.instructions}}", wantErr: "must use {{.tool_descriptions}}"},
+		{name: "instructions without tool names", prompt: PromptReActInstructions, template: "Action: the action", wantErr: "must use {{.tool_names}}"},
+		{name: "unknown prompt", prompt: "summary", template: "{{.context}}", wantErr: "unknown prompt"},
+		{name: "empty", prompt: PromptChain, template: "  ", wantErr: "template is empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePromptTemplate(tt.prompt, tt.template)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestBuiltinPromptsAreValid(t *testing.T) {
+	for name, builtin := range builtinPrompts {
+		if err := validatePromptTemplate(name, builtin.template); err != nil {
+			t.Errorf("built-in %s: %v", name, err)
+		}
+	}
+}
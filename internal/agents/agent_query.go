@@ -3,11 +3,13 @@ package agents
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/blog/conversational-agent/internal/logger"
 	"github.com/tmc/langchaingo/chains"
-	"github.com/tmc/langchaingo/vectorstores"
+	"github.com/tmc/langchaingo/llms"
 )
 
 // Query performs a similarity search and LLM chain call.
@@ -15,43 +17,120 @@ func (am *AgentManager) Query(
 	ctx context.Context,
 	userID, orgID, threadID, input string,
 	chunkCallback func([]byte),
+	options ...QueryOption,
+) (string, error) {
+	// Threads belong to the user and org that started them
+	if err := am.claimThread(threadID, userID, orgID); err != nil {
+		return "", err
+	}
+
+	opts := am.getQueryOptions(options...)
+	if opts.BranchFrom == nil {
+		return am.answer(ctx, userID, orgID, threadID, input, chunkCallback, opts)
+	}
+
+	// Answer on a new branch, going back to the previous one if the query fails
+	if _, pending := am.PendingAction(threadID); pending {
+		return "", ErrPendingAction
+	}
+	previousLeaf, err := am.branchThread(ctx, threadID, *opts.BranchFrom)
+	if err != nil {
+		return "", err
+	}
+	response, err := am.answer(ctx, userID, orgID, threadID, input, chunkCallback, opts)
+	var approvalErr *ApprovalRequiredError
+	if err != nil && !errors.As(err, &approvalErr) {
+		am.restoreBranch(context.WithoutCancel(ctx), threadID, previousLeaf)
+	}
+	return response, err
+}
+
+// answer answers the input from the thread's chat history.
+func (am *AgentManager) answer(
+	ctx context.Context,
+	userID, orgID, threadID, input string,
+	chunkCallback func([]byte),
+	opts QueryOptions,
 ) (string, error) {
 	log := logger.GetLogger()
+	// Let tools act on behalf of the same user and org
+	ctx = withQueryScope(ctx, queryScope{UserID: userID, OrgID: orgID, ThreadID: threadID})
 
 	// Retrieve memory and prepare for search
+	// A paused thread must be resolved before it continues
+	if _, pending := am.PendingAction(threadID); pending {
+		return "", ErrPendingAction
+	}
+
 	threadMemory := am.GetThreadMemory(threadID)
+
+	// Report the tokens of every model call, including routing
+	if opts.UsageCallback != nil {
+		opts.usage = &usageTracker{}
+		defer func() { opts.UsageCallback(opts.usage.total()) }()
+	}
+
+	// Hand the query to the sub-agent for its intent, if routing is on
+	subAgent, err := am.routeQuery(ctx, threadID, input, opts)
+	if err != nil {
+		return "", err
+	}
+	if err := am.applySubAgent(subAgent, &opts); err != nil {
+		return "", err
+	}
+
+	// Structured answers are generated from the chain context
+	if opts.ResponseSchema != nil {
+		opts.Mode = ModeChain
+	}
+	opts.prompts = am.selectPrompts(orgID, threadID, opts.Mode)
 	log.Debug().Msg("Performing similarity search in vector store...")
 
-	// Use org_id as namespace
-	namespace := orgID
-	log.Debug().Msgf("Using namespace: %s for similarity search", namespace)
+	similarDocs, err := am.retrieveKnowledge(ctx, orgID, input, opts)
+	if err != nil {
+		return "", err
+	}
 
-	// Perform similarity search in org namespace
-	log.Debug().Msgf("Performing similarity search in namespace: %s", namespace)
-	orgDocs, err := am.VectorStore.SimilaritySearch(ctx, input, 5, vectorstores.WithNameSpace(namespace))
-	if err != nil && err.Error() != "empty response" {
-		log.Error().Err(err).Msg("Failed to perform org-specific similarity search.")
-		return "", fmt.Errorf("org similarity search failed: %w", err)
+	// Curated corrections take priority over other documents
+	corrections, err := am.retrieveCorrections(ctx, orgID, input, opts)
+	if err != nil {
+		return "", err
 	}
 
-	// Search in default namespace
-	log.Debug().Msgf("Performing similarity search in default namespace")
-	defaultDocs, err := am.VectorStore.SimilaritySearch(ctx, input, 5)
-	if err != nil && err.Error() != "empty response" {
-		log.Error().Err(err).Msg("Failed to perform default similarity search.")
-		return "", fmt.Errorf("default similarity search failed: %w", err)
+	// Past conversations are retrieved separately from the knowledge base
+	memoryDocs, err := am.retrieveConversationMemory(ctx, userID, orgID, threadID, input, opts)
+	if err != nil {
+		return "", err
 	}
 
-	// Combine results
-	log.Debug().Msgf("Combining results")
-	similarDocs := append(orgDocs, defaultDocs...)
-	if len(similarDocs) == 0 {
-		log.Warn().Msg("No relevant documents found in either namespace. Proceeding with empty context.")
-		similarDocs = nil
+	// Favour recent documents before building the prompt
+	now := time.Now()
+	similarDocs = applyRecencyDecay(similarDocs, opts.Retrieval.KnowledgeHalfLife, now)
+	similarDocs = mergeCorrections(similarDocs, corrections, opts.Retrieval.CorrectionBoost)
+	memoryDocs = applyRecencyDecay(memoryDocs, opts.Retrieval.MemoryHalfLife, now)
+
+	// Give small chunks their surrounding context
+	similarDocs = am.expandToParents(ctx, similarDocs, opts)
+	sources := sourcesFromDocuments(similarDocs)
+	if opts.SourcesCallback != nil {
+		opts.SourcesCallback(sources)
+	}
+
+	// What produced the answer, kept with it for feedback
+	record := AnswerRecord{
+		ThreadID:       threadID,
+		UserID:         userID,
+		OrgID:          orgID,
+		Question:       input,
+		Sources:        sources,
+		Model:          am.modelName(opts),
+		Agent:          opts.agentName,
+		PromptVersions: promptVersionIDs(opts.prompts),
 	}
 
 	// Log retrieved documents
 	log.Debug().Msgf("Retrieved documents for thread %s: %+v", threadID, similarDocs)
+	log.Debug().Msgf("Retrieved conversation memory for thread %s: %+v", threadID, memoryDocs)
 
 	// Combine documents for context
 	var docContext bytes.Buffer
@@ -63,40 +142,118 @@ func (am *AgentManager) Query(
 		docContext.WriteString("No relevant documents found.\n")
 	}
 
+	// Combine past conversations for context
+	var memoryContext bytes.Buffer
+	if len(memoryDocs) > 0 {
+		for _, doc := range memoryDocs {
+			memoryContext.WriteString(fmt.Sprintf("Conversation: %s\n", doc.PageContent))
+		}
+	} else {
+		memoryContext.WriteString("No relevant past conversations found.\n")
+	}
+
 	// Prepare LLM input context
 	history, _ := threadMemory.ChatHistory.Messages(ctx)
-	chainInputs := map[string]any{
-		"context": fmt.Sprintf(
-			"History:\n%s\n\nRelevant Documents:\n%s\n\nUser Input:\n%s",
-			formatMessages(history), docContext.String(), input,
-		),
-	}
-	log.Debug().Msgf("LLM context:\n%s", chainInputs["context"])
-
-	// Call LLM chain
-	chainOutputs, err := chains.Call(ctx, am.LLMChain, chainInputs, chains.WithStreamingFunc(
-		func(ctx context.Context, chunk []byte) error {
-			if chunkCallback != nil {
-				chunkCallback(chunk)
+	instructions := am.instructionsFor(orgID, opts)
+
+	var fullResponse string
+	switch opts.Mode {
+	case ModeAgent:
+		// Let the agent decide which tools to use, with the retrieved context in its input
+		historyText, _ := llms.GetBufferString(history, "Human", "AI")
+		agentInput := fmt.Sprintf(
+			"Relevant Past Conversations:\n%s\n\nRelevant Documents:\n%s\n\nUser Input:\n%s",
+			memoryContext.String(), docContext.String(), input,
+		)
+		fullResponse, err = am.runReActAgent(ctx, orgID, instructions, historyText, agentInput, opts, chunkCallback)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to execute agent.")
+			return "", err
+		}
+	case ModeTools:
+		// The tool-calling agent records the input, tool calls and answer in memory itself
+		systemContext := withInstructions(instructions, fmt.Sprintf(
+			"Answer the user using the tools available when they help.\n\nRelevant Past Conversations:\n%s\n\nRelevant Documents:\n%s",
+			memoryContext.String(), docContext.String(),
+		))
+		run := toolCallingRun{
+			threadID:      threadID,
+			userID:        userID,
+			orgID:         orgID,
+			input:         input,
+			systemContext: systemContext,
+			llm:           am.llmFor(opts),
+			toolNames:     opts.toolNames,
+			record:        record,
+		}
+		fullResponse, err = am.runToolCallingAgent(ctx, threadMemory, run, opts, chunkCallback)
+		var approvalErr *ApprovalRequiredError
+		if errors.As(err, &approvalErr) {
+			log.Info().Msgf("Thread %s paused for approval of action %s", threadID, approvalErr.Action.ID)
+			if opts.StepCallback != nil {
+				opts.StepCallback(AgentStep{Type: StepTypeApproval, Log: approvalErr.Action.ID})
 			}
-			return nil
-		},
-	))
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to execute LLMChain.")
-		return "", fmt.Errorf("failed to execute LLMChain: %w", err)
+			return "", err
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to execute tool-calling agent.")
+			return "", err
+		}
+	default:
+		chainInputs := map[string]any{
+			"context": withInstructions(instructions, fmt.Sprintf(
+				"History:\n%s\n\nRelevant Past Conversations:\n%s\n\nRelevant Documents:\n%s\n\nUser Input:\n%s",
+				formatMessages(history), memoryContext.String(), docContext.String(), input,
+			)),
+		}
+		log.Debug().Msgf("LLM context:\n%s", chainInputs["context"])
+
+		if opts.ResponseSchema != nil {
+			fullResponse, err = am.generateStructured(ctx, am.llmFor(opts), chainInputs["context"].(string), opts, chunkCallback)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to generate structured output.")
+				return "", err
+			}
+			break
+		}
+
+		// Call LLM chain
+		chainOutputs, err := chains.Call(ctx, am.chainFor(opts), chainInputs, chains.WithStreamingFunc(
+			func(ctx context.Context, chunk []byte) error {
+				if chunkCallback != nil {
+					chunkCallback(chunk)
+				}
+				return nil
+			},
+		))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to execute LLMChain.")
+			return "", fmt.Errorf("failed to execute LLMChain: %w", err)
+		}
+		fullResponse = chainOutputs["text"].(string)
 	}
 
-	// Parse response and store in memory
-	fullResponse := chainOutputs["text"].(string)
+	// Store response in memory
 	log.Debug().Msgf("Response: %s", fullResponse)
-	threadMemory.SaveContext(ctx,
-		map[string]any{"input": input},
-		map[string]any{"response": fullResponse},
-	)
+	if opts.Mode != ModeTools {
+		threadMemory.SaveContext(ctx,
+			map[string]any{"input": input},
+			map[string]any{"response": fullResponse},
+		)
+	}
 
 	// Pass userID and orgID to addToBuffer
 	am.addToBuffer(threadID, input, fullResponse, userID, orgID)
 
+	record.Answer = fullResponse
+	am.recordAnswer(ctx, record, opts)
 	return fullResponse, nil
 }
+
+// withInstructions puts the persona and sub-agent instructions in front of the model context.
+func withInstructions(instructions, context string) string {
+	if instructions == "" {
+		return context
+	}
+	return fmt.Sprintf("Instructions:\n%s\n\n%s", instructions, context)
+}
@@ -0,0 +1,166 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	lcagents "github.com/tmc/langchaingo/agents"
+	"github.com/tmc/langchaingo/callbacks"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/tools"
+)
+
+// Agent step types reported while the agent loop runs.
+const (
+	StepTypeAction      = "action"
+	StepTypeObservation = "observation"
+)
+
+const (
+	// finalAnswerKeyword marks the start of the final answer in the ReAct format.
+	finalAnswerKeyword = "AI:"
+	// defaultMaxAgentIterations bounds the Thought/Action/Observation loop.
+	defaultMaxAgentIterations = 5
+)
+
+// AgentStep is an intermediate step of the agent loop.
+type AgentStep struct {
+	Type   string `json:"type"`
+	Tool   string `json:"tool"`
+	Input  string `json:"input,omitempty"`
+	Output string `json:"output,omitempty"`
+	Log    string `json:"log,omitempty"`
+}
+
+// runReActAgent answers the input with the Thought/Action/Observation loop of the query's
+// prompt versions, using the registered tools.
+func (am *AgentManager) runReActAgent(
+	ctx context.Context,
+	orgID, instructions, history, input string,
+	opts QueryOptions,
+	chunkCallback func([]byte),
+) (string, error) {
+	log := logger.GetLogger()
+
+	handler := &reactHandler{onStep: opts.StepCallback, onChunk: chunkCallback}
+
+	// Wrap each tool the org enabled so its observation is reported as a step.
+	// Tools that need approval are left out: the ReAct loop cannot pause, only the tools mode can.
+	registered := filterTools(am.Tools.ForOrg(orgID), opts.toolNames)
+	agentTools := make([]tools.Tool, 0, len(registered))
+	for _, tool := range registered {
+		if am.Tools.RequiresApproval(orgID, tool.Name()) {
+			continue
+		}
+		agentTools = append(agentTools, observedTool{Tool: tool, onStep: opts.StepCallback})
+	}
+
+	agent := lcagents.NewConversationalAgent(am.llmFor(opts), agentTools,
+		lcagents.WithPromptPrefix(opts.prompts.template(PromptReActPrefix).Template),
+		lcagents.WithPromptFormatInstructions(opts.prompts.template(PromptReActInstructions).Template),
+		lcagents.WithPromptSuffix(opts.prompts.template(PromptReActSuffix).Template),
+		lcagents.WithCallbacksHandler(handler),
+	)
+	executor := lcagents.NewExecutor(agent,
+		lcagents.WithMaxIterations(opts.MaxIterations),
+		lcagents.WithCallbacksHandler(handler),
+	)
+
+	log.Debug().Msgf("Running ReAct agent with %d tools and max %d iterations", len(agentTools), opts.MaxIterations)
+	outputs, err := executor.Call(ctx, map[string]any{
+		"input":        input,
+		"history":      history,
+		"instructions": instructions,
+	})
+	if errors.Is(err, lcagents.ErrNotFinished) {
+		return "", fmt.Errorf("agent did not finish within %d iterations", opts.MaxIterations)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to execute agent: %w", err)
+	}
+
+	output, _ := outputs["output"].(string)
+	return strings.TrimSpace(output), nil
+}
+
+// reactHandler reports agent actions as steps and streams only the final answer.
+type reactHandler struct {
+	callbacks.SimpleHandler
+	onStep  func(AgentStep)
+	onChunk func([]byte)
+
+	// text buffers the current model output until the final answer keyword appears
+	text      string
+	answering bool
+}
+
+func (h *reactHandler) HandleChainStart(_ context.Context, _ map[string]any) {
+	// Every planning call starts a new model output
+	h.text = ""
+	h.answering = false
+}
+
+func (h *reactHandler) HandleStreamingFunc(_ context.Context, chunk []byte) {
+	if h.onChunk == nil {
+		return
+	}
+	if h.answering {
+		h.onChunk(chunk)
+		return
+	}
+
+	h.text += string(chunk)
+	if idx := strings.Index(h.text, finalAnswerKeyword); idx >= 0 {
+		h.answering = true
+		if answer := strings.TrimLeft(h.text[idx+len(finalAnswerKeyword):], " "); answer != "" {
+			h.onChunk([]byte(answer))
+		}
+	}
+}
+
+func (h *reactHandler) HandleAgentAction(_ context.Context, action schema.AgentAction) {
+	if h.onStep != nil {
+		h.onStep(AgentStep{
+			Type:  StepTypeAction,
+			Tool:  action.Tool,
+			Input: action.ToolInput,
+			Log:   strings.TrimSpace(action.Log),
+		})
+	}
+}
+
+// observedTool reports the result of every call to the wrapped tool.
+type observedTool struct {
+	tools.Tool
+	onStep func(AgentStep)
+}
+
+// Description tells the model the expected JSON arguments of schema tools.
+func (t observedTool) Description() string {
+	if schemaTool, ok := t.Tool.(SchemaTool); ok && schemaTool.Schema() != nil {
+		schema, _ := json.Marshal(schemaTool.Schema())
+		return fmt.Sprintf("%s The input must be a JSON object matching this schema: %s", t.Tool.Description(), schema)
+	}
+	return t.Tool.Description()
+}
+
+func (t observedTool) Call(ctx context.Context, input string) (string, error) {
+	output, err := t.Tool.Call(ctx, input)
+	if err != nil {
+		// Let the model see the failure instead of aborting the loop
+		output = fmt.Sprintf("error: %s", err)
+	}
+	if t.onStep != nil {
+		t.onStep(AgentStep{
+			Type:   StepTypeObservation,
+			Tool:   t.Name(),
+			Input:  input,
+			Output: output,
+		})
+	}
+	return output, nil
+}
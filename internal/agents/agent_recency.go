@@ -0,0 +1,55 @@
+package agents
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// applyRecencyDecay scales each document's score by 0.5^(age/halfLife) and sorts the
+// documents by the decayed score. A zero half-life leaves the documents untouched.
+func applyRecencyDecay(docs []schema.Document, halfLife time.Duration, now time.Time) []schema.Document {
+	if halfLife <= 0 || len(docs) == 0 {
+		return docs
+	}
+
+	for i := range docs {
+		created, ok := documentTimestamp(docs[i])
+		if !ok {
+			continue
+		}
+		age := now.Sub(created)
+		if age < 0 {
+			age = 0
+		}
+		docs[i].Score *= float32(math.Pow(0.5, age.Hours()/halfLife.Hours()))
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Score > docs[j].Score
+	})
+	return docs
+}
+
+// documentTimestamp returns when a document was written, preferring the "timestamp"
+// metadata set on conversation chunks and falling back to Weaviate's creation time.
+func documentTimestamp(doc schema.Document) (time.Time, bool) {
+	if ts, ok := doc.Metadata["timestamp"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			return t, true
+		}
+	}
+
+	if additional, ok := doc.Metadata["_additional"].(map[string]any); ok {
+		if created, ok := additional["creationTimeUnix"].(string); ok {
+			if ms, err := strconv.ParseInt(created, 10, 64); err == nil {
+				return time.UnixMilli(ms), true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
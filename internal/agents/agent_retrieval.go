@@ -0,0 +1,141 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/vectorstores"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+)
+
+// mmrFetchK is the minimum number of candidates retrieved from each namespace when MMR is enabled.
+const mmrFetchK = 20
+
+// Source is a knowledge document retrieved to answer a query.
+type Source struct {
+	Content  string         `json:"content"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Score    float32        `json:"score"`
+}
+
+// sourcesFromDocuments converts retrieved documents into sources.
+func sourcesFromDocuments(docs []schema.Document) []Source {
+	sources := make([]Source, 0, len(docs))
+	for _, doc := range docs {
+		sources = append(sources, Source{Content: doc.PageContent, Metadata: doc.Metadata, Score: doc.Score})
+	}
+	return sources
+}
+
+// SourceConversation marks flushed conversation chunks. Chunks written before conversations
+// moved to their own namespace carry it in the org's knowledge namespace.
+const SourceConversation = "conversation"
+
+// knowledgeFilter restricts a knowledge search to documents that are not conversation chunks,
+// and to those matching filter when it is set.
+func knowledgeFilter(filter *MetadataFilter) *filters.WhereBuilder {
+	where := filters.Where().WithPath([]string{"source"}).WithOperator(filters.NotEqual).WithValueString(SourceConversation)
+	if filter == nil {
+		return where
+	}
+	return filters.Where().WithOperator(filters.And).WithOperands([]*filters.WhereBuilder{where, filter.ToWeaviate()})
+}
+
+// memoryNamespace returns the namespace holding an org's flushed conversation chunks,
+// kept apart from the org's knowledge documents.
+func memoryNamespace(orgID string) string {
+	return orgID + ":conversations"
+}
+
+// retrieveKnowledge searches the org and default knowledge namespaces and merges the results.
+func (am *AgentManager) retrieveKnowledge(ctx context.Context, orgID, input string, opts QueryOptions) ([]schema.Document, error) {
+	log := logger.GetLogger()
+
+	if !opts.Retrieval.KnowledgeEnabled || opts.Retrieval.KnowledgeTopK <= 0 {
+		log.Debug().Msg("Knowledge retrieval disabled for this query.")
+		return nil, nil
+	}
+
+	// Apply the request's metadata filter to every search, skipping legacy conversation chunks
+	if opts.Filter != nil {
+		log.Debug().Msgf("Applying metadata filter: %+v", opts.Filter)
+	}
+	searchOptions := []vectorstores.Option{vectorstores.WithFilters(knowledgeFilter(opts.Filter))}
+
+	// Fetch a wider candidate pool, with the vectors of the candidates, when MMR will pick the final set
+	lambda, mmr := opts.mmrLambda()
+	k := opts.Retrieval.KnowledgeTopK
+	store := am.VectorStore
+	if mmr {
+		k = max(k, mmrFetchK)
+		store = am.mmrVectorStore
+	}
+
+	// Search the org and default namespaces, or those of the routed sub-agent
+	namespaces := knowledgeNamespaces(orgID, opts.namespaces)
+	var similarDocs []schema.Document
+	for _, namespace := range namespaces {
+		log.Debug().Msgf("Performing similarity search in namespace: %s", namespace)
+		docs, err := store.SimilaritySearch(ctx, input, k,
+			append(searchOptions, vectorstores.WithNameSpace(namespace))...)
+		if err != nil && err.Error() != "empty response" {
+			log.Error().Err(err).Msgf("Failed to perform similarity search in namespace %s.", namespace)
+			return nil, fmt.Errorf("similarity search in namespace %s failed: %w", namespace, err)
+		}
+		similarDocs = append(similarDocs, docs...)
+	}
+
+	if len(similarDocs) == 0 {
+		log.Warn().Msg("No relevant documents found in any namespace. Proceeding with empty context.")
+		return nil, nil
+	}
+
+	// Diversify the merged results, keeping as many documents as the searches would have returned
+	if mmr {
+		var err error
+		log.Debug().Msgf("Applying MMR with lambda %.2f to %d candidates", lambda, len(similarDocs))
+		similarDocs, err = rerankMMR(similarDocs, len(namespaces)*opts.Retrieval.KnowledgeTopK, lambda)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to apply maximal marginal relevance.")
+			return nil, fmt.Errorf("mmr reranking failed: %w", err)
+		}
+	}
+
+	return similarDocs, nil
+}
+
+// retrieveConversationMemory searches the org's conversation namespace for past exchanges
+// of the same user or thread.
+func (am *AgentManager) retrieveConversationMemory(
+	ctx context.Context,
+	userID, orgID, threadID, input string,
+	opts QueryOptions,
+) ([]schema.Document, error) {
+	log := logger.GetLogger()
+
+	if !opts.Retrieval.MemoryEnabled || opts.Retrieval.MemoryTopK <= 0 {
+		log.Debug().Msg("Conversation memory retrieval disabled for this query.")
+		return nil, nil
+	}
+
+	// Scope past conversations to the requesting user or thread
+	scopeFilter := filters.Where().WithPath([]string{"user_id"}).WithOperator(filters.Equal).WithValueString(userID)
+	if opts.Retrieval.MemoryScope == MemoryScopeThread {
+		scopeFilter = filters.Where().WithPath([]string{"thread_id"}).WithOperator(filters.Equal).WithValueString(threadID)
+	}
+
+	namespace := memoryNamespace(orgID)
+	log.Debug().Msgf("Performing %s-scoped memory search in namespace: %s", opts.Retrieval.MemoryScope, namespace)
+	docs, err := am.VectorStore.SimilaritySearch(ctx, input, opts.Retrieval.MemoryTopK,
+		vectorstores.WithNameSpace(namespace),
+		vectorstores.WithFilters(scopeFilter),
+	)
+	if err != nil && err.Error() != "empty response" {
+		log.Error().Err(err).Msg("Failed to perform conversation memory search.")
+		return nil, fmt.Errorf("conversation memory search failed: %w", err)
+	}
+
+	return docs, nil
+}
@@ -0,0 +1,330 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/tmc/langchaingo/chains"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/openai"
+	"github.com/tmc/langchaingo/prompts"
+	"github.com/tmc/langchaingo/tools"
+)
+
+// StepTypeRoute reports which sub-agent the router picked.
+const StepTypeRoute = "route"
+
+// Knowledge namespaces a sub-agent can search. Other names select a sub-namespace of the org.
+const (
+	NamespaceOrg     = "org"
+	NamespaceDefault = "default"
+)
+
+// SubAgent is a differently configured agent the router can dispatch queries to.
+// Empty fields fall back to the manager's defaults.
+type SubAgent struct {
+	Name             string   `json:"name"`
+	Description      string   `json:"description"`
+	SystemPrompt     string   `json:"system_prompt"`
+	Namespaces       []string `json:"namespaces,omitempty"`
+	DisableKnowledge bool     `json:"disable_knowledge,omitempty"`
+	Tools            []string `json:"tools,omitempty"`
+	Model            string   `json:"model,omitempty"`
+	Mode             string   `json:"mode,omitempty"`
+}
+
+// AgentRouter classifies queries by intent and picks the sub-agent that handles them.
+type AgentRouter struct {
+	Agents  []SubAgent `json:"agents"`
+	Default string     `json:"default"`
+}
+
+// RouteDecision is the routing decision recorded in the thread metadata.
+type RouteDecision struct {
+	Agent    string    `json:"agent"`
+	Forced   bool      `json:"forced,omitempty"`
+	RoutedAt time.Time `json:"routed_at"`
+}
+
+// DefaultAgentRouter returns the router used when no routes are configured.
+func DefaultAgentRouter() *AgentRouter {
+	return &AgentRouter{
+		Default: "chit_chat",
+		Agents: []SubAgent{
+			{
+				Name:         "billing",
+				Description:  "Questions about invoices, payments, refunds, pricing plans and subscriptions.",
+				SystemPrompt: "You are a billing specialist. Answer precisely about invoices, payments and subscriptions, and never guess amounts that are not in the documents.",
+			},
+			{
+				Name:         "technical_support",
+				Description:  "Problems using the product, errors, configuration, integrations and how-to questions.",
+				SystemPrompt: "You are a technical support engineer. Diagnose the problem step by step and give concrete instructions based on the documents.",
+			},
+			{
+				Name:         "sales",
+				Description:  "Interest in buying, product capabilities, comparisons, demos and quotes.",
+				SystemPrompt: "You are a helpful sales representative. Explain how the product fits the customer's needs, based on the documents.",
+			},
+			{
+				Name:             "chit_chat",
+				Description:      "Greetings, small talk and anything unrelated to the product.",
+				SystemPrompt:     "You are a friendly assistant. Keep the conversation short and natural.",
+				DisableKnowledge: true,
+			},
+		},
+	}
+}
+
+// LoadAgentRouter reads a router definition from a JSON file.
+func LoadAgentRouter(path string) (*AgentRouter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routes file: %w", err)
+	}
+
+	var router AgentRouter
+	if err := json.Unmarshal(data, &router); err != nil {
+		return nil, fmt.Errorf("failed to parse routes file: %w", err)
+	}
+	if err := router.Validate(); err != nil {
+		return nil, err
+	}
+	return &router, nil
+}
+
+// Validate checks that the router has uniquely named agents and a valid default.
+func (r *AgentRouter) Validate() error {
+	if len(r.Agents) == 0 {
+		return fmt.Errorf("router needs at least one agent")
+	}
+
+	seen := make(map[string]bool, len(r.Agents))
+	for _, agent := range r.Agents {
+		if agent.Name == "" || agent.Description == "" {
+			return fmt.Errorf("router agents need a name and a description")
+		}
+		if seen[agent.Name] {
+			return fmt.Errorf("duplicate router agent: %s", agent.Name)
+		}
+		seen[agent.Name] = true
+
+		switch agent.Mode {
+		case "", ModeChain, ModeAgent, ModeTools:
+		default:
+			return fmt.Errorf("router agent %s: unknown mode %s", agent.Name, agent.Mode)
+		}
+	}
+	if r.Default != "" && !seen[r.Default] {
+		return fmt.Errorf("default router agent %s is not defined", r.Default)
+	}
+	return nil
+}
+
+// Agent returns the sub-agent with the given name.
+func (r *AgentRouter) Agent(name string) (*SubAgent, bool) {
+	for i := range r.Agents {
+		if strings.EqualFold(r.Agents[i].Name, name) {
+			return &r.Agents[i], true
+		}
+	}
+	return nil, false
+}
+
+// fallback returns the agent used when classification fails.
+func (r *AgentRouter) fallback() *SubAgent {
+	if agent, ok := r.Agent(r.Default); ok {
+		return agent
+	}
+	return &r.Agents[0]
+}
+
+// classificationPrompt asks the model for the name of the agent that should handle the input.
+func (r *AgentRouter) classificationPrompt(input string) string {
+	var agents strings.Builder
+	for _, agent := range r.Agents {
+		agents.WriteString(fmt.Sprintf("- %s: %s\n", agent.Name, agent.Description))
+	}
+	return fmt.Sprintf(
+		"Classify the user's message by intent and pick the agent that should handle it.\n\nAgents:\n%s\n"+
+			"Respond with the agent name only.\n\nMessage:\n%s",
+		agents.String(), input,
+	)
+}
+
+// routeQuery picks the sub-agent for the input, or nil when routing is off, and records the
+// decision in the thread metadata.
+func (am *AgentManager) routeQuery(ctx context.Context, threadID, input string, opts QueryOptions) (*SubAgent, error) {
+	log := logger.GetLogger()
+
+	if am.Router == nil || (!opts.Routing && opts.Agent == "") {
+		return nil, nil
+	}
+
+	decision := RouteDecision{RoutedAt: time.Now().UTC()}
+	var agent *SubAgent
+	if opts.Agent != "" {
+		// The request named its agent
+		forced, ok := am.Router.Agent(opts.Agent)
+		if !ok {
+			return nil, fmt.Errorf("unknown agent: %s", opts.Agent)
+		}
+		agent = forced
+		decision.Forced = true
+	} else {
+		answer, err := llms.GenerateFromSinglePrompt(ctx, am.llmFor(opts), am.Router.classificationPrompt(input),
+			llms.WithTemperature(0),
+			llms.WithMaxTokens(20),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to classify query: %w", err)
+		}
+
+		name := strings.Trim(strings.TrimSpace(answer), "`\"'.")
+		classified, ok := am.Router.Agent(name)
+		if !ok {
+			log.Warn().Msgf("Router answered unknown agent %q, using the default.", name)
+			classified = am.Router.fallback()
+		}
+		agent = classified
+	}
+	decision.Agent = agent.Name
+
+	log.Debug().Msgf("Routing thread %s to agent %s", threadID, agent.Name)
+	am.SetThreadMetadata(threadID, "route", decision)
+	if opts.StepCallback != nil {
+		opts.StepCallback(AgentStep{Type: StepTypeRoute, Tool: agent.Name})
+	}
+	return agent, nil
+}
+
+// applySubAgent applies a sub-agent's settings to the query options.
+func (am *AgentManager) applySubAgent(agent *SubAgent, opts *QueryOptions) error {
+	if agent == nil {
+		return nil
+	}
+
+	opts.agentName = agent.Name
+	opts.systemPrompt = agent.SystemPrompt
+	opts.namespaces = agent.Namespaces
+	opts.toolNames = agent.Tools
+	if agent.DisableKnowledge {
+		opts.Retrieval.KnowledgeEnabled = false
+	}
+	if agent.Mode != "" {
+		opts.Mode = agent.Mode
+	}
+	if agent.Model != "" {
+		llm, err := am.modelLLM(agent.Model)
+		if err != nil {
+			return err
+		}
+		opts.llm = llm
+		opts.model = agent.Model
+	}
+	return nil
+}
+
+// knowledgeNamespaces maps a sub-agent's namespaces to vector store namespaces of the org.
+// Without a sub-agent, the org and default namespaces are searched.
+func knowledgeNamespaces(orgID string, names []string) []string {
+	if len(names) == 0 {
+		return []string{orgID, NamespaceDefault}
+	}
+
+	namespaces := make([]string, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case NamespaceOrg:
+			namespaces = append(namespaces, orgID)
+		case NamespaceDefault:
+			namespaces = append(namespaces, NamespaceDefault)
+		default:
+			// Keep sub-agents inside their tenant
+			namespaces = append(namespaces, orgID+":"+name)
+		}
+	}
+	return namespaces
+}
+
+// filterTools keeps the named tools, or all of them when no names are given.
+func filterTools(available []tools.Tool, names []string) []tools.Tool {
+	if len(names) == 0 {
+		return available
+	}
+
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[strings.ToLower(name)] = true
+	}
+	filtered := make([]tools.Tool, 0, len(names))
+	for _, tool := range available {
+		if allowed[strings.ToLower(tool.Name())] {
+			filtered = append(filtered, tool)
+		}
+	}
+	return filtered
+}
+
+// modelClients caches an LLM client per model name.
+type modelClients struct {
+	mutex   sync.Mutex
+	clients map[string]*openai.LLM
+}
+
+// modelLLM returns a client for the model, creating it on first use.
+func (am *AgentManager) modelLLM(model string) (*openai.LLM, error) {
+	am.models.mutex.Lock()
+	defer am.models.mutex.Unlock()
+
+	if llm, ok := am.models.clients[model]; ok {
+		return llm, nil
+	}
+	llm, err := InitializeLLM(am.openAIApiKey, model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize model %s: %w", model, err)
+	}
+	if am.models.clients == nil {
+		am.models.clients = make(map[string]*openai.LLM)
+	}
+	am.models.clients[model] = llm
+	return llm, nil
+}
+
+// llmFor returns the model that answers a query, recording its usage when asked to.
+func (am *AgentManager) llmFor(opts QueryOptions) llms.Model {
+	var llm llms.Model = am.LLM
+	if opts.llm != nil {
+		llm = opts.llm
+	}
+	if opts.usage != nil {
+		return &usageModel{Model: llm, tracker: opts.usage}
+	}
+	return llm
+}
+
+// modelName returns the name of the model that answers a query.
+func (am *AgentManager) modelName(opts QueryOptions) string {
+	if opts.model != "" {
+		return opts.model
+	}
+	return am.Model
+}
+
+// chainFor returns the chain that answers a query in chain mode, with the query's model
+// and prompt version.
+func (am *AgentManager) chainFor(opts QueryOptions) *chains.LLMChain {
+	prompt := opts.prompts.template(PromptChain)
+	if opts.llm == nil && opts.usage == nil && prompt.Version == 0 {
+		return am.LLMChain
+	}
+	chain := chains.NewLLMChain(am.llmFor(opts), prompts.NewPromptTemplate(prompt.Template, []string{"context"}))
+	chain.Memory = am.LLMChain.Memory
+	return chain
+}
@@ -0,0 +1,364 @@
+package agents
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONSchema is the subset of JSON Schema used to describe structured answers: types,
+// object properties, array items, enums, combinators and the common size limits.
+type JSONSchema struct {
+	Type                 schemaTypes            `json:"type,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Enum                 []any                  `json:"enum,omitempty"`
+	Const                any                    `json:"const,omitempty"`
+	AnyOf                []*JSONSchema          `json:"anyOf,omitempty"`
+	OneOf                []*JSONSchema          `json:"oneOf,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+}
+
+// schemaTypes accepts "type" as a single name or a list of names.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("'type' must be a string or a list of strings")
+	}
+	*t = list
+	return nil
+}
+
+func (t schemaTypes) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// unsupportedSchemaKeywords are JSON Schema keywords that constrain values but are not
+// enforced. Schemas using them are rejected rather than validated partially.
+var unsupportedSchemaKeywords = map[string]bool{
+	"$ref": true, "$defs": true, "definitions": true, "$dynamicRef": true, "$recursiveRef": true,
+	"allOf": true, "not": true, "if": true, "then": true, "else": true,
+	"pattern": true, "format": true,
+	"exclusiveMinimum": true, "exclusiveMaximum": true, "multipleOf": true,
+	"minProperties": true, "maxProperties": true, "patternProperties": true, "propertyNames": true,
+	"dependentRequired": true, "dependentSchemas": true, "dependencies": true, "unevaluatedProperties": true,
+	"uniqueItems": true, "contains": true, "minContains": true, "maxContains": true,
+	"prefixItems": true, "additionalItems": true, "unevaluatedItems": true,
+}
+
+// ParseJSONSchema decodes and checks a JSON schema.
+func ParseJSONSchema(data []byte) (*JSONSchema, error) {
+	// Look for unsupported keywords first, which decoding into JSONSchema would drop
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	if err := checkSchemaKeywords("$", raw); err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var schema JSONSchema
+	if err := decoder.Decode(&schema); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	if err := schema.check("$"); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// checkSchemaKeywords rejects unsupported keywords anywhere in a decoded schema.
+func checkSchemaKeywords(path string, node any) error {
+	object, ok := node.(map[string]any)
+	if !ok {
+		// Malformed nodes are reported when the schema is decoded
+		return nil
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if unsupportedSchemaKeywords[key] {
+			return fmt.Errorf("invalid JSON schema at %s: keyword %q is not supported", path, key)
+		}
+	}
+	if additional, ok := object["additionalProperties"]; ok {
+		if _, ok := additional.(bool); !ok {
+			return fmt.Errorf("invalid JSON schema at %s: 'additionalProperties' must be a boolean", path)
+		}
+	}
+
+	if properties, ok := object["properties"].(map[string]any); ok {
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := checkSchemaKeywords(path+"."+name, properties[name]); err != nil {
+				return err
+			}
+		}
+	}
+	if err := checkSchemaKeywords(path+"[]", object["items"]); err != nil {
+		return err
+	}
+	for _, combinator := range []string{"anyOf", "oneOf"} {
+		subschemas, _ := object[combinator].([]any)
+		for _, sub := range subschemas {
+			if err := checkSchemaKeywords(path, sub); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// check rejects unknown type names anywhere in the schema.
+func (s *JSONSchema) check(path string) error {
+	for _, name := range s.Type {
+		switch name {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("invalid JSON schema at %s: unknown type %q", path, name)
+		}
+	}
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("invalid JSON schema at %s.%s: empty property schema", path, name)
+		}
+		if err := property.check(path + "." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.check(path + "[]"); err != nil {
+			return err
+		}
+	}
+	for _, sub := range append(append([]*JSONSchema{}, s.AnyOf...), s.OneOf...) {
+		if sub == nil {
+			return fmt.Errorf("invalid JSON schema at %s: empty subschema", path)
+		}
+		if err := sub.check(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks a decoded JSON value against the schema. Numbers may be float64 or json.Number.
+func (s *JSONSchema) Validate(value any) error {
+	return s.validate("$", value)
+}
+
+func (s *JSONSchema) validate(path string, value any) error {
+	if number, ok := value.(json.Number); ok {
+		f, err := number.Float64()
+		if err != nil {
+			return fmt.Errorf("%s: invalid number %s", path, number)
+		}
+		value = f
+	}
+
+	if len(s.Type) > 0 && !s.matchesType(value) {
+		return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(s.Type, " or "), jsonTypeName(value))
+	}
+	if len(s.Enum) > 0 && !containsJSONValue(s.Enum, value) {
+		return fmt.Errorf("%s: value must be one of %s", path, formatJSONValues(s.Enum))
+	}
+	if s.Const != nil && !jsonValuesEqual(s.Const, value) {
+		return fmt.Errorf("%s: value must be %s", path, formatJSONValues([]any{s.Const}))
+	}
+
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, sub := range s.AnyOf {
+			if sub.validate(path, value) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: value does not match any of the allowed schemas", path)
+		}
+	}
+	if len(s.OneOf) > 0 {
+		matches := 0
+		for _, sub := range s.OneOf {
+			if sub.validate(path, value) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: value must match exactly one of the allowed schemas, matched %d", path, matches)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		return s.validateObject(path, v)
+	case []any:
+		return s.validateArray(path, v)
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			return fmt.Errorf("%s: string must have at least %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return fmt.Errorf("%s: string must have at most %d characters", path, *s.MaxLength)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s: value must be at least %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%s: value must be at most %v", path, *s.Maximum)
+		}
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateObject(path string, object map[string]any) error {
+	for _, name := range s.Required {
+		if _, ok := object[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+	for name, value := range object {
+		property, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				return fmt.Errorf("%s: unexpected property %q", path, name)
+			}
+			continue
+		}
+		if err := property.validate(path+"."+name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateArray(path string, items []any) error {
+	if s.MinItems != nil && len(items) < *s.MinItems {
+		return fmt.Errorf("%s: array must have at least %d items", path, *s.MinItems)
+	}
+	if s.MaxItems != nil && len(items) > *s.MaxItems {
+		return fmt.Errorf("%s: array must have at most %d items", path, *s.MaxItems)
+	}
+	if s.Items == nil {
+		return nil
+	}
+	for i, item := range items {
+		if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DescribesObject reports whether the schema's type is exactly "object", so every value
+// matching it is a JSON object.
+func (s *JSONSchema) DescribesObject() bool {
+	return len(s.Type) == 1 && s.Type[0] == "object"
+}
+
+// matchesType reports whether the value has one of the schema's types.
+func (s *JSONSchema) matchesType(value any) bool {
+	actual := jsonTypeName(value)
+	for _, name := range s.Type {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeName returns the JSON Schema type of a decoded value.
+func jsonTypeName(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// containsJSONValue reports whether the value equals one of the candidates.
+func containsJSONValue(candidates []any, value any) bool {
+	for _, candidate := range candidates {
+		if jsonValuesEqual(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonValuesEqual compares two decoded JSON values, treating all numbers alike.
+func jsonValuesEqual(a, b any) bool {
+	return reflect.DeepEqual(normalizeJSONValue(a), normalizeJSONValue(b))
+}
+
+func normalizeJSONValue(value any) any {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
+
+func formatJSONValues(values []any) string {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		data, _ := json.Marshal(value)
+		parts = append(parts, string(data))
+	}
+	return strings.Join(parts, ", ")
+}
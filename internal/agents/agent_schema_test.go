@@ -0,0 +1,119 @@
+package agents
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseJSONSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{name: "supported keywords", schema: `{"type": "object", "title": "answer", "properties": {"n": {"type": ["integer", "null"], "minimum": 0}}, "additionalProperties": false}`},
+		{name: "unknown type", schema: `{"type": "decimal"}`, wantErr: `unknown type "decimal"`},
+		{name: "pattern", schema: `{"type": "string", "pattern": "^a"}`, wantErr: `at $: keyword "pattern" is not supported`},
+		{name: "nested format", schema: `{"type": "object", "properties": {"when": {"type": "string", "format": "date"}}}`, wantErr: `at $.when: keyword "format" is not supported`},
+		{name: "allOf", schema: `{"allOf": [{"type": "string"}]}`, wantErr: `keyword "allOf" is not supported`},
+		{name: "ref in items", schema: `{"type": "array", "items": {"$ref": "#/$defs/item"}}`, wantErr: `at $[]: keyword "$ref" is not supported`},
+		{name: "exclusiveMinimum in oneOf", schema: `{"oneOf": [{"type": "number", "exclusiveMinimum": 0}]}`, wantErr: `keyword "exclusiveMinimum" is not supported`},
+		{name: "minProperties", schema: `{"type": "object", "minProperties": 1}`, wantErr: `keyword "minProperties" is not supported`},
+		{name: "additionalProperties schema", schema: `{"type": "object", "additionalProperties": {"type": "string"}}`, wantErr: "'additionalProperties' must be a boolean"},
+		{name: "malformed", schema: `{"type": `, wantErr: "invalid JSON schema"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseJSONSchema([]byte(tt.schema))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		value   string
+		wantErr string
+	}{
+		{name: "type list first", schema: `{"type": ["string", "null"]}`, value: `"a"`},
+		{name: "type list second", schema: `{"type": ["string", "null"]}`, value: `null`},
+		{name: "type list mismatch", schema: `{"type": ["string", "null"]}`, value: `1`, wantErr: "expected string or null, got integer"},
+		{name: "integer accepts whole number", schema: `{"type": "integer"}`, value: `3.0`},
+		{name: "integer rejects fraction", schema: `{"type": "integer"}`, value: `3.5`, wantErr: "expected integer, got number"},
+		{name: "number accepts integer", schema: `{"type": "number"}`, value: `3`},
+		{name: "enum match", schema: `{"enum": ["low", "high", 1]}`, value: `1.0`},
+		{name: "enum mismatch", schema: `{"enum": ["low", "high"]}`, value: `"medium"`, wantErr: `value must be one of "low", "high"`},
+		{name: "const object match", schema: `{"const": {"a": [1, 2]}}`, value: `{"a": [1, 2]}`},
+		{name: "const mismatch", schema: `{"const": "yes"}`, value: `"no"`, wantErr: `value must be "yes"`},
+		{name: "oneOf single match", schema: `{"oneOf": [{"type": "string"}, {"type": "integer"}]}`, value: `2`},
+		{name: "oneOf two matches", schema: `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, value: `2`, wantErr: "matched 2"},
+		{name: "oneOf no match", schema: `{"oneOf": [{"type": "string"}, {"type": "integer"}]}`, value: `true`, wantErr: "matched 0"},
+		{name: "anyOf", schema: `{"anyOf": [{"type": "string"}, {"type": "boolean"}]}`, value: `1`, wantErr: "does not match any"},
+		{name: "additional properties allowed", schema: `{"type": "object", "properties": {"a": {"type": "string"}}}`, value: `{"a": "x", "b": 1}`},
+		{name: "additional properties rejected", schema: `{"type": "object", "properties": {"a": {"type": "string"}}, "additionalProperties": false}`, value: `{"a": "x", "b": 1}`, wantErr: `$: unexpected property "b"`},
+		{name: "nested property", schema: `{"type": "object", "properties": {"a": {"type": "array", "items": {"type": "string"}}}}`, value: `{"a": ["x", 2]}`, wantErr: "$.a[1]: expected string, got integer"},
+		{name: "required", schema: `{"type": "object", "required": ["a"]}`, value: `{}`, wantErr: `missing required property "a"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := ParseJSONSchema([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("ParseJSONSchema: %v", err)
+			}
+			var value any
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatalf("decode value: %v", err)
+			}
+
+			err = schema.Validate(value)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaDescribesObject(t *testing.T) {
+	tests := []struct {
+		schema string
+		want   bool
+	}{
+		{schema: `{"type": "object"}`, want: true},
+		{schema: `{"type": ["object"]}`, want: true},
+		{schema: `{"type": ["object", "array"]}`, want: false},
+		{schema: `{"type": ["object", "null"]}`, want: false},
+		{schema: `{"anyOf": [{"type": "object"}]}`, want: false},
+		{schema: `{"type": "array"}`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.schema, func(t *testing.T) {
+			schema, err := ParseJSONSchema([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("ParseJSONSchema: %v", err)
+			}
+			if got := schema.DescribesObject(); got != tt.want {
+				t.Fatalf("DescribesObject() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
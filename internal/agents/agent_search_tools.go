@@ -0,0 +1,214 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/vectorstores"
+)
+
+// Namespaces the search tools can target.
+const (
+	searchNamespaceOrg     = "org"
+	searchNamespaceDefault = "default"
+	searchNamespaceBoth    = "both"
+)
+
+// maxToolResults bounds the number of documents a single tool search returns.
+const maxToolResults = 20
+
+// filterSchemaDescription explains the metadata filter format to the model.
+const filterSchemaDescription = `Metadata filter. A comparison is {"field": "<name>", "op": "eq|ne|gt|gte|lt|lte|in", "value": <value>}; ` +
+	`comparisons combine as {"and": [...]}, {"or": [...]} or {"not": {...}}. Timestamps are RFC3339 strings.`
+
+// queryScope identifies who a query runs for, so tools act within the same tenant.
+type queryScope struct {
+	UserID   string
+	OrgID    string
+	ThreadID string
+}
+
+type queryScopeKey struct{}
+
+// withQueryScope attaches the query's user, org and thread to the context passed to tools.
+func withQueryScope(ctx context.Context, scope queryScope) context.Context {
+	return context.WithValue(ctx, queryScopeKey{}, scope)
+}
+
+// queryScopeFromContext returns the scope attached by withQueryScope.
+func queryScopeFromContext(ctx context.Context) (queryScope, error) {
+	scope, ok := ctx.Value(queryScopeKey{}).(queryScope)
+	if !ok || scope.OrgID == "" {
+		return queryScope{}, fmt.Errorf("tool called outside of a query")
+	}
+	return scope, nil
+}
+
+// knowledgeSearchTool lets the agent run its own similarity searches over the knowledge base.
+func (am *AgentManager) knowledgeSearchTool() FunctionTool {
+	return FunctionTool{
+		ToolName: "search_knowledge_base",
+		ToolDescription: "Semantic search over the knowledge base. Use it to find documents relevant to a question; " +
+			"refine the query and search again if the results are not useful.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query": map[string]any{"type": "string", "description": "What to search for."},
+				"namespace": map[string]any{
+					"type":        "string",
+					"enum":        []string{searchNamespaceOrg, searchNamespaceDefault, searchNamespaceBoth},
+					"description": "Search the organization's documents, the shared default documents, or both. Defaults to both.",
+				},
+				"top_k":  map[string]any{"type": "integer", "description": "Number of documents per namespace, at most 20."},
+				"filter": map[string]any{"type": "object", "description": filterSchemaDescription},
+			},
+			"required": []string{"query"},
+		},
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			var args struct {
+				Query     string          `json:"query"`
+				Namespace string          `json:"namespace"`
+				TopK      int             `json:"top_k"`
+				Filter    *MetadataFilter `json:"filter"`
+			}
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			if args.Query == "" {
+				return "", fmt.Errorf("'query' is required")
+			}
+
+			scope, err := queryScopeFromContext(ctx)
+			if err != nil {
+				return "", err
+			}
+			namespaces, err := searchNamespaces(scope.OrgID, args.Namespace)
+			if err != nil {
+				return "", err
+			}
+			topK := clampTopK(args.TopK, am.Retrieval.KnowledgeTopK)
+
+			if args.Filter != nil {
+				if err := args.Filter.Validate(); err != nil {
+					return "", fmt.Errorf("invalid filter: %w", err)
+				}
+			}
+			searchOptions := []vectorstores.Option{vectorstores.WithFilters(knowledgeFilter(args.Filter))}
+
+			var results []schema.Document
+			for _, namespace := range namespaces {
+				docs, err := am.VectorStore.SimilaritySearch(ctx, args.Query, topK,
+					append(searchOptions, vectorstores.WithNameSpace(namespace))...)
+				if err != nil && err.Error() != "empty response" {
+					return "", fmt.Errorf("search in namespace %s failed: %w", namespace, err)
+				}
+				results = append(results, docs...)
+			}
+			return formatToolResults(results), nil
+		},
+	}
+}
+
+// metadataSearchTool lets the agent look up documents by metadata alone, like QueryMemoryByUserAndOrgID.
+func (am *AgentManager) metadataSearchTool() FunctionTool {
+	return FunctionTool{
+		ToolName: "search_documents_by_metadata",
+		ToolDescription: "Find documents by their metadata only, without semantic matching. " +
+			"Useful for listing documents from a given source, product or time range.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"filter": map[string]any{"type": "object", "description": filterSchemaDescription},
+				"namespace": map[string]any{
+					"type":        "string",
+					"enum":        []string{searchNamespaceOrg, searchNamespaceDefault},
+					"description": "Search the organization's documents or the shared default documents. Defaults to org.",
+				},
+				"limit": map[string]any{"type": "integer", "description": "Maximum number of documents, at most 20."},
+			},
+			"required": []string{"filter"},
+		},
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			var args struct {
+				Filter    *MetadataFilter `json:"filter"`
+				Namespace string          `json:"namespace"`
+				Limit     int             `json:"limit"`
+			}
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			if args.Filter == nil {
+				return "", fmt.Errorf("'filter' is required")
+			}
+			if err := args.Filter.Validate(); err != nil {
+				return "", fmt.Errorf("invalid filter: %w", err)
+			}
+
+			scope, err := queryScopeFromContext(ctx)
+			if err != nil {
+				return "", err
+			}
+			if args.Namespace == "" {
+				args.Namespace = searchNamespaceOrg
+			}
+			if args.Namespace == searchNamespaceBoth {
+				return "", fmt.Errorf("namespace must be 'org' or 'default'")
+			}
+			namespaces, err := searchNamespaces(scope.OrgID, args.Namespace)
+			if err != nil {
+				return "", err
+			}
+
+			docs, err := am.VectorStore.MetadataSearch(ctx, clampTopK(args.Limit, 10),
+				vectorstores.WithNameSpace(namespaces[0]),
+				vectorstores.WithFilters(knowledgeFilter(args.Filter)),
+			)
+			if err != nil && err.Error() != "empty response" {
+				return "", fmt.Errorf("metadata search failed: %w", err)
+			}
+			return formatToolResults(docs), nil
+		},
+	}
+}
+
+// searchNamespaces maps a tool's namespace argument to vector store namespaces.
+func searchNamespaces(orgID, namespace string) ([]string, error) {
+	switch namespace {
+	case searchNamespaceOrg:
+		return []string{orgID}, nil
+	case searchNamespaceDefault:
+		return []string{searchNamespaceDefault}, nil
+	case searchNamespaceBoth, "":
+		return []string{orgID, searchNamespaceDefault}, nil
+	}
+	return nil, fmt.Errorf("unknown namespace: %s", namespace)
+}
+
+// clampTopK applies the default to unset values and caps the result count.
+func clampTopK(topK, defaultTopK int) int {
+	if topK <= 0 {
+		topK = defaultTopK
+	}
+	if topK > maxToolResults {
+		topK = maxToolResults
+	}
+	return topK
+}
+
+// formatToolResults renders documents as numbered text for the model.
+func formatToolResults(docs []schema.Document) string {
+	if len(docs) == 0 {
+		return "No documents found."
+	}
+
+	var results strings.Builder
+	for i, doc := range docs {
+		source, _ := doc.Metadata["source"].(string)
+		namespace, _ := doc.Metadata["nameSpace"].(string)
+		results.WriteString(fmt.Sprintf("[%d] (namespace: %s, source: %s)\n%s\n\n", i+1, namespace, source, doc.PageContent))
+	}
+	return strings.TrimSpace(results.String())
+}
@@ -0,0 +1,128 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// defaultStructuredOutputRetries is the number of corrections asked for when an answer
+// does not match the response schema.
+const defaultStructuredOutputRetries = 2
+
+// ErrInvalidStructuredOutput is returned when the model keeps answering outside the response schema.
+var ErrInvalidStructuredOutput = errors.New("model output does not match the response schema")
+
+// StructuredResponse is a validated structured answer: the JSON text and the decoded object.
+type StructuredResponse struct {
+	Text   string `json:"text"`
+	Object any    `json:"object"`
+}
+
+// QueryStructured answers the input with a JSON object matching the schema and returns it
+// both as text and decoded.
+func (am *AgentManager) QueryStructured(
+	ctx context.Context,
+	userID, orgID, threadID, input string,
+	schema *JSONSchema,
+	chunkCallback func([]byte),
+	options ...QueryOption,
+) (*StructuredResponse, error) {
+	text, err := am.Query(ctx, userID, orgID, threadID, input, chunkCallback,
+		append(options, WithResponseSchema(schema))...)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := decodeStructuredOutput(text)
+	if err != nil {
+		return nil, err
+	}
+	return &StructuredResponse{Text: text, Object: object}, nil
+}
+
+// generateStructured asks the model for a JSON answer in JSON mode and validates it against
+// the schema, sending the validation error back to the model until it complies or the
+// retries run out. Only the valid answer is streamed.
+func (am *AgentManager) generateStructured(
+	ctx context.Context,
+	llm llms.Model,
+	prompt string,
+	opts QueryOptions,
+	chunkCallback func([]byte),
+) (string, error) {
+	log := logger.GetLogger()
+
+	schemaJSON, err := json.Marshal(opts.ResponseSchema)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode response schema: %w", err)
+	}
+	messages := []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, fmt.Sprintf(
+			"Respond only with a JSON object that matches this JSON schema:\n%s\n\nDo not add any text outside the JSON object.",
+			schemaJSON,
+		)),
+		llms.TextParts(llms.ChatMessageTypeHuman, prompt),
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= opts.StructuredRetries; attempt++ {
+		response, err := llm.GenerateContent(ctx, messages, llms.WithJSONMode())
+		if err != nil {
+			return "", fmt.Errorf("failed to generate content: %w", err)
+		}
+		if len(response.Choices) == 0 {
+			return "", fmt.Errorf("model returned no choices")
+		}
+		text := response.Choices[0].Content
+
+		lastErr = validateStructuredOutput(opts.ResponseSchema, text)
+		if lastErr == nil {
+			if chunkCallback != nil {
+				chunkCallback([]byte(text))
+			}
+			return text, nil
+		}
+
+		// Show the model its answer and what is wrong with it
+		log.Warn().Err(lastErr).Msgf("Structured output attempt %d did not match the schema.", attempt+1)
+		messages = append(messages,
+			llms.TextParts(llms.ChatMessageTypeAI, text),
+			llms.TextParts(llms.ChatMessageTypeHuman, fmt.Sprintf(
+				"Your response does not match the schema: %s\nRespond again with only the corrected JSON object.",
+				lastErr,
+			)),
+		)
+	}
+
+	return "", fmt.Errorf("%w after %d attempts: %v", ErrInvalidStructuredOutput, opts.StructuredRetries+1, lastErr)
+}
+
+// validateStructuredOutput checks that the text is a single JSON value matching the schema.
+func validateStructuredOutput(schema *JSONSchema, text string) error {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(text)))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("invalid JSON: unexpected content after the JSON value")
+	}
+	return schema.Validate(value)
+}
+
+// decodeStructuredOutput decodes a validated answer.
+func decodeStructuredOutput(text string) (any, error) {
+	var object any
+	if err := json.Unmarshal([]byte(text), &object); err != nil {
+		return nil, fmt.Errorf("failed to decode structured output: %w", err)
+	}
+	return object, nil
+}
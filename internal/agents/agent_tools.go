@@ -0,0 +1,253 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/tools"
+)
+
+// SchemaTool is a tool that declares a JSON schema for its arguments.
+// Its Call receives the arguments as a JSON object.
+type SchemaTool interface {
+	tools.Tool
+	Schema() map[string]any
+}
+
+// FunctionTool is a tool defined by a JSON schema and a handler.
+type FunctionTool struct {
+	ToolName        string
+	ToolDescription string
+	Parameters      map[string]any
+	Handler         func(ctx context.Context, arguments string) (string, error)
+}
+
+var _ SchemaTool = FunctionTool{}
+
+func (t FunctionTool) Name() string {
+	return t.ToolName
+}
+
+func (t FunctionTool) Description() string {
+	return t.ToolDescription
+}
+
+func (t FunctionTool) Schema() map[string]any {
+	return t.Parameters
+}
+
+func (t FunctionTool) Call(ctx context.Context, arguments string) (string, error) {
+	return t.Handler(ctx, arguments)
+}
+
+// toolSchema returns the JSON schema of a tool's arguments. Plain tools take a single string input.
+func toolSchema(tool tools.Tool) map[string]any {
+	if schemaTool, ok := tool.(SchemaTool); ok && schemaTool.Schema() != nil {
+		return schemaTool.Schema()
+	}
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"input": map[string]any{"type": "string", "description": "The input to the tool."},
+		},
+		"required": []string{"input"},
+	}
+}
+
+// callWithArguments invokes a tool with the JSON arguments produced by native tool calling.
+func callWithArguments(ctx context.Context, tool tools.Tool, arguments string) (string, error) {
+	if _, ok := tool.(SchemaTool); ok {
+		return tool.Call(ctx, arguments)
+	}
+
+	var args struct {
+		Input string `json:"input"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", fmt.Errorf("invalid arguments for tool %s: %w", tool.Name(), err)
+	}
+	return tool.Call(ctx, args.Input)
+}
+
+// ToolRegistry holds the tools the agent can use, keyed by name, which of them each org enabled,
+// and which need approval. Tools registered for an org are only available to that org.
+type ToolRegistry struct {
+	mutex       sync.RWMutex
+	tools       map[string]tools.Tool
+	orgOnly     map[string]map[string]tools.Tool
+	orgEnabled  map[string][]string
+	orgApproval map[string]map[string]bool
+}
+
+// NewToolRegistry creates a registry with the given tools.
+func NewToolRegistry(initial ...tools.Tool) *ToolRegistry {
+	registry := &ToolRegistry{
+		tools:       make(map[string]tools.Tool),
+		orgOnly:     make(map[string]map[string]tools.Tool),
+		orgEnabled:  make(map[string][]string),
+		orgApproval: make(map[string]map[string]bool),
+	}
+	for _, tool := range initial {
+		registry.Register(tool)
+	}
+	return registry
+}
+
+// Register adds a tool, replacing any tool with the same name.
+func (r *ToolRegistry) Register(tool tools.Tool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tools[strings.ToLower(tool.Name())] = tool
+}
+
+// RegisterForOrg adds a tool available only to the given org, replacing any of its tools with the same name.
+func (r *ToolRegistry) RegisterForOrg(orgID string, tool tools.Tool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.orgOnly[orgID] == nil {
+		r.orgOnly[orgID] = make(map[string]tools.Tool)
+	}
+	r.orgOnly[orgID][strings.ToLower(tool.Name())] = tool
+}
+
+// Get returns the tool with the given name available to the org. The org's own tools take precedence.
+func (r *ToolRegistry) Get(orgID, name string) (tools.Tool, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.get(orgID, strings.ToLower(name))
+}
+
+func (r *ToolRegistry) get(orgID, name string) (tools.Tool, bool) {
+	if tool, ok := r.orgOnly[orgID][name]; ok {
+		return tool, true
+	}
+	tool, ok := r.tools[name]
+	return tool, ok
+}
+
+// List returns all tools available to the org, enabled or not, sorted by name.
+func (r *ToolRegistry) List(orgID string) []tools.Tool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	available := make(map[string]tools.Tool, len(r.tools)+len(r.orgOnly[orgID]))
+	for name, tool := range r.tools {
+		available[name] = tool
+	}
+	for name, tool := range r.orgOnly[orgID] {
+		available[name] = tool
+	}
+
+	list := make([]tools.Tool, 0, len(available))
+	for _, tool := range available {
+		list = append(list, tool)
+	}
+	sortTools(list)
+	return list
+}
+
+// SetOrgTools enables exactly the named tools for an org.
+func (r *ToolRegistry) SetOrgTools(orgID string, names []string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	enabled := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if _, ok := r.get(orgID, name); !ok {
+			return fmt.Errorf("unknown tool: %s", name)
+		}
+		enabled = append(enabled, name)
+	}
+	r.orgEnabled[orgID] = enabled
+	return nil
+}
+
+// ForOrg returns the tools enabled for an org. Orgs without explicit settings get every available tool.
+func (r *ToolRegistry) ForOrg(orgID string) []tools.Tool {
+	r.mutex.RLock()
+	names, configured := r.orgEnabled[orgID]
+	r.mutex.RUnlock()
+
+	if !configured {
+		return r.List(orgID)
+	}
+
+	list := make([]tools.Tool, 0, len(names))
+	for _, name := range names {
+		if tool, ok := r.Get(orgID, name); ok {
+			list = append(list, tool)
+		}
+	}
+	sortTools(list)
+	return list
+}
+
+// SetOrgApproval sets which tools need approval before running for an org, in addition to
+// tools that declare it themselves.
+func (r *ToolRegistry) SetOrgApproval(orgID string, names []string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	approval := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if _, ok := r.get(orgID, name); !ok {
+			return fmt.Errorf("unknown tool: %s", name)
+		}
+		approval[name] = true
+	}
+	r.orgApproval[orgID] = approval
+	return nil
+}
+
+// RequiresApproval reports whether calls to the tool must be approved for the org.
+func (r *ToolRegistry) RequiresApproval(orgID, name string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	name = strings.ToLower(name)
+	if r.orgApproval[orgID][name] {
+		return true
+	}
+	tool, ok := r.get(orgID, name)
+	if !ok {
+		return false
+	}
+	approvalTool, ok := tool.(ApprovalTool)
+	return ok && approvalTool.RequiresApproval()
+}
+
+func sortTools(list []tools.Tool) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+}
+
+// defaultTools returns the built-in tools available to every agent.
+func defaultTools() []tools.Tool {
+	return []tools.Tool{
+		tools.Calculator{},
+		currentTimeTool{},
+	}
+}
+
+// currentTimeTool reports the current date and time, which the model cannot know on its own.
+type currentTimeTool struct{}
+
+func (currentTimeTool) Name() string {
+	return "current_time"
+}
+
+func (currentTimeTool) Description() string {
+	return "Useful for getting the current date and time in UTC. The input is ignored."
+}
+
+func (currentTimeTool) Call(_ context.Context, _ string) (string, error) {
+	return time.Now().UTC().Format(time.RFC1123), nil
+}
@@ -0,0 +1,85 @@
+package agents
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Usage is the number of tokens the model calls of a query consumed.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// usageTracker adds up the usage of a query's model calls.
+type usageTracker struct {
+	mutex sync.Mutex
+	usage Usage
+}
+
+func (t *usageTracker) add(usage Usage) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.usage.PromptTokens += usage.PromptTokens
+	t.usage.CompletionTokens += usage.CompletionTokens
+	t.usage.TotalTokens += usage.TotalTokens
+}
+
+func (t *usageTracker) total() Usage {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.usage
+}
+
+// usageModel records the usage of every call to the wrapped model.
+type usageModel struct {
+	llms.Model
+	tracker *usageTracker
+}
+
+func (m *usageModel) GenerateContent(
+	ctx context.Context,
+	messages []llms.MessageContent,
+	options ...llms.CallOption,
+) (*llms.ContentResponse, error) {
+	response, err := m.Model.GenerateContent(ctx, messages, options...)
+	if err != nil {
+		return nil, err
+	}
+	m.tracker.add(responseUsage(messages, response))
+	return response, nil
+}
+
+func (m *usageModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// responseUsage reads the usage reported with the response, estimating it when the
+// provider did not report any.
+func responseUsage(messages []llms.MessageContent, response *llms.ContentResponse) Usage {
+	var usage Usage
+	for _, choice := range response.Choices {
+		prompt, _ := choice.GenerationInfo["PromptTokens"].(int)
+		completion, _ := choice.GenerationInfo["CompletionTokens"].(int)
+		total, _ := choice.GenerationInfo["TotalTokens"].(int)
+		if total > 0 {
+			return Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: total}
+		}
+		usage.CompletionTokens += estimateTokens(choice.Content)
+	}
+
+	for _, message := range messages {
+		for _, part := range message.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				usage.PromptTokens += estimateTokens(text.Text)
+			}
+		}
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
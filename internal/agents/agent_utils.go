@@ -3,8 +3,10 @@ package agents
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -35,6 +37,130 @@ func (am *AgentManager) GetThreadMemory(threadID string) *memory.ConversationBuf
 	return newMemory
 }
 
+// threadOwner is the user and org a thread belongs to.
+type threadOwner struct {
+	UserID string
+	OrgID  string
+}
+
+// ErrThreadNotOwned is returned when a user or org uses a thread that belongs to another.
+var ErrThreadNotOwned = errors.New("thread belongs to another user")
+
+// claimThread records the user and org a thread belongs to on its first use. It fails if
+// the thread already belongs to another user or org.
+func (am *AgentManager) claimThread(threadID, userID, orgID string) error {
+	am.memoryMutex.Lock()
+	defer am.memoryMutex.Unlock()
+
+	if am.threadOwners == nil {
+		am.threadOwners = make(map[string]threadOwner)
+	}
+	if owner, ok := am.threadOwners[threadID]; ok {
+		if owner.UserID != userID || owner.OrgID != orgID {
+			return ErrThreadNotOwned
+		}
+		return nil
+	}
+	am.threadOwners[threadID] = threadOwner{UserID: userID, OrgID: orgID}
+	return nil
+}
+
+// CheckThreadOwner returns ErrThreadNotOwned if the thread belongs to another user or org.
+// Threads not used yet are free to claim.
+func (am *AgentManager) CheckThreadOwner(threadID, userID, orgID string) error {
+	ownerUserID, ownerOrgID, ok := am.ThreadOwner(threadID)
+	if ok && (ownerUserID != userID || ownerOrgID != orgID) {
+		return ErrThreadNotOwned
+	}
+	return nil
+}
+
+// ThreadOwner returns the user and org a thread belongs to.
+func (am *AgentManager) ThreadOwner(threadID string) (userID, orgID string, ok bool) {
+	am.memoryMutex.Lock()
+	defer am.memoryMutex.Unlock()
+
+	owner, ok := am.threadOwners[threadID]
+	return owner.UserID, owner.OrgID, ok
+}
+
+// ThreadsForUser lists the IDs of the threads a user has in an org.
+func (am *AgentManager) ThreadsForUser(orgID, userID string) []string {
+	am.memoryMutex.Lock()
+	defer am.memoryMutex.Unlock()
+
+	var threadIDs []string
+	for threadID, owner := range am.threadOwners {
+		if owner.OrgID == orgID && owner.UserID == userID {
+			threadIDs = append(threadIDs, threadID)
+		}
+	}
+	sort.Strings(threadIDs)
+	return threadIDs
+}
+
+// SetThreadMetadata records a value in the thread's metadata.
+func (am *AgentManager) SetThreadMetadata(threadID, key string, value any) {
+	am.memoryMutex.Lock()
+	defer am.memoryMutex.Unlock()
+
+	if am.threadMetadata == nil {
+		am.threadMetadata = make(map[string]map[string]any)
+	}
+	if am.threadMetadata[threadID] == nil {
+		am.threadMetadata[threadID] = make(map[string]any)
+	}
+	am.threadMetadata[threadID][key] = value
+}
+
+// ThreadMetadata returns a copy of the thread's metadata.
+func (am *AgentManager) ThreadMetadata(threadID string) map[string]any {
+	am.memoryMutex.Lock()
+	defer am.memoryMutex.Unlock()
+
+	metadata := make(map[string]any, len(am.threadMetadata[threadID]))
+	for key, value := range am.threadMetadata[threadID] {
+		metadata[key] = value
+	}
+	return metadata
+}
+
+// ReleaseThread forgets a thread: its chat history, owner, metadata, branches and any pending
+// action. Conversation chunks already buffered for long-term memory are kept.
+func (am *AgentManager) ReleaseThread(threadID string) {
+	am.memoryMutex.Lock()
+	delete(am.AgentMemory, threadID)
+	delete(am.threadOwners, threadID)
+	delete(am.threadMetadata, threadID)
+	delete(am.pendingActions, threadID)
+	am.memoryMutex.Unlock()
+
+	am.treeMutex.Lock()
+	delete(am.threadTrees, threadID)
+	am.treeMutex.Unlock()
+}
+
+// SeedThreadHistory appends earlier turns to a thread's chat history, for clients that send
+// the whole conversation with each request. Roles are "user" and "ai", as returned by RetrieveMemory.
+func (am *AgentManager) SeedThreadHistory(ctx context.Context, threadID string, turns []map[string]string) error {
+	threadMemory := am.GetThreadMemory(threadID)
+	for _, turn := range turns {
+		var err error
+		switch turn["role"] {
+		case "user":
+			err = threadMemory.ChatHistory.AddUserMessage(ctx, turn["content"])
+		case "ai":
+			err = threadMemory.ChatHistory.AddAIMessage(ctx, turn["content"])
+		default:
+			err = fmt.Errorf("unsupported role: %s", turn["role"])
+		}
+		if err != nil {
+			return fmt.Errorf("failed to seed history of thread %s: %w", threadID, err)
+		}
+	}
+	return nil
+}
+
 // RetrieveMemory retrieves the chat history for a specific thread
 func (am *AgentManager) RetrieveMemory(ctx context.Context, threadID string) ([]map[string]string, error) {
 	log := logger.GetLogger()
@@ -67,13 +193,23 @@ func formatMessages(messages []llms.ChatMessage) []map[string]string {
 	var formattedMessages []map[string]string
 	for _, msg := range messages {
 		role := "user"
-		if msg.GetType() == llms.ChatMessageTypeAI {
+		switch msg.GetType() {
+		case llms.ChatMessageTypeAI:
 			role = "ai"
+		case llms.ChatMessageTypeTool:
+			role = "tool"
 		}
-		formattedMessages = append(formattedMessages, map[string]string{
+		formatted := map[string]string{
 			"role":    role,
 			"content": msg.GetContent(),
-		})
+		}
+
+		// Include the tool calls requested by the model
+		if aiMessage, ok := msg.(llms.AIChatMessage); ok && len(aiMessage.ToolCalls) > 0 {
+			toolCalls, _ := json.Marshal(aiMessage.ToolCalls)
+			formatted["tool_calls"] = string(toolCalls)
+		}
+		formattedMessages = append(formattedMessages, formatted)
 	}
 	return formattedMessages
 }
@@ -93,7 +229,7 @@ func (am *AgentManager) addToBuffer(threadID, input, response, userID, orgID str
 				"user_id":   userID,
 				"org_id":    orgID,
 				"timestamp": time.Now().Format(time.RFC3339),
-				"source":    "conversation",
+				"source":    SourceConversation,
 			},
 		}
 
@@ -124,25 +260,40 @@ func (am *AgentManager) flushBufferToWeaviate(ctx context.Context) {
 	log := logger.GetLogger()
 	log.Debug().Msgf("Batch inserting %d unique messages into Weaviate...", len(uniqueDocs))
 
-	// Assuming each document has org_id in its metadata
-	if len(uniqueDocs) > 0 {
-		orgID, ok := uniqueDocs[0].Metadata["org_id"].(string)
-		if !ok {
+	if err := am.addConversationDocuments(ctx, uniqueDocs); err != nil {
+		log.Error().Err(err).Msg("Failed to batch insert messages into Weaviate.")
+		return
+	}
+
+	log.Debug().Msg("Batch insertion to Weaviate completed successfully.")
+}
+
+// addConversationDocuments stores conversation chunks in the conversation namespace of
+// their org, so they never mix with the org's knowledge documents.
+func (am *AgentManager) addConversationDocuments(ctx context.Context, docs []schema.Document) error {
+	log := logger.GetLogger()
+
+	// Group documents by org_id, a buffer may hold messages from several orgs
+	byOrg := make(map[string][]schema.Document)
+	for _, doc := range docs {
+		orgID, ok := doc.Metadata["org_id"].(string)
+		if !ok || orgID == "" {
 			log.Error().Msg("Failed to retrieve org_id from document metadata.")
-			return
+			continue
 		}
+		byOrg[orgID] = append(byOrg[orgID], doc)
+	}
 
-		namespace := orgID
+	for orgID, orgDocs := range byOrg {
+		namespace := memoryNamespace(orgID)
 		log.Debug().Msgf("Using namespace: %s for flushing buffer to Weaviate", namespace)
 
-		_, err := am.VectorStore.AddDocuments(ctx, uniqueDocs, vectorstores.WithNameSpace(namespace))
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to batch insert messages into Weaviate.")
-			return
+		if _, err := am.VectorStore.AddDocuments(ctx, orgDocs, vectorstores.WithNameSpace(namespace)); err != nil {
+			return fmt.Errorf("failed to add conversation documents for org_id %s: %w", orgID, err)
 		}
 	}
 
-	log.Debug().Msg("Batch insertion to Weaviate completed successfully.")
+	return nil
 }
 
 // deduplicateDocuments removes duplicate documents from a slice based on PageContent.
@@ -173,7 +324,7 @@ func (am *AgentManager) SyncMemory(ctx context.Context) error {
 	}
 
 	log.Debug().Msgf("Syncing %d messages from buffer to Weaviate...", len(am.messageBuffer))
-	_, err := am.VectorStore.AddDocuments(ctx, am.messageBuffer)
+	err := am.addConversationDocuments(ctx, am.messageBuffer)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to sync messages to Weaviate.")
 		return err
@@ -191,7 +342,14 @@ func (am *AgentManager) AddDocuments(ctx context.Context, docs []schema.Document
 	// Add user_id to document metadata
 	for i := range docs {
 		docs[i].Metadata["user_id"] = userID
+		if err := normalizeTimestamp(docs[i].Metadata); err != nil {
+			return err
+		}
 	}
+	documentCount := len(docs)
+
+	// Index small chunks linked to their parent document
+	docs = ChunkDocuments(docs, am.ChunkWords)
 
 	// Use org_id as namespace
 	namespace := orgID
@@ -210,6 +368,11 @@ func (am *AgentManager) AddDocuments(ctx context.Context, docs []schema.Document
 			}
 		} else {
 			log.Info().Msgf("Documents added to vector store successfully on attempt %d.", attempt)
+			am.Events.Publish(Event{
+				Type:  EventIngestionComplete,
+				OrgID: orgID,
+				Data:  map[string]any{"user_id": userID, "documents": documentCount},
+			})
 			return nil
 		}
 	}
@@ -219,6 +382,68 @@ func (am *AgentManager) AddDocuments(ctx context.Context, docs []schema.Document
 	return fmt.Errorf("failed to add documents to vector store: %w", err)
 }
 
+// Document ingestion errors.
+var (
+	ErrEmptyDocument    = errors.New("document content is empty")
+	ErrInvalidDataset   = errors.New("dataset is not a JSON list of objects")
+	ErrInvalidTimestamp = errors.New("'timestamp' must be an RFC3339 date-time or a YYYY-MM-DD date")
+)
+
+// AddKnowledgeDocument splits a document into chunks linked to a parent ID and adds them to
+// the default knowledge base. It returns the parent ID and the number of chunks.
+func (am *AgentManager) AddKnowledgeDocument(ctx context.Context, content string, metadata map[string]string) (string, int, error) {
+	doc := schema.Document{
+		PageContent: content,
+		Metadata:    ConvertMetadata(metadata),
+	}
+	if err := normalizeTimestamp(doc.Metadata); err != nil {
+		return "", 0, err
+	}
+
+	chunks := ChunkDocuments([]schema.Document{doc}, am.ChunkWords)
+	if len(chunks) == 0 {
+		return "", 0, ErrEmptyDocument
+	}
+
+	if _, err := am.VectorStore.AddDocuments(ctx, chunks); err != nil {
+		return "", 0, fmt.Errorf("failed to add document: %w", err)
+	}
+
+	parentID, _ := chunks[0].Metadata["parent_id"].(string)
+	return parentID, len(chunks), nil
+}
+
+// ImportDataset adds the items of a JSON dataset file that have a summary to the org's
+// knowledge base. It returns the number of documents added.
+func (am *AgentManager) ImportDataset(ctx context.Context, filePath, userID, orgID string) (int, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open dataset: %w", err)
+	}
+	defer file.Close()
+
+	var dataset []map[string]interface{}
+	if err := json.NewDecoder(file).Decode(&dataset); err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidDataset, err)
+	}
+
+	// Items are indexed by their summary
+	docs := []schema.Document{}
+	for _, item := range dataset {
+		if summary, ok := item["summary"].(string); ok {
+			docs = append(docs, schema.Document{
+				PageContent: summary,
+				Metadata:    item,
+			})
+		}
+	}
+
+	if err := am.AddDocuments(ctx, docs, userID, orgID); err != nil {
+		return 0, err
+	}
+	return len(docs), nil
+}
+
 // AddDataset adds a dataset to the vector store
 func (am *AgentManager) AddDataset(ctx context.Context, threadID, filePath string) error {
 	file, err := os.Open(filePath)
@@ -315,3 +540,24 @@ func ConvertMetadata(metadata map[string]string) map[string]any {
 	}
 	return converted
 }
+
+// normalizeTimestamp rewrites a "timestamp" metadata value as an RFC3339 UTC date-time,
+// the format Weaviate accepts for the date property.
+func normalizeTimestamp(metadata map[string]any) error {
+	value, ok := metadata["timestamp"]
+	if !ok {
+		return nil
+	}
+	ts, ok := value.(string)
+	if !ok {
+		return ErrInvalidTimestamp
+	}
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		if t, err = time.Parse(time.DateOnly, ts); err != nil {
+			return ErrInvalidTimestamp
+		}
+	}
+	metadata["timestamp"] = t.UTC().Format(time.RFC3339)
+	return nil
+}
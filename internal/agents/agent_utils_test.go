@@ -0,0 +1,119 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tmc/langchaingo/memory"
+)
+
+func TestNormalizeTimestamp(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   any
+		want    string
+		wantErr bool
+	}{
+		{name: "utc date-time", value: "2024-03-01T10:00:00Z", want: "2024-03-01T10:00:00Z"},
+		{name: "offset converted to utc", value: "2024-03-01T10:00:00+02:00", want: "2024-03-01T08:00:00Z"},
+		{name: "fractional seconds", value: "2024-03-01T10:00:00.123Z", want: "2024-03-01T10:00:00Z"},
+		{name: "date only", value: "2024-03-01", want: "2024-03-01T00:00:00Z"},
+		{name: "free text", value: "last tuesday", wantErr: true},
+		{name: "unix seconds", value: 1709287200.0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := map[string]any{"timestamp": tt.value}
+			err := normalizeTimestamp(metadata)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidTimestamp) {
+					t.Fatalf("normalizeTimestamp returned %v, want ErrInvalidTimestamp", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalizeTimestamp returned %v", err)
+			}
+			if metadata["timestamp"] != tt.want {
+				t.Fatalf("timestamp = %v, want %s", metadata["timestamp"], tt.want)
+			}
+		})
+	}
+
+	if err := normalizeTimestamp(map[string]any{"source": "wiki"}); err != nil {
+		t.Fatalf("normalizeTimestamp without a timestamp returned %v", err)
+	}
+}
+
+func TestClaimThread(t *testing.T) {
+	am := &AgentManager{}
+
+	if err := am.CheckThreadOwner("t1", "alice", "acme"); err != nil {
+		t.Fatalf("CheckThreadOwner on a new thread returned %v", err)
+	}
+	if err := am.claimThread("t1", "alice", "acme"); err != nil {
+		t.Fatalf("first claim returned %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		userID  string
+		orgID   string
+		wantErr error
+	}{
+		{name: "owner", userID: "alice", orgID: "acme"},
+		{name: "other user", userID: "bob", orgID: "acme", wantErr: ErrThreadNotOwned},
+		{name: "same user in another org", userID: "alice", orgID: "globex", wantErr: ErrThreadNotOwned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := am.claimThread("t1", tt.userID, tt.orgID); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("claimThread returned %v, want %v", err, tt.wantErr)
+			}
+			if err := am.CheckThreadOwner("t1", tt.userID, tt.orgID); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckThreadOwner returned %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A rejected claim leaves the owner unchanged
+	if userID, orgID, _ := am.ThreadOwner("t1"); userID != "alice" || orgID != "acme" {
+		t.Fatalf("thread owner changed to %s/%s", userID, orgID)
+	}
+}
+
+func TestReleaseThread(t *testing.T) {
+	ctx := context.Background()
+	am := &AgentManager{
+		AgentMemory:    map[string]*memory.ConversationBuffer{},
+		pendingActions: map[string]*PendingAction{"t1": {ID: "a1"}},
+	}
+
+	if err := am.claimThread("t1", "alice", "acme"); err != nil {
+		t.Fatalf("claimThread: %v", err)
+	}
+	if err := am.SeedThreadHistory(ctx, "t1", []map[string]string{{"role": "user", "content": "Hi"}}); err != nil {
+		t.Fatalf("SeedThreadHistory: %v", err)
+	}
+	am.SetThreadMetadata("t1", "agent", "billing")
+
+	am.ReleaseThread("t1")
+
+	if _, _, ok := am.ThreadOwner("t1"); ok {
+		t.Error("thread owner was kept")
+	}
+	if _, ok := am.AgentMemory["t1"]; ok {
+		t.Error("chat history was kept")
+	}
+	if len(am.ThreadMetadata("t1")) != 0 {
+		t.Error("thread metadata was kept")
+	}
+	if _, ok := am.PendingAction("t1"); ok {
+		t.Error("pending action was kept")
+	}
+	if err := am.claimThread("t1", "bob", "acme"); err != nil {
+		t.Errorf("a released thread could not be claimed again: %v", err)
+	}
+}
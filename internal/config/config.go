@@ -3,6 +3,7 @@ package config
 
 import (
 	"log"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -14,6 +15,64 @@ type Config struct {
 	WeaviateAPIKey    string `mapstructure:"WEAVIATE_API_KEY"`
 	WeaviateIndexName string `mapstructure:"WEAVIATE_INDEX_NAME"`
 	Debug             string `mapstructure:"DEBUG"`
+
+	// Retrieval defaults, overridable per query
+	KnowledgeRetrievalEnabled bool          `mapstructure:"KNOWLEDGE_RETRIEVAL_ENABLED"`
+	KnowledgeTopK             int           `mapstructure:"KNOWLEDGE_TOP_K"`
+	KnowledgeHalfLife         time.Duration `mapstructure:"KNOWLEDGE_HALF_LIFE"`
+	MMREnabled                bool          `mapstructure:"MMR_ENABLED"`
+	MMRLambda                 float64       `mapstructure:"MMR_LAMBDA"`
+	KnowledgeExpansion        string        `mapstructure:"KNOWLEDGE_EXPANSION"`
+	CorrectionTopK            int           `mapstructure:"CORRECTION_TOP_K"`
+	CorrectionBoost           float64       `mapstructure:"CORRECTION_BOOST"`
+	NeighborWindow            int           `mapstructure:"NEIGHBOR_WINDOW"`
+	ContextTokenBudget        int           `mapstructure:"CONTEXT_TOKEN_BUDGET"`
+	ChunkWords                int           `mapstructure:"CHUNK_WORDS"`
+	MemoryRetrievalEnabled    bool          `mapstructure:"MEMORY_RETRIEVAL_ENABLED"`
+	MemoryTopK                int           `mapstructure:"MEMORY_TOP_K"`
+	MemoryScope               string        `mapstructure:"MEMORY_SCOPE"`
+	MemoryHalfLife            time.Duration `mapstructure:"MEMORY_HALF_LIFE"`
+
+	AgentMaxIterations int `mapstructure:"AGENT_MAX_ITERATIONS"`
+
+	// Declarative HTTP tools, keyed by org in a JSON file
+	HTTPToolsFile            string        `mapstructure:"HTTP_TOOLS_FILE"`
+	HTTPToolAllowedHosts     []string      `mapstructure:"HTTP_TOOL_ALLOWED_HOSTS"`
+	HTTPToolTimeout          time.Duration `mapstructure:"HTTP_TOOL_TIMEOUT"`
+	HTTPToolMaxResponseBytes int64         `mapstructure:"HTTP_TOOL_MAX_RESPONSE_BYTES"`
+
+	// MCP servers, keyed by org in a JSON file
+	MCPServersFile string `mapstructure:"MCP_SERVERS_FILE"`
+
+	// Webhook notified when a tool call awaits approval
+	ApprovalWebhookURL string `mapstructure:"APPROVAL_WEBHOOK_URL"`
+
+	// Intent routing to sub-agents
+	RoutingEnabled  bool   `mapstructure:"ROUTING_ENABLED"`
+	AgentRoutesFile string `mapstructure:"AGENT_ROUTES_FILE"`
+
+	// Corrections asked for when a structured answer does not match its schema
+	StructuredOutputRetries int `mapstructure:"STRUCTURED_OUTPUT_RETRIES"`
+
+	// Directory holding persistent settings such as org personas and prompt versions
+	DataDir string `mapstructure:"DATA_DIR"`
+
+	// How long the events of a finished streamed generation can be replayed, and how long a
+	// running one may go unfollowed before it is cancelled
+	StreamBufferTTL time.Duration `mapstructure:"STREAM_BUFFER_TTL"`
+
+	// Number of streamed generations an org can run at once
+	MaxGenerationsPerOrg int `mapstructure:"MAX_GENERATIONS_PER_ORG"`
+
+	// Address of the gRPC API, disabled when empty. It has no TLS or authentication,
+	// so it must only be reachable from trusted clients.
+	GRPCAddress string `mapstructure:"GRPC_ADDRESS"`
+
+	// Number of batch queries answered at once, across all batches
+	BatchConcurrency int `mapstructure:"BATCH_CONCURRENCY"`
+
+	// Number of batches an org can run at once
+	MaxBatchesPerOrg int `mapstructure:"MAX_BATCHES_PER_ORG"`
 }
 
 // LoadConfig loads environment variables into the Config struct
@@ -24,6 +83,39 @@ func LoadConfig() (*Config, error) {
 
 	viper.AutomaticEnv()
 
+	// Defaults also register the keys so they can be set from the environment alone
+	viper.SetDefault("KNOWLEDGE_RETRIEVAL_ENABLED", true)
+	viper.SetDefault("KNOWLEDGE_TOP_K", 5)
+	viper.SetDefault("KNOWLEDGE_HALF_LIFE", "0s")
+	viper.SetDefault("MMR_ENABLED", false)
+	viper.SetDefault("MMR_LAMBDA", 0.5)
+	viper.SetDefault("KNOWLEDGE_EXPANSION", "parent")
+	viper.SetDefault("CORRECTION_TOP_K", 2)
+	viper.SetDefault("CORRECTION_BOOST", 1.5)
+	viper.SetDefault("NEIGHBOR_WINDOW", 1)
+	viper.SetDefault("CONTEXT_TOKEN_BUDGET", 3000)
+	viper.SetDefault("CHUNK_WORDS", 100)
+	viper.SetDefault("MEMORY_RETRIEVAL_ENABLED", true)
+	viper.SetDefault("MEMORY_TOP_K", 3)
+	viper.SetDefault("MEMORY_SCOPE", "user")
+	viper.SetDefault("MEMORY_HALF_LIFE", "0s")
+	viper.SetDefault("AGENT_MAX_ITERATIONS", 5)
+	viper.SetDefault("HTTP_TOOLS_FILE", "")
+	viper.SetDefault("HTTP_TOOL_ALLOWED_HOSTS", "")
+	viper.SetDefault("HTTP_TOOL_TIMEOUT", "10s")
+	viper.SetDefault("HTTP_TOOL_MAX_RESPONSE_BYTES", 65536)
+	viper.SetDefault("MCP_SERVERS_FILE", "")
+	viper.SetDefault("APPROVAL_WEBHOOK_URL", "")
+	viper.SetDefault("ROUTING_ENABLED", false)
+	viper.SetDefault("AGENT_ROUTES_FILE", "")
+	viper.SetDefault("STRUCTURED_OUTPUT_RETRIES", 2)
+	viper.SetDefault("DATA_DIR", "data")
+	viper.SetDefault("STREAM_BUFFER_TTL", "5m")
+	viper.SetDefault("MAX_GENERATIONS_PER_ORG", 10)
+	viper.SetDefault("GRPC_ADDRESS", "")
+	viper.SetDefault("BATCH_CONCURRENCY", 4)
+	viper.SetDefault("MAX_BATCHES_PER_ORG", 2)
+
 	// Load the config file
 	if err := viper.ReadInConfig(); err != nil {
 		log.Printf("Warning: No .env file found (%v), loading from environment variables only.", err)
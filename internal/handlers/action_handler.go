@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/blog/conversational-agent/internal/agents"
+	"github.com/labstack/echo/v4"
+)
+
+// GetPendingActionHandler returns the action one of the user's threads is waiting on.
+func (h *AgentHandler) GetPendingActionHandler(c echo.Context) error {
+	userID := c.Param("user_id")
+	orgID := c.Param("org_id")
+	threadID := c.Param("thread_id")
+	if threadID == "" || userID == "" || orgID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "'thread_id', 'user_id', and 'org_id' are required"})
+	}
+	if err := h.AgentManager.CheckThreadOwner(threadID, userID, orgID); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+
+	action, ok := h.AgentManager.PendingAction(threadID)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": agents.ErrNoPendingAction.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"action": action})
+}
+
+// ApproveActionHandler approves the thread's pending tool calls and resumes the agent.
+func (h *AgentHandler) ApproveActionHandler(c echo.Context) error {
+	return h.resolveAction(c, true)
+}
+
+// RejectActionHandler rejects the thread's pending tool calls and resumes the agent.
+func (h *AgentHandler) RejectActionHandler(c echo.Context) error {
+	return h.resolveAction(c, false)
+}
+
+func (h *AgentHandler) resolveAction(c echo.Context, approved bool) error {
+	type ResolveRequest struct {
+		ActionID string `json:"action_id"`
+		Reason   string `json:"reason"`
+	}
+
+	userID := c.Param("user_id")
+	orgID := c.Param("org_id")
+	threadID := c.Param("thread_id")
+	if threadID == "" || userID == "" || orgID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "'thread_id', 'user_id', and 'org_id' are required"})
+	}
+	if err := h.AgentManager.CheckThreadOwner(threadID, userID, orgID); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+
+	var req ResolveRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+	}
+	if req.ActionID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "'action_id' is required"})
+	}
+
+	steps := []agents.AgentStep{}
+	var messageID string
+	response, err := h.AgentManager.ResolvePendingAction(
+		c.Request().Context(),
+		userID,
+		orgID,
+		threadID,
+		req.ActionID,
+		approved,
+		req.Reason,
+		nil,
+		agents.WithStepCallback(func(step agents.AgentStep) {
+			steps = append(steps, step)
+		}),
+		agents.WithMessageCallback(func(id string) {
+			messageID = id
+		}),
+	)
+
+	// The resumed run may pause again on another tool call
+	var approvalErr *agents.ApprovalRequiredError
+	switch {
+	case errors.As(err, &approvalErr):
+		return c.JSON(http.StatusAccepted, map[string]any{
+			"status": agents.StepTypeApproval,
+			"action": approvalErr.Action,
+			"steps":  steps,
+		})
+	case errors.Is(err, agents.ErrNoPendingAction):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, agents.ErrThreadNotOwned):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{"response": response, "message_id": messageID, "steps": steps})
+}
@@ -2,25 +2,24 @@
 package handlers
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"net/http"
 
 	"github.com/blog/conversational-agent/internal/agents"
 	"github.com/labstack/echo/v4"
 )
 
+// maxTopK bounds the number of documents a query may request from a single source.
+const maxTopK = 50
+
 type AgentHandler struct {
 	AgentManager *agents.AgentManager
 }
 
 // QueryHandler handles the query request from the client.
 func (h *AgentHandler) QueryHandler(c echo.Context) error {
-	type RequestBody struct {
-		Query  string `json:"query"`
-		Stream bool   `json:"stream"`
-	}
-
-	var req RequestBody
+	var req queryRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
 	}
@@ -37,38 +36,195 @@ func (h *AgentHandler) QueryHandler(c echo.Context) error {
 		})
 	}
 
+	// Only the thread's owner may continue it
+	if err := h.AgentManager.CheckThreadOwner(threadID, userID, orgID); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+
+	return h.runQuery(c, req, userID, orgID, threadID)
+}
+
+// runQuery answers a validated query request, streamed or not. Extra options, such as the
+// branch to answer on, are added to those of the request.
+func (h *AgentHandler) runQuery(
+	c echo.Context,
+	req queryRequest,
+	userID, orgID, threadID string,
+	extra ...agents.QueryOption,
+) error {
+	// Validate the optional retrieval and generation settings
+	queryOptions, responseSchema, err := req.options(h.AgentManager)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	queryOptions = append(queryOptions, extra...)
+
+	// A thread paused for approval must be resolved first
+	if _, pending := h.AgentManager.PendingAction(threadID); pending {
+		return c.JSON(http.StatusConflict, map[string]string{"error": agents.ErrPendingAction.Error()})
+	}
+
 	if req.Stream {
-		// Streamed response
-		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
-		c.Response().Header().Set("Cache-Control", "no-cache")
-		c.Response().Header().Set("Connection", "keep-alive")
-		c.Response().WriteHeader(http.StatusOK)
-
-		_, err := h.AgentManager.Query(
-			c.Request().Context(),
-			userID,
-			orgID,
-			threadID,
-			req.Query,
-			func(chunk []byte) {
-				c.Response().Write([]byte(fmt.Sprintf("data: %s\n\n", chunk)))
-				c.Response().Flush()
-			},
+		return h.streamQuery(c, userID, orgID, threadID, req.Query, responseSchema, queryOptions)
+	}
+
+	// Non-streamed response, collecting any agent steps and the answer's message ID
+	steps := []agents.AgentStep{}
+	var messageID string
+	queryOptions = append(queryOptions,
+		agents.WithStepCallback(func(step agents.AgentStep) {
+			steps = append(steps, step)
+		}),
+		agents.WithMessageCallback(func(id string) {
+			messageID = id
+		}),
+	)
+
+	var response string
+	var output any
+	if responseSchema != nil {
+		var structured *agents.StructuredResponse
+		structured, err = h.AgentManager.QueryStructured(
+			c.Request().Context(), userID, orgID, threadID, req.Query, responseSchema, nil, queryOptions...,
 		)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		if structured != nil {
+			response, output = structured.Text, structured.Object
 		}
+	} else {
+		response, err = h.AgentManager.Query(c.Request().Context(), userID, orgID, threadID, req.Query, nil, queryOptions...)
+	}
+	var approvalErr *agents.ApprovalRequiredError
+	if errors.As(err, &approvalErr) {
+		return c.JSON(http.StatusAccepted, map[string]any{
+			"status": agents.StepTypeApproval,
+			"action": approvalErr.Action,
+			"steps":  steps,
+		})
+	}
+	if errors.Is(err, agents.ErrPendingAction) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	if errors.Is(err, agents.ErrThreadNotOwned) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if errors.Is(err, agents.ErrInvalidStructuredOutput) {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	// Report the answer's message ID, the sub-agent that answered, any agent steps and the
+	// structured output
+	body := map[string]any{"response": response}
+	if messageID != "" {
+		body["message_id"] = messageID
+	}
+	if responseSchema != nil {
+		body["output"] = output
+	}
+	for _, step := range steps {
+		if step.Type == agents.StepTypeRoute {
+			body["agent"] = step.Tool
+		}
+	}
+	if len(steps) > 0 || req.Mode == agents.ModeAgent || req.Mode == agents.ModeTools {
+		body["steps"] = steps
+	}
+	if len(body) == 1 {
+		return c.JSON(http.StatusOK, map[string]string{"response": response})
+	}
+	return c.JSON(http.StatusOK, body)
+}
 
-		c.Response().Write([]byte("data: [DONE]\n\n"))
-		c.Response().Flush()
-
-		return nil
+// streamQuery starts the query as a generation and streams its events as typed server-sent
+// events: tokens as they are generated, the retrieved sources, tool calls and other agent
+// steps, then the usage and a final done event. The generation outlives the request, so a
+// client that disconnects can resume the stream or cancel the generation by its ID, sent in
+// the X-Generation-ID header.
+func (h *AgentHandler) streamQuery(
+	c echo.Context,
+	userID, orgID, threadID, input string,
+	responseSchema *agents.JSONSchema,
+	queryOptions []agents.QueryOption,
+) error {
+	if responseSchema != nil {
+		queryOptions = append(queryOptions, agents.WithResponseSchema(responseSchema))
 	}
 
-	// Non-streamed response
-	response, err := h.AgentManager.Query(c.Request().Context(), userID, orgID, threadID, req.Query, nil)
+	generation, err := h.startGeneration(userID, orgID, threadID, input, queryOptions)
+	if errors.Is(err, agents.ErrTooManyGenerations) {
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
-	return c.JSON(http.StatusOK, map[string]string{"response": response})
+	c.Response().Header().Set(HeaderGenerationID, generation.ID)
+	return followGeneration(c, generation, 0)
+}
+
+// startGeneration runs the query as a generation publishing the events of the query stream.
+func (h *AgentHandler) startGeneration(
+	userID, orgID, threadID, input string,
+	queryOptions []agents.QueryOption,
+) (*agents.Generation, error) {
+	return h.AgentManager.Generations.Start(userID, orgID, threadID,
+		func(ctx context.Context, generation *agents.Generation) error {
+			var messageID string
+			options := append(queryOptions, streamOptions(generation.Publish)...)
+			options = append(options, agents.WithMessageCallback(func(id string) {
+				messageID = id
+			}))
+			_, err := h.AgentManager.Query(ctx, userID, orgID, threadID, input,
+				func(chunk []byte) {
+					generation.Publish(SSEEventToken, map[string]string{"text": string(chunk)})
+				},
+				options...,
+			)
+			finishStream(generation, messageID, err)
+			return err
+		},
+	)
+}
+
+// streamOptions sends the retrieved sources, the agent steps and the usage of a streamed
+// query as events.
+func streamOptions(send func(event string, data any)) []agents.QueryOption {
+	return []agents.QueryOption{
+		agents.WithSourcesCallback(func(sources []agents.Source) {
+			send(SSEEventSources, map[string]any{"sources": sources})
+		}),
+		agents.WithStepCallback(func(step agents.AgentStep) {
+			switch step.Type {
+			case agents.StepTypeAction, agents.StepTypeObservation:
+				send(SSEEventToolCall, step)
+			default:
+				send(SSEEventStep, step)
+			}
+		}),
+		agents.WithUsageCallback(func(usage agents.Usage) {
+			send(SSEEventUsage, usage)
+		}),
+	}
+}
+
+// finishStream publishes the events ending a streamed query: the pending approval, the
+// error, or the done event with the reason the generation stopped and the answer's message ID.
+func finishStream(generation *agents.Generation, messageID string, err error) {
+	done := map[string]string{"thread_id": generation.ThreadID, "generation_id": generation.ID, "finish_reason": "stop"}
+	if messageID != "" {
+		done["message_id"] = messageID
+	}
+	var approvalErr *agents.ApprovalRequiredError
+	switch {
+	case errors.As(err, &approvalErr):
+		// The client resumes the run through the approve or reject endpoint
+		generation.Publish(SSEEventApproval, approvalErr.Action)
+		done["finish_reason"] = agents.StepTypeApproval
+	case errors.Is(err, context.Canceled):
+		done["finish_reason"] = "cancelled"
+	case err != nil:
+		generation.Publish(SSEEventError, map[string]string{"message": err.Error()})
+		return
+	}
+	generation.Publish(SSEEventDone, done)
 }
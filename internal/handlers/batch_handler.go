@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/blog/conversational-agent/internal/agents"
+	"github.com/labstack/echo/v4"
+)
+
+// maxBatchQueries bounds the number of queries of a batch.
+const maxBatchQueries = 1000
+
+// batchRequest is a batch of queries sharing the settings of a query request.
+type batchRequest struct {
+	queryRequest
+	Queries []agents.BatchQuery `json:"queries"`
+}
+
+// CreateBatchHandler starts answering a batch of the org's queries in the background and
+// returns the batch, whose results are fetched once it has finished.
+func (h *AgentHandler) CreateBatchHandler(c echo.Context) error {
+	orgID := c.Param("org_id")
+	if orgID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing org_id"})
+	}
+
+	var req batchRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+	}
+
+	if len(req.Queries) == 0 || len(req.Queries) > maxBatchQueries {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("'queries' must have between 1 and %d queries", maxBatchQueries),
+		})
+	}
+	for i, query := range req.Queries {
+		if query.UserID == "" || query.Query == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("query %d: 'user_id' and 'query' are required", i),
+			})
+		}
+		if query.OrgID != "" && query.OrgID != orgID {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("query %d: 'org_id' must be the batch's org", i),
+			})
+		}
+	}
+	if req.Stream {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Batches cannot be streamed"})
+	}
+
+	// Validate the settings shared by every query
+	queryOptions, responseSchema, err := req.options(h.AgentManager)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if responseSchema != nil {
+		queryOptions = append(queryOptions, agents.WithResponseSchema(responseSchema))
+	}
+
+	batch, err := h.AgentManager.StartBatch(orgID, req.Queries, queryOptions...)
+	if errors.Is(err, agents.ErrTooManyBatches) {
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusAccepted, batch.Info())
+}
+
+// GetBatchHandler returns the status and progress of a batch.
+func (h *AgentHandler) GetBatchHandler(c echo.Context) error {
+	batch, status, err := h.orgBatch(c)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, batch.Info())
+}
+
+// GetBatchResultsHandler returns the results of a finished batch as JSON Lines, one result
+// per query in the order of the batch.
+func (h *AgentHandler) GetBatchResultsHandler(c echo.Context) error {
+	batch, status, err := h.orgBatch(c)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+	results, finished := batch.Results()
+	if !finished {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Batch is still running"})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", batch.ID+".jsonl"))
+	c.Response().WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(c.Response())
+	for _, result := range results {
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CancelBatchHandler stops a running batch. Queries not answered yet end with an error.
+func (h *AgentHandler) CancelBatchHandler(c echo.Context) error {
+	batch, status, err := h.orgBatch(c)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+	if !batch.Cancel() {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Batch has already finished"})
+	}
+	return c.JSON(http.StatusAccepted, batch.Info())
+}
+
+// orgBatch returns the batch in the request path, checking that it belongs to the org.
+func (h *AgentHandler) orgBatch(c echo.Context) (*agents.BatchJob, int, error) {
+	orgID := c.Param("org_id")
+	if orgID == "" {
+		return nil, http.StatusBadRequest, errors.New("Missing org_id")
+	}
+
+	batch, ok := h.AgentManager.Batches.Get(c.Param("batch_id"))
+	if !ok {
+		return nil, http.StatusNotFound, errors.New("Batch not found")
+	}
+	if batch.OrgID != orgID {
+		return nil, http.StatusForbidden, errors.New("batch belongs to another organization")
+	}
+	return batch, http.StatusOK, nil
+}
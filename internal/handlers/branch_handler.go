@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/blog/conversational-agent/internal/agents"
+	"github.com/labstack/echo/v4"
+)
+
+// RegenerateHandler answers the thread's last question again. The new answer is added as a
+// sibling of the previous one, which stays retrievable on its own branch.
+func (h *AgentHandler) RegenerateHandler(c echo.Context) error {
+	userID, orgID, threadID, status, err := h.userThread(c)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	var req queryRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+	}
+
+	parentID, input, err := h.AgentManager.RegenerationPoint(c.Request().Context(), threadID)
+	if err != nil {
+		return branchError(c, err)
+	}
+	req.Query = input
+	return h.runQuery(c, req, userID, orgID, threadID, agents.WithBranchFrom(parentID))
+}
+
+// EditMessageHandler asks an edited version of a past user message. The edited question and
+// its answer start a new branch from where the original was asked.
+func (h *AgentHandler) EditMessageHandler(c echo.Context) error {
+	userID, orgID, threadID, status, err := h.userThread(c)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	var req queryRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+	}
+	if req.Query == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "'query' is required"})
+	}
+
+	parentID, err := h.AgentManager.EditPoint(c.Request().Context(), threadID, c.Param("message_id"))
+	if err != nil {
+		return branchError(c, err)
+	}
+	return h.runQuery(c, req, userID, orgID, threadID, agents.WithBranchFrom(parentID))
+}
+
+// GetThreadTreeHandler returns every message of the thread's history with its parent, and
+// the last message of the active branch.
+func (h *AgentHandler) GetThreadTreeHandler(c echo.Context) error {
+	_, _, threadID, status, err := h.userThread(c)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	messages, leafID, err := h.AgentManager.ThreadTree(c.Request().Context(), threadID)
+	if err != nil {
+		return branchError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"messages": messages, "active_message_id": leafID})
+}
+
+// GetBranchHandler returns the messages from the start of the thread to the given message.
+func (h *AgentHandler) GetBranchHandler(c echo.Context) error {
+	_, _, threadID, status, err := h.userThread(c)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	messages, err := h.AgentManager.ThreadBranch(c.Request().Context(), threadID, c.Param("message_id"))
+	if err != nil {
+		return branchError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"messages": messages})
+}
+
+// ActivateBranchHandler continues the thread on the branch through the given message.
+func (h *AgentHandler) ActivateBranchHandler(c echo.Context) error {
+	_, _, threadID, status, err := h.userThread(c)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	messages, err := h.AgentManager.ActivateBranch(c.Request().Context(), threadID, c.Param("message_id"))
+	if err != nil {
+		return branchError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"messages": messages})
+}
+
+// userThread returns the user, org and thread of the route. The thread must exist and belong
+// to the user and org, otherwise the status and error to respond with are returned.
+func (h *AgentHandler) userThread(c echo.Context) (userID, orgID, threadID string, status int, err error) {
+	userID = c.Param("user_id")
+	orgID = c.Param("org_id")
+	threadID = c.Param("thread_id")
+	if threadID == "" || userID == "" || orgID == "" {
+		return "", "", "", http.StatusBadRequest, errors.New("'thread_id', 'user_id', and 'org_id' are required")
+	}
+	if _, _, ok := h.AgentManager.ThreadOwner(threadID); !ok {
+		return "", "", "", http.StatusNotFound, errors.New("Thread not found")
+	}
+	if err := h.AgentManager.CheckThreadOwner(threadID, userID, orgID); err != nil {
+		return "", "", "", http.StatusForbidden, err
+	}
+	return userID, orgID, threadID, http.StatusOK, nil
+}
+
+// branchError maps the errors of branch operations to responses.
+func branchError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, agents.ErrMessageNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, agents.ErrNotUserMessage):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, agents.ErrNoAnswer), errors.Is(err, agents.ErrPendingAction):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
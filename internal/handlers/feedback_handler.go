@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/agents"
+	"github.com/labstack/echo/v4"
+)
+
+// maxFeedbackLimit bounds the number of feedback entries listed at once.
+const maxFeedbackLimit = 1000
+
+// GetAnswerHandler returns an answer given to the user with the sources, model and prompt
+// versions behind it.
+func (h *AgentHandler) GetAnswerHandler(c echo.Context) error {
+	userID := c.Param("user_id")
+	orgID := c.Param("org_id")
+	messageID := c.Param("message_id")
+	if messageID == "" || userID == "" || orgID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "'message_id', 'user_id', and 'org_id' are required"})
+	}
+
+	answer, err := h.AgentManager.Feedback.UserAnswer(userID, orgID, messageID)
+	if err != nil {
+		return c.JSON(answerErrorStatus(err), map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, answer)
+}
+
+// SubmitFeedbackHandler records the user's feedback on an answer they were given: thumbs up
+// or down, a rating from 1 to 5, a comment and a corrected answer. With add_to_knowledge, the
+// question and the corrected answer are also added to the org's knowledge base as a curated
+// Q&A document.
+func (h *AgentHandler) SubmitFeedbackHandler(c echo.Context) error {
+	type FeedbackRequest struct {
+		Thumbs         string `json:"thumbs"`
+		Rating         *int   `json:"rating"`
+		Comment        string `json:"comment"`
+		Correction     string `json:"correction"`
+		AddToKnowledge bool   `json:"add_to_knowledge"`
+	}
+
+	userID := c.Param("user_id")
+	orgID := c.Param("org_id")
+	messageID := c.Param("message_id")
+	if messageID == "" || userID == "" || orgID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "'message_id', 'user_id', and 'org_id' are required"})
+	}
+
+	var req FeedbackRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+	}
+	feedback := agents.Feedback{
+		Thumbs:     req.Thumbs,
+		Rating:     req.Rating,
+		Comment:    req.Comment,
+		Correction: req.Correction,
+	}
+	if err := feedback.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.AddToKnowledge && strings.TrimSpace(req.Correction) == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "'add_to_knowledge' needs a 'correction'"})
+	}
+
+	feedback, err := h.AgentManager.Feedback.Submit(userID, orgID, messageID, feedback)
+	if err != nil {
+		return c.JSON(answerErrorStatus(err), map[string]string{"error": err.Error()})
+	}
+	if !req.AddToKnowledge {
+		return c.JSON(http.StatusCreated, feedback)
+	}
+
+	// The feedback is kept even if the correction cannot be added. Corrections only go into
+	// the knowledge base of the org the answer was given in.
+	documentID, err := h.AgentManager.AddCorrection(c.Request().Context(), userID, orgID, feedback)
+	if err == nil {
+		err = h.AgentManager.Feedback.SetKnowledgeDocument(feedback.ID, documentID)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error":    "Feedback recorded, but the correction could not be added to the knowledge base",
+			"feedback": feedback,
+		})
+	}
+	feedback.KnowledgeDocumentID = documentID
+	return c.JSON(http.StatusCreated, feedback)
+}
+
+// answerErrorStatus returns the HTTP status for an error looking up or giving feedback on an
+// answer.
+func answerErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, agents.ErrAnswerNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, agents.ErrAnswerNotOwned):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// ListFeedbackHandler returns an org's feedback, newest first, with a summary. The thread_id,
+// thumbs, max_rating, has_correction, prompt_version, model, since and limit query parameters
+// narrow it down.
+func (h *AgentHandler) ListFeedbackHandler(c echo.Context) error {
+	orgID := c.Param("org_id")
+	if orgID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing org_id"})
+	}
+
+	filter := agents.FeedbackFilter{
+		ThreadID:      c.QueryParam("thread_id"),
+		Thumbs:        c.QueryParam("thumbs"),
+		PromptVersion: c.QueryParam("prompt_version"),
+		Model:         c.QueryParam("model"),
+		HasCorrection: c.QueryParam("has_correction") == "true",
+		Limit:         100,
+	}
+	if filter.Thumbs != "" && filter.Thumbs != agents.ThumbsUp && filter.Thumbs != agents.ThumbsDown {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "'thumbs' must be 'up' or 'down'"})
+	}
+	if value := c.QueryParam("max_rating"); value != "" {
+		rating, err := strconv.Atoi(value)
+		if err != nil || rating < 1 || rating > 5 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "'max_rating' must be between 1 and 5"})
+		}
+		filter.MaxRating = rating
+	}
+	if value := c.QueryParam("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "'since' must be an RFC 3339 time"})
+		}
+		filter.Since = since
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxFeedbackLimit {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "'limit' must be between 1 and 1000"})
+		}
+		filter.Limit = limit
+	}
+
+	feedback, summary := h.AgentManager.Feedback.List(orgID, filter)
+	return c.JSON(http.StatusOK, map[string]any{"org_id": orgID, "feedback": feedback, "summary": summary})
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/blog/conversational-agent/internal/agents"
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderGenerationID is the response header carrying the ID of a streamed generation.
+const HeaderGenerationID = "X-Generation-ID"
+
+// headerLastEventID is the header an SSE client sends with the ID of the last event it received.
+const headerLastEventID = "Last-Event-ID"
+
+// GetGenerationHandler returns the status of a generation.
+func (h *AgentHandler) GetGenerationHandler(c echo.Context) error {
+	generation, status, err := h.userGeneration(c)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, generation.Info())
+}
+
+// StreamGenerationHandler resumes the stream of a generation. Events after the Last-Event-ID
+// header, or the last_event_id query parameter, are replayed from the buffer, then new events
+// follow until the generation finishes.
+func (h *AgentHandler) StreamGenerationHandler(c echo.Context) error {
+	generation, status, err := h.userGeneration(c)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	lastEventID := 0
+	value := c.Request().Header.Get(headerLastEventID)
+	if value == "" {
+		value = c.QueryParam("last_event_id")
+	}
+	if value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || id < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "'Last-Event-ID' must be an event ID"})
+		}
+		lastEventID = id
+	}
+
+	c.Response().Header().Set(HeaderGenerationID, generation.ID)
+	return followGeneration(c, generation, lastEventID)
+}
+
+// CancelGenerationHandler stops a running generation. The stream ends with a done event whose
+// finish reason is "cancelled".
+func (h *AgentHandler) CancelGenerationHandler(c echo.Context) error {
+	generation, status, err := h.userGeneration(c)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+	if !generation.Cancel() {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Generation has already finished"})
+	}
+	return c.JSON(http.StatusAccepted, generation.Info())
+}
+
+// userGeneration returns the generation of the route, which must belong to the route's user
+// and org, or the status and error to respond with.
+func (h *AgentHandler) userGeneration(c echo.Context) (*agents.Generation, int, error) {
+	userID := c.Param("user_id")
+	orgID := c.Param("org_id")
+	if userID == "" || orgID == "" {
+		return nil, http.StatusBadRequest, errors.New("'user_id' and 'org_id' are required")
+	}
+
+	generation, ok := h.AgentManager.Generations.Get(c.Param("generation_id"))
+	if !ok {
+		return nil, http.StatusNotFound, errors.New("Generation not found")
+	}
+	if generation.UserID != userID || generation.OrgID != orgID {
+		return nil, http.StatusForbidden, errors.New("generation belongs to another user")
+	}
+	return generation, http.StatusOK, nil
+}
+
+// followGeneration streams a generation's events after lastEventID as server-sent events,
+// until the generation finishes or the client disconnects.
+func followGeneration(c echo.Context, generation *agents.Generation, lastEventID int) error {
+	stream := newSSEWriter(c)
+	defer stream.Close()
+
+	generation.Follow(c.Request().Context(), lastEventID, func(event agents.GenerationEvent) {
+		stream.Send(event.ID, event.Type, event.Data)
+	})
+	return nil
+}
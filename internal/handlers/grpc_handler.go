@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	agentv1 "github.com/blog/conversational-agent/api/agent/v1"
+	"github.com/blog/conversational-agent/internal/agents"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCServer serves the gRPC API on top of the REST handlers' validation and agent logic.
+// Errors map to the status codes matching the REST statuses.
+type GRPCServer struct {
+	agentv1.UnimplementedAgentServiceServer
+	*AgentHandler
+}
+
+// Query answers a query in a thread.
+func (s *GRPCServer) Query(ctx context.Context, req *agentv1.QueryRequest) (*agentv1.QueryResponse, error) {
+	queryOptions, responseSchema, err := s.grpcQueryOptions(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []agents.AgentStep
+	var usage agents.Usage
+	queryOptions = append(queryOptions,
+		agents.WithStepCallback(func(step agents.AgentStep) {
+			steps = append(steps, step)
+		}),
+		agents.WithUsageCallback(func(u agents.Usage) {
+			usage = u
+		}),
+	)
+
+	response := &agentv1.QueryResponse{}
+	queryOptions = append(queryOptions, agents.WithMessageCallback(func(messageID string) {
+		response.MessageId = messageID
+	}))
+	if responseSchema != nil {
+		var structured *agents.StructuredResponse
+		structured, err = s.AgentManager.QueryStructured(
+			ctx, req.GetUserId(), req.GetOrgId(), req.GetThreadId(), req.GetQuery(), responseSchema, nil, queryOptions...,
+		)
+		if structured != nil {
+			response.Response = structured.Text
+			response.Output = &structpb.Struct{}
+			if err := response.Output.UnmarshalJSON([]byte(structured.Text)); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to convert structured output: %s", err)
+			}
+		}
+	} else {
+		response.Response, err = s.AgentManager.Query(
+			ctx, req.GetUserId(), req.GetOrgId(), req.GetThreadId(), req.GetQuery(), nil, queryOptions...,
+		)
+	}
+
+	for _, step := range steps {
+		response.Steps = append(response.Steps, agentStepToProto(step))
+		if step.Type == agents.StepTypeRoute {
+			response.Agent = step.Tool
+		}
+	}
+	response.Usage = usageToProto(usage)
+
+	var approvalErr *agents.ApprovalRequiredError
+	if errors.As(err, &approvalErr) {
+		response.PendingAction = pendingActionToProto(approvalErr.Action)
+		return response, nil
+	}
+	if err != nil {
+		return nil, grpcQueryError(err)
+	}
+	return response, nil
+}
+
+// StreamQuery answers a query in a thread, streaming tokens, sources, agent steps and usage,
+// then a done event. Errors end the stream with a status. The query runs as a generation, so
+// it counts towards the org's running generations, and is cancelled if the client goes away.
+func (s *GRPCServer) StreamQuery(req *agentv1.QueryRequest, stream agentv1.AgentService_StreamQueryServer) error {
+	queryOptions, responseSchema, err := s.grpcQueryOptions(req)
+	if err != nil {
+		return err
+	}
+
+	// Callbacks may run on other goroutines, while a stream allows one sender at a time
+	var mutex sync.Mutex
+	var sendErr error
+	send := func(event *agentv1.QueryEvent) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if sendErr == nil {
+			sendErr = stream.Send(event)
+		}
+	}
+
+	queryOptions = append(queryOptions,
+		agents.WithSourcesCallback(func(sources []agents.Source) {
+			event := &agentv1.Sources{}
+			for _, source := range sources {
+				event.Sources = append(event.Sources, sourceToProto(source))
+			}
+			send(&agentv1.QueryEvent{Event: &agentv1.QueryEvent_Sources{Sources: event}})
+		}),
+		agents.WithStepCallback(func(step agents.AgentStep) {
+			switch step.Type {
+			case agents.StepTypeAction, agents.StepTypeObservation:
+				send(&agentv1.QueryEvent{Event: &agentv1.QueryEvent_ToolCall{ToolCall: agentStepToProto(step)}})
+			default:
+				send(&agentv1.QueryEvent{Event: &agentv1.QueryEvent_Step{Step: agentStepToProto(step)}})
+			}
+		}),
+		agents.WithUsageCallback(func(usage agents.Usage) {
+			send(&agentv1.QueryEvent{Event: &agentv1.QueryEvent_Usage{Usage: usageToProto(usage)}})
+		}),
+	)
+	if responseSchema != nil {
+		queryOptions = append(queryOptions, agents.WithResponseSchema(responseSchema))
+	}
+
+	done := &agentv1.Done{ThreadId: req.GetThreadId(), FinishReason: "stop"}
+	queryOptions = append(queryOptions, agents.WithMessageCallback(func(messageID string) {
+		done.MessageId = messageID
+	}))
+
+	// Events go straight to the stream; gRPC clients cannot resume a generation
+	var queryErr error
+	generation, err := s.AgentManager.Generations.Start(req.GetUserId(), req.GetOrgId(), req.GetThreadId(),
+		func(ctx context.Context, _ *agents.Generation) error {
+			_, queryErr = s.AgentManager.Query(ctx, req.GetUserId(), req.GetOrgId(), req.GetThreadId(), req.GetQuery(),
+				func(chunk []byte) {
+					send(&agentv1.QueryEvent{Event: &agentv1.QueryEvent_Token{Token: string(chunk)}})
+				},
+				queryOptions...,
+			)
+			return queryErr
+		},
+	)
+	if errors.Is(err, agents.ErrTooManyGenerations) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	ignore := func(agents.GenerationEvent) {}
+	if err := generation.Follow(stream.Context(), 0, ignore); err != nil {
+		// Stop the query and wait for it, so nothing is sent after the handler returns
+		generation.Cancel()
+		generation.Follow(context.Background(), 0, ignore)
+		return status.FromContextError(err).Err()
+	}
+
+	err = queryErr
+	var approvalErr *agents.ApprovalRequiredError
+	if errors.As(err, &approvalErr) {
+		// The client resumes the run through the approve or reject endpoint
+		send(&agentv1.QueryEvent{Event: &agentv1.QueryEvent_ApprovalRequired{
+			ApprovalRequired: pendingActionToProto(approvalErr.Action),
+		}})
+		done.FinishReason = agents.StepTypeApproval
+	} else if err != nil {
+		return grpcQueryError(err)
+	}
+	send(&agentv1.QueryEvent{Event: &agentv1.QueryEvent_Done{Done: done}})
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return sendErr
+}
+
+// GetMemory returns the conversation history and metadata of a thread.
+func (s *GRPCServer) GetMemory(ctx context.Context, req *agentv1.GetMemoryRequest) (*agentv1.GetMemoryResponse, error) {
+	if req.GetThreadId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Missing thread_id")
+	}
+
+	memory, err := s.AgentManager.RetrieveMemory(ctx, req.GetThreadId())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := &agentv1.GetMemoryResponse{}
+	for _, message := range memory {
+		response.Messages = append(response.Messages, &agentv1.Message{Role: message["role"], Content: message["content"]})
+	}
+	response.Metadata, err = structFromJSON(s.AgentManager.ThreadMetadata(req.GetThreadId()))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return response, nil
+}
+
+// AddDocument adds a document to the knowledge base.
+func (s *GRPCServer) AddDocument(ctx context.Context, req *agentv1.AddDocumentRequest) (*agentv1.AddDocumentResponse, error) {
+	docID, chunks, err := s.AgentManager.AddKnowledgeDocument(ctx, req.GetPageContent(), req.GetMetadata())
+	if errors.Is(err, agents.ErrEmptyDocument) {
+		return nil, status.Error(codes.InvalidArgument, "'page_content' is empty")
+	}
+	if errors.Is(err, agents.ErrInvalidTimestamp) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Failed to add document")
+	}
+	return &agentv1.AddDocumentResponse{DocId: docID, Chunks: int32(chunks)}, nil
+}
+
+// ImportDataset imports a JSON dataset file for an organization and user.
+func (s *GRPCServer) ImportDataset(ctx context.Context, req *agentv1.ImportDatasetRequest) (*agentv1.ImportDatasetResponse, error) {
+	if req.GetFilePath() == "" || req.GetUserId() == "" || req.GetOrgId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "'file_path', 'user_id', and 'org_id' are required.")
+	}
+
+	// As over REST, the import completes if the client goes away
+	documents, err := s.AgentManager.ImportDataset(context.WithoutCancel(ctx), req.GetFilePath(), req.GetUserId(), req.GetOrgId())
+	var pathErr *os.PathError
+	switch {
+	case errors.Is(err, agents.ErrInvalidDataset):
+		return nil, status.Error(codes.InvalidArgument, "Invalid JSON file format.")
+	case errors.Is(err, agents.ErrInvalidTimestamp):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.As(err, &pathErr):
+		return nil, status.Error(codes.NotFound, "Failed to open the file.")
+	case err != nil:
+		return nil, status.Error(codes.Internal, "Failed to add documents to vector store.")
+	}
+	return &agentv1.ImportDatasetResponse{Documents: int32(documents)}, nil
+}
+
+// grpcQueryOptions validates a query request as the REST API does.
+func (s *GRPCServer) grpcQueryOptions(req *agentv1.QueryRequest) ([]agents.QueryOption, *agents.JSONSchema, error) {
+	if req.GetThreadId() == "" || req.GetUserId() == "" || req.GetOrgId() == "" {
+		return nil, nil, status.Error(codes.InvalidArgument, "'thread_id', 'user_id', and 'org_id' are required")
+	}
+
+	query, err := queryRequestFromProto(req)
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	queryOptions, responseSchema, err := query.options(s.AgentManager)
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// A thread paused for approval must be resolved first
+	if _, pending := s.AgentManager.PendingAction(req.GetThreadId()); pending {
+		return nil, nil, status.Error(codes.FailedPrecondition, agents.ErrPendingAction.Error())
+	}
+	return queryOptions, responseSchema, nil
+}
+
+// queryRequestFromProto converts a gRPC query to the REST request, whose filter and schema
+// are JSON.
+func queryRequestFromProto(req *agentv1.QueryRequest) (queryRequest, error) {
+	query := queryRequest{
+		Query:     req.GetQuery(),
+		MMRLambda: req.MmrLambda,
+		Knowledge: sourceSettingsFromProto(req.GetKnowledge()),
+		Memory:    sourceSettingsFromProto(req.GetMemory()),
+		Mode:      req.GetMode(),
+		Route:     req.Route,
+		Agent:     req.GetAgent(),
+	}
+	if req.GetFilter() != nil {
+		filterJSON, err := req.GetFilter().MarshalJSON()
+		if err != nil {
+			return queryRequest{}, fmt.Errorf("Invalid filter: %s", err)
+		}
+		if err := json.Unmarshal(filterJSON, &query.Filter); err != nil {
+			return queryRequest{}, fmt.Errorf("Invalid filter: %s", err)
+		}
+	}
+	if req.GetResponseSchema() != nil {
+		schemaJSON, err := req.GetResponseSchema().MarshalJSON()
+		if err != nil {
+			return queryRequest{}, fmt.Errorf("Invalid 'response_schema': %s", err)
+		}
+		query.ResponseSchema = schemaJSON
+	}
+	return query, nil
+}
+
+func sourceSettingsFromProto(settings *agentv1.SourceSettings) *sourceSettings {
+	if settings == nil {
+		return nil
+	}
+	converted := &sourceSettings{
+		Enabled:   settings.Enabled,
+		Scope:     settings.Scope,
+		HalfLife:  settings.HalfLife,
+		Expansion: settings.Expansion,
+	}
+	if settings.TopK != nil {
+		topK := int(settings.GetTopK())
+		converted.TopK = &topK
+	}
+	return converted
+}
+
+// grpcQueryError converts a query error to a status.
+func grpcQueryError(err error) error {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, agents.ErrPendingAction):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, agents.ErrThreadNotOwned):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, agents.ErrInvalidStructuredOutput):
+		return status.Error(codes.Aborted, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func agentStepToProto(step agents.AgentStep) *agentv1.AgentStep {
+	return &agentv1.AgentStep{Type: step.Type, Tool: step.Tool, Input: step.Input, Output: step.Output, Log: step.Log}
+}
+
+func usageToProto(usage agents.Usage) *agentv1.Usage {
+	return &agentv1.Usage{
+		PromptTokens:     int32(usage.PromptTokens),
+		CompletionTokens: int32(usage.CompletionTokens),
+		TotalTokens:      int32(usage.TotalTokens),
+	}
+}
+
+func pendingActionToProto(action *agents.PendingAction) *agentv1.PendingAction {
+	converted := &agentv1.PendingAction{
+		Id:        action.ID,
+		ThreadId:  action.ThreadID,
+		UserId:    action.UserID,
+		OrgId:     action.OrgID,
+		CreatedAt: timestamppb.New(action.CreatedAt),
+	}
+	for _, call := range action.ToolCalls {
+		converted.ToolCalls = append(converted.ToolCalls, &agentv1.PendingToolCall{
+			Id:               call.ID,
+			Tool:             call.Tool,
+			Arguments:        call.Arguments,
+			RequiresApproval: call.RequiresApproval,
+		})
+	}
+	return converted
+}
+
+func sourceToProto(source agents.Source) *agentv1.Source {
+	// Metadata that cannot be converted is left out rather than failing the stream
+	metadata, _ := structFromJSON(source.Metadata)
+	return &agentv1.Source{Content: source.Content, Metadata: metadata, Score: float64(source.Score)}
+}
+
+// structFromJSON converts a value to a Struct through its JSON form, which handles the
+// slices, times and numbers structpb does not.
+func structFromJSON(v any) (*structpb.Struct, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %T: %w", v, err)
+	}
+	converted := &structpb.Struct{}
+	if string(data) == "null" {
+		return converted, nil
+	}
+	if err := converted.UnmarshalJSON(data); err != nil {
+		return nil, fmt.Errorf("failed to convert %T: %w", v, err)
+	}
+	return converted, nil
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/blog/conversational-agent/internal/agents"
+	"github.com/blog/conversational-agent/internal/mcp"
+	"github.com/labstack/echo/v4"
+)
+
+// mcpServer describes this service to MCP clients.
+var mcpServer = &mcp.Server{
+	Info:         mcp.Implementation{Name: "conversational-agent", Version: "1.0.0"},
+	Instructions: agents.MCPServerInstructions,
+}
+
+// MCPHandler serves the agent as an MCP server over the streamable HTTP transport,
+// scoped to the org and user in the path.
+func (h *AgentHandler) MCPHandler(c echo.Context) error {
+	orgID := c.Param("org_id")
+	userID := c.Param("user_id")
+	if orgID == "" || userID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "'org_id' and 'user_id' are required"})
+	}
+
+	mcpServer.ServeStreamableHTTP(c.Response(), c.Request(), h.AgentManager.MCPServerHandler(orgID, userID))
+	return nil
+}
@@ -2,14 +2,13 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
 
 	"github.com/blog/conversational-agent/internal/agents"
 	"github.com/blog/conversational-agent/internal/logger"
 	"github.com/labstack/echo/v4"
-	"github.com/tmc/langchaingo/schema"
 )
 
 type ImportDatasetRequest struct {
@@ -39,7 +38,10 @@ func (h *AgentHandler) GetMemoryHandler(c echo.Context) error {
 		return c.JSON(http.StatusOK, map[string]string{"memory": "Memory is empty"})
 	}
 
-	return c.JSON(http.StatusOK, map[string]any{"memory": memory})
+	return c.JSON(http.StatusOK, map[string]any{
+		"memory":   memory,
+		"metadata": h.AgentManager.ThreadMetadata(threadID),
+	})
 }
 
 // AddDocumentHandler processes and stores documents with chunking and metadata.
@@ -60,20 +62,22 @@ func (h *AgentHandler) AddDocumentHandler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
-	// Create a document to add
-	doc := schema.Document{
-		PageContent: content,
-		Metadata:    agents.ConvertMetadata(req.Metadata),
+	// Split the document into chunks linked to a parent ID and store them
+	docID, chunks, err := h.AgentManager.AddKnowledgeDocument(c.Request().Context(), content, req.Metadata)
+	if errors.Is(err, agents.ErrEmptyDocument) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "'page_content' is empty"})
+	}
+	if errors.Is(err, agents.ErrInvalidTimestamp) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
-
-	// Add document to Weaviate vector store
-	ctx := c.Request().Context()
-	docID, err := h.AgentManager.VectorStore.AddDocuments(ctx, []schema.Document{doc})
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to add document"})
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{"doc_id": docID[0]})
+	return c.JSON(http.StatusOK, map[string]any{
+		"doc_id": docID,
+		"chunks": chunks,
+	})
 }
 
 // ImportMemoryHandler imports a dataset into the vector store.
@@ -91,34 +95,19 @@ func (h *AgentHandler) ImportMemoryHandler(c echo.Context) error {
 		})
 	}
 
-	// Open the JSON file
-	file, err := os.Open(req.FilePath)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to open the file."})
-	}
-	defer file.Close()
-
-	// Parse the JSON file
-	var dataset []map[string]interface{}
-	if err := json.NewDecoder(file).Decode(&dataset); err != nil {
+	// Import in the background context, so the import completes if the client disconnects
+	_, err := h.AgentManager.ImportDataset(context.Background(), req.FilePath, req.UserID, req.OrgID)
+	if errors.Is(err, agents.ErrInvalidDataset) {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON file format."})
 	}
-
-	// Convert dataset into schema.Document format
-	docs := []schema.Document{}
-	for _, item := range dataset {
-		if summary, ok := item["summary"].(string); ok {
-			doc := schema.Document{
-				PageContent: summary,
-				Metadata:    item,
-			}
-			docs = append(docs, doc)
-		}
+	if errors.Is(err, agents.ErrInvalidTimestamp) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
-
-	// Add documents to vector store with userID and orgID
-	ctx := context.Background()
-	if err := h.AgentManager.AddDocuments(ctx, docs, req.UserID, req.OrgID); err != nil {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to open the file."})
+	}
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to add documents to vector store."})
 	}
 
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/agents"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// Headers mapping an OpenAI-compatible request to the org, user and thread.
+const (
+	HeaderOrgID    = "X-Org-ID"
+	HeaderUserID   = "X-User-ID"
+	HeaderThreadID = "X-Thread-ID"
+)
+
+// defaultCompletionModel is the model name reported when the request names none.
+const defaultCompletionModel = "conversational-agent"
+
+// chatCompletionMessage is a message of an OpenAI chat completion request. Content is a
+// string or a list of content parts.
+type chatCompletionMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// text returns the message's text, joining text parts.
+func (m chatCompletionMessage) text() (string, error) {
+	if len(m.Content) == 0 || string(m.Content) == "null" {
+		return "", nil
+	}
+
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return text, nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return "", fmt.Errorf("content must be a string or a list of parts")
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type != "text" {
+			return "", fmt.Errorf("unsupported content part type: %s", part.Type)
+		}
+		texts = append(texts, part.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// chatCompletionRequest is the subset of the OpenAI chat completion request the agent supports.
+type chatCompletionRequest struct {
+	Model         string                  `json:"model"`
+	Messages      []chatCompletionMessage `json:"messages"`
+	Stream        bool                    `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	User           string `json:"user"`
+	ResponseFormat *struct {
+		Type       string `json:"type"`
+		JSONSchema *struct {
+			Name   string          `json:"name"`
+			Schema json.RawMessage `json:"schema"`
+		} `json:"json_schema"`
+	} `json:"response_format"`
+}
+
+// ChatCompletionsHandler serves the OpenAI chat completions API on top of the agent, so
+// OpenAI SDKs get retrieval and memory. The org, user and thread come from the X-Org-ID,
+// X-User-ID and X-Thread-ID headers, or from a "user" field of the form
+// "org_id:user_id[:thread_id]". Errors use the OpenAI error format.
+func (h *AgentHandler) ChatCompletionsHandler(c echo.Context) error {
+	var req chatCompletionRequest
+	if err := c.Bind(&req); err != nil {
+		return openAIError(c, http.StatusBadRequest, "Invalid request payload")
+	}
+	if len(req.Messages) == 0 {
+		return openAIError(c, http.StatusBadRequest, "'messages' must not be empty")
+	}
+
+	orgID, userID, threadID := completionIdentity(c, req.User)
+	if orgID == "" || userID == "" {
+		return openAIError(c, http.StatusBadRequest, fmt.Sprintf(
+			"the org and user are required: set the %s and %s headers, or 'user' to 'org_id:user_id[:thread_id]'",
+			HeaderOrgID, HeaderUserID,
+		))
+	}
+
+	// The last message is the input; system messages become instructions
+	last := req.Messages[len(req.Messages)-1]
+	if last.Role != "user" {
+		return openAIError(c, http.StatusBadRequest, "the last message must have the 'user' role")
+	}
+	input, err := last.text()
+	if err != nil {
+		return openAIError(c, http.StatusBadRequest, err.Error())
+	}
+
+	var instructions []string
+	var history []map[string]string
+	for _, message := range req.Messages[:len(req.Messages)-1] {
+		text, err := message.text()
+		if err != nil {
+			return openAIError(c, http.StatusBadRequest, err.Error())
+		}
+		switch message.Role {
+		case "system", "developer":
+			instructions = append(instructions, text)
+		case "user":
+			history = append(history, map[string]string{"role": "user", "content": text})
+		case "assistant":
+			history = append(history, map[string]string{"role": "ai", "content": text})
+		default:
+			return openAIError(c, http.StatusBadRequest, fmt.Sprintf("unsupported message role: %s", message.Role))
+		}
+	}
+
+	var queryOptions []agents.QueryOption
+	if len(instructions) > 0 {
+		queryOptions = append(queryOptions, agents.WithInstructions(strings.Join(instructions, "\n\n")))
+	}
+	if format := req.ResponseFormat; format != nil && format.Type != "text" {
+		schemaJSON := json.RawMessage(`{"type": "object"}`)
+		if format.Type == "json_schema" && format.JSONSchema != nil && len(format.JSONSchema.Schema) > 0 {
+			schemaJSON = format.JSONSchema.Schema
+		}
+		schema, err := agents.ParseJSONSchema(schemaJSON)
+		if err != nil {
+			return openAIError(c, http.StatusBadRequest, err.Error())
+		}
+		if !schema.DescribesObject() {
+			return openAIError(c, http.StatusBadRequest, "the response schema must have the type \"object\"")
+		}
+		queryOptions = append(queryOptions, agents.WithResponseSchema(schema))
+	}
+
+	var usage agents.Usage
+	queryOptions = append(queryOptions, agents.WithUsageCallback(func(u agents.Usage) {
+		usage = u
+	}))
+
+	// Without a thread, the conversation sent with the request is the history of a temporary
+	// thread, released once answered since the client sends the whole conversation each time
+	if threadID == "" {
+		threadID = uuid.NewString()
+		defer h.AgentManager.ReleaseThread(threadID)
+		if err := h.AgentManager.SeedThreadHistory(c.Request().Context(), threadID, history); err != nil {
+			return openAIError(c, http.StatusInternalServerError, err.Error())
+		}
+	} else {
+		c.Response().Header().Set(HeaderThreadID, threadID)
+	}
+	if err := h.AgentManager.CheckThreadOwner(threadID, userID, orgID); err != nil {
+		return openAIError(c, http.StatusForbidden, err.Error())
+	}
+	if _, pending := h.AgentManager.PendingAction(threadID); pending {
+		return openAIError(c, http.StatusConflict, agents.ErrPendingAction.Error())
+	}
+
+	id := "chatcmpl-" + uuid.NewString()
+	created := time.Now().Unix()
+	model := req.Model
+	if model == "" {
+		model = defaultCompletionModel
+	}
+
+	if req.Stream {
+		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+		c.Response().Header().Set("Cache-Control", "no-cache")
+		c.Response().Header().Set("Connection", "keep-alive")
+		c.Response().WriteHeader(http.StatusOK)
+
+		writeChunk := func(chunk map[string]any) {
+			payload, _ := json.Marshal(chunk)
+			c.Response().Write([]byte(fmt.Sprintf("data: %s\n\n", payload)))
+			c.Response().Flush()
+		}
+		completionChunk := func(delta map[string]any, finishReason any) map[string]any {
+			return map[string]any{
+				"id":      id,
+				"object":  "chat.completion.chunk",
+				"created": created,
+				"model":   model,
+				"choices": []map[string]any{{"index": 0, "delta": delta, "finish_reason": finishReason}},
+			}
+		}
+
+		writeChunk(completionChunk(map[string]any{"role": "assistant", "content": ""}, nil))
+		_, err := h.AgentManager.Query(c.Request().Context(), userID, orgID, threadID, input,
+			func(chunk []byte) {
+				writeChunk(completionChunk(map[string]any{"content": string(chunk)}, nil))
+			},
+			queryOptions...,
+		)
+		if err != nil {
+			// Headers are sent, so the error is reported in the stream
+			writeChunk(map[string]any{"error": openAIErrorBody(http.StatusInternalServerError, err.Error())})
+			return nil
+		}
+
+		writeChunk(completionChunk(map[string]any{}, "stop"))
+		if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+			writeChunk(map[string]any{
+				"id":      id,
+				"object":  "chat.completion.chunk",
+				"created": created,
+				"model":   model,
+				"choices": []map[string]any{},
+				"usage":   usage,
+			})
+		}
+		c.Response().Write([]byte("data: [DONE]\n\n"))
+		c.Response().Flush()
+		return nil
+	}
+
+	response, err := h.AgentManager.Query(c.Request().Context(), userID, orgID, threadID, input, nil, queryOptions...)
+	var approvalErr *agents.ApprovalRequiredError
+	if errors.As(err, &approvalErr) {
+		return openAIError(c, http.StatusConflict, err.Error())
+	}
+	if errors.Is(err, agents.ErrInvalidStructuredOutput) {
+		return openAIError(c, http.StatusUnprocessableEntity, err.Error())
+	}
+	if err != nil {
+		return openAIError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": created,
+		"model":   model,
+		"choices": []map[string]any{{
+			"index":         0,
+			"message":       map[string]string{"role": "assistant", "content": response},
+			"finish_reason": "stop",
+		}},
+		"usage": usage,
+	})
+}
+
+// completionIdentity reads the org, user and thread from the headers, falling back to the
+// request's "user" field.
+func completionIdentity(c echo.Context, user string) (orgID, userID, threadID string) {
+	orgID = c.Request().Header.Get(HeaderOrgID)
+	userID = c.Request().Header.Get(HeaderUserID)
+	threadID = c.Request().Header.Get(HeaderThreadID)
+
+	parts := strings.SplitN(user, ":", 3)
+	if orgID == "" && len(parts) >= 2 {
+		orgID = parts[0]
+	}
+	if userID == "" && len(parts) >= 2 {
+		userID = parts[1]
+	}
+	if threadID == "" && len(parts) == 3 {
+		threadID = parts[2]
+	}
+	return orgID, userID, threadID
+}
+
+// openAIError responds with an error in the OpenAI format.
+func openAIError(c echo.Context, status int, message string) error {
+	return c.JSON(status, map[string]any{"error": openAIErrorBody(status, message)})
+}
+
+func openAIErrorBody(status int, message string) map[string]any {
+	errorType := "invalid_request_error"
+	if status >= http.StatusInternalServerError {
+		errorType = "server_error"
+	}
+	return map[string]any{"message": message, "type": errorType, "code": nil}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/blog/conversational-agent/internal/agents"
+	"github.com/labstack/echo/v4"
+)
+
+// GetPersonaHandler returns the org's persona, or the default one.
+func (h *AgentHandler) GetPersonaHandler(c echo.Context) error {
+	orgID := c.Param("org_id")
+	if orgID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing org_id"})
+	}
+
+	persona, ok := h.AgentManager.Personas.Get(orgID)
+	if !ok {
+		persona = agents.DefaultPersona()
+	}
+	return c.JSON(http.StatusOK, map[string]any{"org_id": orgID, "persona": persona, "default": !ok})
+}
+
+// SetPersonaHandler sets the org's persona name, system prompt, tone and answer format.
+func (h *AgentHandler) SetPersonaHandler(c echo.Context) error {
+	orgID := c.Param("org_id")
+	if orgID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing org_id"})
+	}
+
+	var persona agents.Persona
+	if err := c.Bind(&persona); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+	}
+	if err := persona.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	persona, err := h.AgentManager.Personas.Set(orgID, persona)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"org_id": orgID, "persona": persona})
+}
+
+// DeletePersonaHandler removes the org's persona so the default applies again.
+func (h *AgentHandler) DeletePersonaHandler(c echo.Context) error {
+	orgID := c.Param("org_id")
+	if orgID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing org_id"})
+	}
+
+	if err := h.AgentManager.Personas.Delete(orgID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Persona reset to the default"})
+}
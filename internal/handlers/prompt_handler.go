@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/blog/conversational-agent/internal/agents"
+	"github.com/labstack/echo/v4"
+)
+
+// ListPromptsHandler lists the prompts and their versions.
+func (h *AgentHandler) ListPromptsHandler(c echo.Context) error {
+	prompts := []map[string]any{}
+	for _, name := range h.AgentManager.Prompts.Names() {
+		versions, err := h.AgentManager.Prompts.Versions(name)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		prompts = append(prompts, map[string]any{"name": name, "versions": versions})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{"prompts": prompts})
+}
+
+// GetPromptHandler returns the versions of a prompt and the versions each org uses.
+func (h *AgentHandler) GetPromptHandler(c echo.Context) error {
+	name := c.Param("name")
+
+	versions, err := h.AgentManager.Prompts.Versions(name)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"name":        name,
+		"versions":    versions,
+		"assignments": h.AgentManager.Prompts.Assignments(name),
+	})
+}
+
+// AddPromptVersionHandler stores a new version of a prompt.
+func (h *AgentHandler) AddPromptVersionHandler(c echo.Context) error {
+	type AddVersionRequest struct {
+		Template    string `json:"template"`
+		Description string `json:"description"`
+	}
+
+	name := c.Param("name")
+	if _, err := h.AgentManager.Prompts.Versions(name); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	var req AddVersionRequest
+	if err := c.Bind(&req); err != nil || req.Template == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload. 'template' is required."})
+	}
+
+	version, err := h.AgentManager.Prompts.AddVersion(name, req.Template, req.Description)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, version)
+}
+
+// AssignPromptHandler sets the version of a prompt an org uses, with an optional A/B variant.
+func (h *AgentHandler) AssignPromptHandler(c echo.Context) error {
+	name := c.Param("name")
+	orgID := c.Param("org_id")
+	if _, err := h.AgentManager.Prompts.Versions(name); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	var assignment agents.PromptAssignment
+	if err := c.Bind(&assignment); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+	}
+
+	if err := h.AgentManager.Prompts.Assign(orgID, name, assignment); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{"name": name, "org_id": orgID, "assignment": assignment})
+}
+
+// UnassignPromptHandler makes an org use the built-in version of a prompt again.
+func (h *AgentHandler) UnassignPromptHandler(c echo.Context) error {
+	name := c.Param("name")
+	orgID := c.Param("org_id")
+	if _, err := h.AgentManager.Prompts.Versions(name); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	if err := h.AgentManager.Prompts.Unassign(orgID, name); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Prompt reset to the built-in version"})
+}
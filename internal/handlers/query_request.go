@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/agents"
+)
+
+// sourceSettings override the retrieval defaults of the knowledge or memory source.
+type sourceSettings struct {
+	Enabled   *bool   `json:"enabled"`
+	TopK      *int    `json:"top_k"`
+	Scope     *string `json:"scope"`
+	HalfLife  *string `json:"half_life"`
+	Expansion *string `json:"expansion"`
+}
+
+// queryRequest is a query with its optional settings. The REST, WebSocket and gRPC APIs all
+// convert their requests to it, so queries are validated the same way everywhere.
+type queryRequest struct {
+	Query     string                 `json:"query"`
+	Stream    bool                   `json:"stream"`
+	Filter    *agents.MetadataFilter `json:"filter"`
+	MMRLambda *float64               `json:"mmr_lambda"`
+	Knowledge *sourceSettings        `json:"knowledge"`
+	Memory    *sourceSettings        `json:"memory"`
+	Mode      string                 `json:"mode"`
+	Route     *bool                  `json:"route"`
+	Agent     string                 `json:"agent"`
+
+	ResponseSchema json.RawMessage `json:"response_schema"`
+}
+
+// options validates the settings and converts them to query options. The response schema
+// is returned apart, since structured answers are read through QueryStructured.
+func (r queryRequest) options(am *agents.AgentManager) ([]agents.QueryOption, *agents.JSONSchema, error) {
+	var queryOptions []agents.QueryOption
+	if r.Filter != nil {
+		if err := r.Filter.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("Invalid filter: %s", err)
+		}
+		queryOptions = append(queryOptions, agents.WithMetadataFilter(r.Filter))
+	}
+	if r.MMRLambda != nil {
+		if *r.MMRLambda < 0 || *r.MMRLambda > 1 {
+			return nil, nil, fmt.Errorf("'mmr_lambda' must be between 0 and 1")
+		}
+		queryOptions = append(queryOptions, agents.WithMMR(*r.MMRLambda))
+	}
+	if r.Knowledge != nil {
+		if r.Knowledge.Enabled != nil {
+			queryOptions = append(queryOptions, agents.WithKnowledgeEnabled(*r.Knowledge.Enabled))
+		}
+		if r.Knowledge.TopK != nil {
+			if *r.Knowledge.TopK < 0 || *r.Knowledge.TopK > maxTopK {
+				return nil, nil, fmt.Errorf("'knowledge.top_k' must be between 0 and %d", maxTopK)
+			}
+			queryOptions = append(queryOptions, agents.WithKnowledgeTopK(*r.Knowledge.TopK))
+		}
+		if r.Knowledge.HalfLife != nil {
+			halfLife, err := time.ParseDuration(*r.Knowledge.HalfLife)
+			if err != nil || halfLife < 0 {
+				return nil, nil, fmt.Errorf("'knowledge.half_life' must be a duration such as '720h'")
+			}
+			queryOptions = append(queryOptions, agents.WithKnowledgeHalfLife(halfLife))
+		}
+		if r.Knowledge.Expansion != nil {
+			switch *r.Knowledge.Expansion {
+			case agents.ExpansionNone, agents.ExpansionParent, agents.ExpansionNeighbors:
+				queryOptions = append(queryOptions, agents.WithExpansion(*r.Knowledge.Expansion))
+			default:
+				return nil, nil, fmt.Errorf("'knowledge.expansion' must be 'none', 'parent' or 'neighbors'")
+			}
+		}
+	}
+	if r.Memory != nil {
+		if r.Memory.Enabled != nil {
+			queryOptions = append(queryOptions, agents.WithMemoryEnabled(*r.Memory.Enabled))
+		}
+		if r.Memory.TopK != nil {
+			if *r.Memory.TopK < 0 || *r.Memory.TopK > maxTopK {
+				return nil, nil, fmt.Errorf("'memory.top_k' must be between 0 and %d", maxTopK)
+			}
+			queryOptions = append(queryOptions, agents.WithMemoryTopK(*r.Memory.TopK))
+		}
+		if r.Memory.Scope != nil {
+			if *r.Memory.Scope != agents.MemoryScopeUser && *r.Memory.Scope != agents.MemoryScopeThread {
+				return nil, nil, fmt.Errorf("'memory.scope' must be 'user' or 'thread'")
+			}
+			queryOptions = append(queryOptions, agents.WithMemoryScope(*r.Memory.Scope))
+		}
+		if r.Memory.HalfLife != nil {
+			halfLife, err := time.ParseDuration(*r.Memory.HalfLife)
+			if err != nil || halfLife < 0 {
+				return nil, nil, fmt.Errorf("'memory.half_life' must be a duration such as '72h'")
+			}
+			queryOptions = append(queryOptions, agents.WithMemoryHalfLife(halfLife))
+		}
+	}
+
+	switch r.Mode {
+	case "", agents.ModeChain:
+	case agents.ModeAgent, agents.ModeTools:
+		queryOptions = append(queryOptions, agents.WithMode(r.Mode))
+	default:
+		return nil, nil, fmt.Errorf("'mode' must be 'chain', 'agent' or 'tools'")
+	}
+
+	if r.Route != nil {
+		queryOptions = append(queryOptions, agents.WithRouting(*r.Route))
+	}
+	if r.Agent != "" {
+		if am.Router == nil {
+			return nil, nil, fmt.Errorf("Routing is not configured")
+		}
+		if _, ok := am.Router.Agent(r.Agent); !ok {
+			return nil, nil, fmt.Errorf("Unknown agent: %s", r.Agent)
+		}
+		queryOptions = append(queryOptions, agents.WithAgent(r.Agent))
+	}
+
+	var responseSchema *agents.JSONSchema
+	if len(r.ResponseSchema) > 0 {
+		schema, err := agents.ParseJSONSchema(r.ResponseSchema)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid 'response_schema': %s", err)
+		}
+		if !schema.DescribesObject() {
+			return nil, nil, fmt.Errorf("'response_schema' must have the type \"object\"")
+		}
+		responseSchema = schema
+	}
+	return queryOptions, responseSchema, nil
+}
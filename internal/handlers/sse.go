@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Server-sent event names of the query stream.
+const (
+	SSEEventToken    = "token"
+	SSEEventSources  = "sources"
+	SSEEventToolCall = "tool_call"
+	SSEEventStep     = "step"
+	SSEEventApproval = "approval_required"
+	SSEEventUsage    = "usage"
+	SSEEventError    = "error"
+	SSEEventDone     = "done"
+)
+
+// sseHeartbeatInterval is how often an idle stream sends a comment to keep proxies from closing it.
+const sseHeartbeatInterval = 15 * time.Second
+
+// sseWriter writes typed server-sent events with JSON payloads. It is safe for concurrent
+// use, since events and heartbeats are written from different goroutines.
+type sseWriter struct {
+	response *echo.Response
+	mutex    sync.Mutex
+	closed   bool
+	stop     chan struct{}
+}
+
+// newSSEWriter sends the stream headers and starts the heartbeat.
+func newSSEWriter(c echo.Context) *sseWriter {
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.Header().Set("Connection", "keep-alive")
+	response.WriteHeader(http.StatusOK)
+	response.Flush()
+
+	w := &sseWriter{response: response, stop: make(chan struct{})}
+	go w.heartbeat()
+	return w
+}
+
+// Send writes one event. The ID is what clients send back as Last-Event-ID when they
+// reconnect. Payloads are JSON, so newlines in the data never break the framing.
+func (w *sseWriter) Send(id int, event string, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		payload, _ = json.Marshal(map[string]string{"message": fmt.Sprintf("failed to encode %s event: %s", event, err)})
+		event = SSEEventError
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return
+	}
+	fmt.Fprintf(w.response, "id: %d\nevent: %s\ndata: %s\n\n", id, event, payload)
+	w.response.Flush()
+}
+
+// Close stops the heartbeat. Events sent afterwards are dropped.
+func (w *sseWriter) Close() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		close(w.stop)
+	}
+}
+
+func (w *sseWriter) heartbeat() {
+	ticker := time.NewTicker(sseHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mutex.Lock()
+			if !w.closed {
+				w.response.Write([]byte(": heartbeat\n\n"))
+				w.response.Flush()
+			}
+			w.mutex.Unlock()
+		}
+	}
+}
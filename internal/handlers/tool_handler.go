@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ListToolsHandler lists the tools available to the org and whether each is enabled for the org.
+func (h *AgentHandler) ListToolsHandler(c echo.Context) error {
+	orgID := c.Param("org_id")
+	if orgID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing org_id"})
+	}
+
+	enabled := make(map[string]bool)
+	for _, tool := range h.AgentManager.Tools.ForOrg(orgID) {
+		enabled[tool.Name()] = true
+	}
+
+	tools := []map[string]any{}
+	for _, tool := range h.AgentManager.Tools.List(orgID) {
+		tools = append(tools, map[string]any{
+			"name":              tool.Name(),
+			"description":       tool.Description(),
+			"enabled":           enabled[tool.Name()],
+			"requires_approval": h.AgentManager.Tools.RequiresApproval(orgID, tool.Name()),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{"tools": tools})
+}
+
+// SetOrgToolsHandler sets which tools are enabled for the org, and optionally which need approval.
+func (h *AgentHandler) SetOrgToolsHandler(c echo.Context) error {
+	type SetToolsRequest struct {
+		Tools           []string `json:"tools"`
+		RequireApproval []string `json:"require_approval"`
+	}
+
+	orgID := c.Param("org_id")
+	if orgID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing org_id"})
+	}
+
+	var req SetToolsRequest
+	if err := c.Bind(&req); err != nil || req.Tools == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload. 'tools' is required."})
+	}
+
+	if err := h.AgentManager.Tools.SetOrgTools(orgID, req.Tools); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.RequireApproval != nil {
+		if err := h.AgentManager.Tools.SetOrgApproval(orgID, req.RequireApproval); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"org_id":           orgID,
+		"tools":            req.Tools,
+		"require_approval": req.RequireApproval,
+	})
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/agents"
+	"github.com/blog/conversational-agent/internal/logger"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// Message types a WebSocket client sends.
+const (
+	WSMessageQuery  = "message"
+	WSMessageCancel = "cancel"
+	WSMessagePing   = "ping"
+)
+
+// Message types the server sends besides the stream events of the query stream.
+const (
+	WSMessagePong  = "pong"
+	WSMessageEvent = "event"
+)
+
+// WebSocket limits and keepalive timings.
+const (
+	wsMaxMessageSize = 1 << 20
+	wsWriteTimeout   = 10 * time.Second
+	wsPongTimeout    = 60 * time.Second
+	wsPingInterval   = wsPongTimeout * 9 / 10
+)
+
+// wsUpgrader keeps gorilla's default origin check, which only accepts same-origin browsers.
+var wsUpgrader = websocket.Upgrader{}
+
+// errGenerationInProgress is sent when a message arrives while the connection's previous
+// answer is still generating.
+var errGenerationInProgress = errors.New("A generation is already in progress")
+
+// wsClientMessage is a message from the client. ID is echoed in the server messages it
+// causes; a query message has the settings of a query request.
+type wsClientMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	queryRequest
+}
+
+// wsServerMessage is a message to the client. The events of a generation carry its ID and
+// their event ID, with which the stream can be resumed over SSE.
+type wsServerMessage struct {
+	Type         string `json:"type"`
+	ID           string `json:"id,omitempty"`
+	GenerationID string `json:"generation_id,omitempty"`
+	EventID      int    `json:"event_id,omitempty"`
+	Data         any    `json:"data,omitempty"`
+}
+
+// wsConnection serializes writes to the socket and tracks the in-flight generation.
+type wsConnection struct {
+	conn       *websocket.Conn
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	generation *agents.Generation
+	followers  sync.WaitGroup
+}
+
+// send writes one message. Write errors surface as read errors, which end the connection.
+func (w *wsConnection) send(message wsServerMessage) {
+	w.writeMutex.Lock()
+	defer w.writeMutex.Unlock()
+
+	w.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := w.conn.WriteJSON(message); err != nil {
+		log := logger.GetLogger()
+		log.Debug().Msgf("Failed to write WebSocket message: %s", err)
+	}
+}
+
+// begin starts a generation, unless one is already in flight.
+func (w *wsConnection) begin(start func() (*agents.Generation, error)) (*agents.Generation, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.generation != nil {
+		return nil, errGenerationInProgress
+	}
+	generation, err := start()
+	if err != nil {
+		return nil, err
+	}
+	w.generation = generation
+	w.followers.Add(1)
+	return w.generation, nil
+}
+
+// release clears the in-flight generation, if it is still the given one.
+func (w *wsConnection) release(generation *agents.Generation) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.generation == generation {
+		w.generation = nil
+	}
+}
+
+// cancelGeneration cancels the in-flight generation, reporting whether there was one.
+func (w *wsConnection) cancelGeneration() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.generation != nil && w.generation.Cancel()
+}
+
+// keepalive pings the client until the context is done.
+func (w *wsConnection) keepalive(ctx context.Context) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// WebSocketHandler holds a bidirectional conversation on a thread. The client sends messages,
+// which are answered with the events of the query stream, and can cancel the answer being
+// generated. Events published for the thread or its org are pushed as they happen.
+func (h *AgentHandler) WebSocketHandler(c echo.Context) error {
+	log := logger.GetLogger()
+
+	userID := c.Param("user_id")
+	orgID := c.Param("org_id")
+	threadID := c.Param("thread_id")
+	if threadID == "" || userID == "" || orgID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "'thread_id', 'user_id', and 'org_id' are required query parameters",
+		})
+	}
+
+	if err := h.AgentManager.CheckThreadOwner(threadID, userID, orgID); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+
+	conn, err := wsUpgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// The upgrader has already responded with the error
+		log.Warn().Msgf("Failed to upgrade WebSocket connection: %s", err)
+		return nil
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+
+	ws := &wsConnection{conn: conn}
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	go ws.keepalive(ctx)
+
+	events, unsubscribe := h.AgentManager.Events.Subscribe(orgID, threadID)
+	defer unsubscribe()
+	go func() {
+		for event := range events {
+			ws.send(wsServerMessage{Type: WSMessageEvent, Data: event})
+		}
+	}()
+
+	for {
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Warn().Msgf("WebSocket connection for thread %s closed: %s", threadID, err)
+			}
+			break
+		}
+		conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+
+		var message wsClientMessage
+		if err := json.Unmarshal(payload, &message); err != nil {
+			ws.send(wsError("", "Invalid message payload"))
+			continue
+		}
+
+		switch message.Type {
+		case WSMessageQuery:
+			h.startWSGeneration(ctx, ws, userID, orgID, threadID, message)
+		case WSMessageCancel:
+			if !ws.cancelGeneration() {
+				ws.send(wsError(message.ID, "No generation is in progress"))
+			}
+		case WSMessagePing:
+			ws.send(wsServerMessage{Type: WSMessagePong, ID: message.ID})
+		default:
+			ws.send(wsError(message.ID, fmt.Sprintf("Unknown message type: %s", message.Type)))
+		}
+	}
+
+	// Stop forwarding before the connection closes. The generation itself runs on, so the
+	// client can resume it over SSE or cancel it.
+	cancel()
+	ws.followers.Wait()
+	return nil
+}
+
+// startWSGeneration answers a client message with a generation, forwarding its events while
+// the connection keeps reading, so a cancel message can stop it.
+func (h *AgentHandler) startWSGeneration(
+	ctx context.Context,
+	ws *wsConnection,
+	userID, orgID, threadID string,
+	message wsClientMessage,
+) {
+	if strings.TrimSpace(message.Query) == "" {
+		ws.send(wsError(message.ID, "'query' is required"))
+		return
+	}
+
+	queryOptions, responseSchema, err := message.options(h.AgentManager)
+	if err != nil {
+		ws.send(wsError(message.ID, err.Error()))
+		return
+	}
+	if responseSchema != nil {
+		queryOptions = append(queryOptions, agents.WithResponseSchema(responseSchema))
+	}
+	if _, pending := h.AgentManager.PendingAction(threadID); pending {
+		ws.send(wsError(message.ID, agents.ErrPendingAction.Error()))
+		return
+	}
+
+	generation, err := ws.begin(func() (*agents.Generation, error) {
+		return h.startGeneration(userID, orgID, threadID, message.Query, queryOptions)
+	})
+	if err != nil {
+		ws.send(wsError(message.ID, err.Error()))
+		return
+	}
+
+	go func() {
+		defer ws.followers.Done()
+		defer ws.release(generation)
+
+		generation.Follow(ctx, 0, func(event agents.GenerationEvent) {
+			// The client may send its next message as soon as it sees the final event
+			if event.Type == SSEEventDone || event.Type == SSEEventError {
+				ws.release(generation)
+			}
+			ws.send(wsServerMessage{
+				Type:         event.Type,
+				ID:           message.ID,
+				GenerationID: generation.ID,
+				EventID:      event.ID,
+				Data:         event.Data,
+			})
+		})
+	}()
+}
+
+func wsError(id, message string) wsServerMessage {
+	return wsServerMessage{Type: SSEEventError, ID: id, Data: map[string]string{"message": message}}
+}
+
+// PublishEventHandler pushes an event, such as a human handoff, to the WebSocket clients of
+// a thread, or of the whole org when no thread is given.
+func (h *AgentHandler) PublishEventHandler(c echo.Context) error {
+	orgID := c.Param("org_id")
+	if orgID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "'org_id' is required"})
+	}
+
+	var req struct {
+		Type     string `json:"type"`
+		ThreadID string `json:"thread_id"`
+		Data     any    `json:"data"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+	}
+	if strings.TrimSpace(req.Type) == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "'type' is required"})
+	}
+	if req.ThreadID != "" {
+		if _, owner, ok := h.AgentManager.ThreadOwner(req.ThreadID); ok && owner != orgID {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Thread not found"})
+		}
+	}
+
+	event := agents.Event{
+		Type:      req.Type,
+		OrgID:     orgID,
+		ThreadID:  req.ThreadID,
+		Data:      req.Data,
+		CreatedAt: time.Now().UTC(),
+	}
+	h.AgentManager.Events.Publish(event)
+	return c.JSON(http.StatusAccepted, event)
+}
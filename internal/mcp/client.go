@@ -0,0 +1,180 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+)
+
+// maxListPages bounds pagination so a misbehaving server cannot loop forever.
+const maxListPages = 20
+
+// Client is an initialized session with an MCP server.
+type Client struct {
+	transport Transport
+	nextID    atomic.Int64
+
+	ServerInfo   Implementation
+	Capabilities ServerCapabilities
+	Instructions string
+}
+
+// Connect initializes a session over the transport.
+func Connect(ctx context.Context, transport Transport, clientInfo Implementation) (*Client, error) {
+	c := &Client{transport: transport}
+
+	var result InitializeResult
+	err := c.call(ctx, "initialize", InitializeParams{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    map[string]any{},
+		ClientInfo:      clientInfo,
+	}, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize: %w", err)
+	}
+	c.ServerInfo = result.ServerInfo
+	c.Capabilities = result.Capabilities
+	c.Instructions = result.Instructions
+
+	notification, err := NewNotification("notifications/initialized", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := transport.Notify(ctx, notification); err != nil {
+		return nil, fmt.Errorf("failed to confirm initialization: %w", err)
+	}
+	return c, nil
+}
+
+// call sends a request and decodes its result.
+func (c *Client) call(ctx context.Context, method string, params, result any) error {
+	request, err := NewRequest(c.nextID.Add(1), method, params)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.transport.Call(ctx, request)
+	if err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(response.Result, result); err != nil {
+		return fmt.Errorf("failed to decode %s result: %w", method, err)
+	}
+	return nil
+}
+
+// ListTools returns every tool the server offers.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	if c.Capabilities.Tools == nil {
+		return nil, nil
+	}
+
+	var tools []Tool
+	cursor := ""
+	for page := 0; page < maxListPages; page++ {
+		var result ListToolsResult
+		if err := c.call(ctx, "tools/list", cursorParams{Cursor: cursor}, &result); err != nil {
+			return nil, err
+		}
+		tools = append(tools, result.Tools...)
+		if cursor = result.NextCursor; cursor == "" {
+			break
+		}
+	}
+	return tools, nil
+}
+
+// ListResources returns every resource the server offers.
+func (c *Client) ListResources(ctx context.Context) ([]Resource, error) {
+	if c.Capabilities.Resources == nil {
+		return nil, nil
+	}
+
+	var resources []Resource
+	cursor := ""
+	for page := 0; page < maxListPages; page++ {
+		var result ListResourcesResult
+		if err := c.call(ctx, "resources/list", cursorParams{Cursor: cursor}, &result); err != nil {
+			return nil, err
+		}
+		resources = append(resources, result.Resources...)
+		if cursor = result.NextCursor; cursor == "" {
+			break
+		}
+	}
+	return resources, nil
+}
+
+// ListPrompts returns every prompt the server offers.
+func (c *Client) ListPrompts(ctx context.Context) ([]Prompt, error) {
+	if c.Capabilities.Prompts == nil {
+		return nil, nil
+	}
+
+	var prompts []Prompt
+	cursor := ""
+	for page := 0; page < maxListPages; page++ {
+		var result ListPromptsResult
+		if err := c.call(ctx, "prompts/list", cursorParams{Cursor: cursor}, &result); err != nil {
+			return nil, err
+		}
+		prompts = append(prompts, result.Prompts...)
+		if cursor = result.NextCursor; cursor == "" {
+			break
+		}
+	}
+	return prompts, nil
+}
+
+// CallTool invokes a tool with JSON arguments.
+func (c *Client) CallTool(ctx context.Context, name string, arguments json.RawMessage) (*CallToolResult, error) {
+	var result CallToolResult
+	if err := c.call(ctx, "tools/call", CallToolParams{Name: name, Arguments: arguments}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ReadResource returns the contents of a resource.
+func (c *Client) ReadResource(ctx context.Context, uri string) (*ReadResourceResult, error) {
+	var result ReadResourceResult
+	if err := c.call(ctx, "resources/read", ReadResourceParams{URI: uri}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetPrompt renders a prompt with the given arguments.
+func (c *Client) GetPrompt(ctx context.Context, name string, arguments map[string]string) (*GetPromptResult, error) {
+	var result GetPromptResult
+	if err := c.call(ctx, "prompts/get", GetPromptParams{Name: name, Arguments: arguments}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Exited reports whether the server behind a transport that tracks its process has exited.
+func (c *Client) Exited() bool {
+	process, ok := c.transport.(interface{ Done() <-chan struct{} })
+	if !ok {
+		return false
+	}
+	select {
+	case <-process.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// Close ends the session.
+func (c *Client) Close() error {
+	return c.transport.Close()
+}
@@ -0,0 +1,169 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// HTTP headers of the streamable HTTP transport.
+const (
+	HeaderSessionID       = "Mcp-Session-Id"
+	HeaderProtocolVersion = "Mcp-Protocol-Version"
+)
+
+// maxHTTPResponseBytes bounds a JSON response body read from a server.
+const maxHTTPResponseBytes = 4 * 1024 * 1024
+
+// HTTPTransport talks to a server over the streamable HTTP transport: every message is
+// POSTed to a single endpoint, and the server answers with JSON or an SSE stream.
+type HTTPTransport struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+
+	mutex     sync.Mutex
+	sessionID string
+}
+
+var _ Transport = (*HTTPTransport)(nil)
+
+// NewHTTPTransport creates a transport for the endpoint. headers are sent with every request.
+func NewHTTPTransport(endpoint string, headers map[string]string, client *http.Client) *HTTPTransport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPTransport{endpoint: endpoint, headers: headers, client: client}
+}
+
+func (t *HTTPTransport) newRequest(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	req.Header.Set(HeaderProtocolVersion, ProtocolVersion)
+
+	t.mutex.Lock()
+	if t.sessionID != "" {
+		req.Header.Set(HeaderSessionID, t.sessionID)
+	}
+	t.mutex.Unlock()
+	return req, nil
+}
+
+// post sends a message and returns the response once its status is checked.
+func (t *HTTPTransport) post(ctx context.Context, msg *Message) (*http.Response, error) {
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+	req, err := t.newRequest(ctx, http.MethodPost, encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("server returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	// The server assigns the session on initialize
+	if sessionID := resp.Header.Get(HeaderSessionID); sessionID != "" {
+		t.mutex.Lock()
+		t.sessionID = sessionID
+		t.mutex.Unlock()
+	}
+	return resp, nil
+}
+
+func (t *HTTPTransport) Call(ctx context.Context, request *Message) (*Message, error) {
+	resp, err := t.post(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/event-stream" {
+		return readSSEResponse(resp.Body, request.ID)
+	}
+
+	var response Message
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxHTTPResponseBytes)).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &response, nil
+}
+
+// readSSEResponse reads events until the response to the request with the given ID arrives.
+// Notifications and requests from the server on the same stream are skipped.
+func readSSEResponse(body io.Reader, id json.RawMessage) (*Message, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxHTTPResponseBytes)
+
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		case line == "" && data.Len() > 0:
+			var msg Message
+			if err := json.Unmarshal([]byte(data.String()), &msg); err == nil && msg.IsResponse() && string(msg.ID) == string(id) {
+				return &msg, nil
+			}
+			data.Reset()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read event stream: %w", err)
+	}
+	return nil, fmt.Errorf("event stream ended without a response")
+}
+
+func (t *HTTPTransport) Notify(ctx context.Context, notification *Message) error {
+	resp, err := t.post(ctx, notification)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Close ends the session on the server.
+func (t *HTTPTransport) Close() error {
+	t.mutex.Lock()
+	sessionID := t.sessionID
+	t.mutex.Unlock()
+	if sessionID == "" {
+		return nil
+	}
+
+	req, err := t.newRequest(context.Background(), http.MethodDelete, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to end session: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,138 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/blog/conversational-agent/internal/logger"
+)
+
+// maxRequestBytes bounds a message read from a client.
+const maxRequestBytes = 4 * 1024 * 1024
+
+// ServerHandler provides the tools and resources a server exposes to one client.
+type ServerHandler interface {
+	ListTools(ctx context.Context) ([]Tool, error)
+	// CallTool runs a tool. Failures of the tool itself belong in the result with IsError set;
+	// returned errors are reported as protocol errors.
+	CallTool(ctx context.Context, name string, arguments json.RawMessage) (*CallToolResult, error)
+	ListResources(ctx context.Context) ([]Resource, error)
+	ReadResource(ctx context.Context, uri string) (*ReadResourceResult, error)
+}
+
+// Server answers MCP requests with a ServerHandler. It is stateless: every request carries
+// everything needed to answer it, so no session is assigned.
+type Server struct {
+	Info         Implementation
+	Instructions string
+}
+
+// HandleMessage answers a single message. It returns nil for notifications and responses.
+func (s *Server) HandleMessage(ctx context.Context, handler ServerHandler, msg *Message) *Message {
+	if !msg.IsRequest() {
+		return nil
+	}
+
+	result, err := s.dispatch(ctx, handler, msg)
+	if err != nil {
+		if rpcErr, ok := err.(*RPCError); ok {
+			return &Message{JSONRPC: jsonRPCVersion, ID: msg.ID, Error: rpcErr}
+		}
+		return NewErrorResponse(msg.ID, CodeInternalError, err.Error())
+	}
+
+	response, err := NewResponse(msg.ID, result)
+	if err != nil {
+		return NewErrorResponse(msg.ID, CodeInternalError, err.Error())
+	}
+	return response
+}
+
+func (s *Server) dispatch(ctx context.Context, handler ServerHandler, msg *Message) (any, error) {
+	switch msg.Method {
+	case "initialize":
+		return InitializeResult{
+			ProtocolVersion: ProtocolVersion,
+			Capabilities: ServerCapabilities{
+				Tools:     &Capability{},
+				Resources: &Capability{},
+			},
+			ServerInfo:   s.Info,
+			Instructions: s.Instructions,
+		}, nil
+	case "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		tools, err := handler.ListTools(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ListToolsResult{Tools: tools}, nil
+	case "tools/call":
+		var params CallToolParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil || params.Name == "" {
+			return nil, &RPCError{Code: CodeInvalidParams, Message: "'name' is required"}
+		}
+		return handler.CallTool(ctx, params.Name, params.Arguments)
+	case "resources/list":
+		resources, err := handler.ListResources(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ListResourcesResult{Resources: resources}, nil
+	case "resources/read":
+		var params ReadResourceParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil || params.URI == "" {
+			return nil, &RPCError{Code: CodeInvalidParams, Message: "'uri' is required"}
+		}
+		return handler.ReadResource(ctx, params.URI)
+	}
+	return nil, &RPCError{Code: CodeMethodNotFound, Message: "method not found: " + msg.Method}
+}
+
+// ServeStreamableHTTP serves the streamable HTTP transport. Requests are answered with a
+// single JSON response; no server-initiated stream is offered.
+func (s *Server) ServeStreamableHTTP(w http.ResponseWriter, r *http.Request, handler ServerHandler) {
+	log := logger.GetLogger()
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "content type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBytes))
+	if err != nil {
+		http.Error(w, "failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	var msg Message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		writeMessage(w, NewErrorResponse(json.RawMessage("null"), CodeParseError, "invalid JSON"))
+		return
+	}
+
+	response := s.HandleMessage(r.Context(), handler, &msg)
+	if response == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if response.Error != nil {
+		log.Debug().Msgf("MCP %s failed: %s", msg.Method, response.Error.Message)
+	}
+	writeMessage(w, response)
+}
+
+func writeMessage(w http.ResponseWriter, msg *Message) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(msg)
+}
@@ -0,0 +1,198 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/blog/conversational-agent/internal/logger"
+)
+
+const (
+	// maxStdioMessageBytes bounds a single newline-delimited message read from a server.
+	maxStdioMessageBytes = 4 * 1024 * 1024
+	// stdioShutdownTimeout is how long a server may take to exit after its stdin closes.
+	stdioShutdownTimeout = 2 * time.Second
+)
+
+// Transport exchanges JSON-RPC messages with a server.
+type Transport interface {
+	// Call sends a request and waits for its response.
+	Call(ctx context.Context, request *Message) (*Message, error)
+	// Notify sends a notification.
+	Notify(ctx context.Context, notification *Message) error
+	Close() error
+}
+
+// StdioTransport runs a server as a subprocess and exchanges newline-delimited
+// messages over its stdin and stdout.
+type StdioTransport struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	pending    map[string]chan *Message
+	done       chan struct{}
+	readErr    error
+}
+
+var _ Transport = (*StdioTransport)(nil)
+
+// NewStdioTransport starts the command and begins reading its output.
+// env is added to the current process environment.
+func NewStdioTransport(command string, args []string, env map[string]string) (*StdioTransport, error) {
+	log := logger.GetLogger()
+
+	cmd := exec.Command(command, args...)
+	cmd.Env = os.Environ()
+	for name, value := range env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdout: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stderr: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", command, err)
+	}
+
+	t := &StdioTransport{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[string]chan *Message),
+		done:    make(chan struct{}),
+	}
+
+	// Servers log to stderr
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Debug().Msgf("mcp %s: %s", command, scanner.Text())
+		}
+	}()
+	go t.readLoop(stdout)
+
+	return t, nil
+}
+
+// readLoop dispatches responses to their waiting callers until stdout closes.
+func (t *StdioTransport) readLoop(stdout io.Reader) {
+	log := logger.GetLogger()
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxStdioMessageBytes)
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			log.Warn().Err(err).Msg("Ignoring invalid message from MCP server.")
+			continue
+		}
+
+		switch {
+		case msg.IsResponse():
+			t.mutex.Lock()
+			waiting, ok := t.pending[string(msg.ID)]
+			delete(t.pending, string(msg.ID))
+			t.mutex.Unlock()
+			if ok {
+				waiting <- &msg
+			}
+		case msg.IsRequest():
+			t.answerServerRequest(&msg)
+		}
+	}
+
+	t.mutex.Lock()
+	t.readErr = scanner.Err()
+	if t.readErr == nil {
+		t.readErr = io.EOF
+	}
+	t.mutex.Unlock()
+	close(t.done)
+}
+
+// answerServerRequest replies to requests the server sends. Only ping is supported.
+func (t *StdioTransport) answerServerRequest(request *Message) {
+	response := NewErrorResponse(request.ID, CodeMethodNotFound, "method not supported by client")
+	if request.Method == "ping" {
+		response, _ = NewResponse(request.ID, struct{}{})
+	}
+	_ = t.write(response)
+}
+
+func (t *StdioTransport) write(msg *Message) error {
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+	if _, err := t.stdin.Write(append(encoded, '\n')); err != nil {
+		return fmt.Errorf("failed to write to server: %w", err)
+	}
+	return nil
+}
+
+func (t *StdioTransport) Call(ctx context.Context, request *Message) (*Message, error) {
+	waiting := make(chan *Message, 1)
+	id := string(request.ID)
+
+	t.mutex.Lock()
+	t.pending[id] = waiting
+	t.mutex.Unlock()
+	defer func() {
+		t.mutex.Lock()
+		delete(t.pending, id)
+		t.mutex.Unlock()
+	}()
+
+	if err := t.write(request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-waiting:
+		return response, nil
+	case <-t.done:
+		return nil, fmt.Errorf("server exited: %w", t.readErr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Done is closed once the server's output ends, usually because the server exited.
+func (t *StdioTransport) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *StdioTransport) Notify(_ context.Context, notification *Message) error {
+	return t.write(notification)
+}
+
+// Close closes the server's stdin, which asks it to exit, and stops it if it does not.
+func (t *StdioTransport) Close() error {
+	_ = t.stdin.Close()
+	select {
+	case <-t.done:
+	case <-time.After(stdioShutdownTimeout):
+		_ = t.cmd.Process.Kill()
+	}
+	return t.cmd.Wait()
+}
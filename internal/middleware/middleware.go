@@ -1,9 +1,14 @@
 package middleware
 
 import (
+	"context"
+	"time"
+
 	"github.com/blog/conversational-agent/internal/logger"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 )
 
 // LoggingMiddleware logs HTTP requests and responses
@@ -26,3 +31,32 @@ func LoggingMiddleware() echo.MiddlewareFunc {
 		},
 	})
 }
+
+// GRPCUnaryLoggingInterceptor logs unary gRPC calls like LoggingMiddleware logs HTTP requests
+func GRPCUnaryLoggingInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logGRPCCall(info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// GRPCStreamLoggingInterceptor logs streaming gRPC calls once the stream ends
+func GRPCStreamLoggingInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, stream)
+		logGRPCCall(info.FullMethod, start, err)
+		return err
+	}
+}
+
+func logGRPCCall(method string, start time.Time, err error) {
+	log := logger.GetLogger()
+	log.Info().
+		Str("method", method).
+		Str("code", status.Code(err).String()).
+		Dur("latency", time.Since(start)).
+		Msg("gRPC request processed")
+}
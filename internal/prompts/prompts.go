@@ -1,6 +1,13 @@
 package prompts
 
-const ConversationalPrefix = `Assistant is a large language model trained by Meta.
+// ChainTemplate is the single-shot chain prompt, filled with the retrieved context and the input.
+const ChainTemplate = `
+{{.context}}
+
+AI Response:`
+
+// ConversationalPrefix opens the ReAct prompt with the org's persona instructions.
+const ConversationalPrefix = `{{.instructions}}
 
 Assistant is designed to assist with a wide range of tasks, from answering simple questions to providing in-depth explanations. It can generate human-like text based on the input it receives.
 
@@ -1,8 +1,11 @@
 package router
 
 import (
+	agentv1 "github.com/blog/conversational-agent/api/agent/v1"
 	"github.com/blog/conversational-agent/internal/handlers"
 	"github.com/labstack/echo/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 )
 
 // RegisterRoutes registers all application routes
@@ -11,4 +14,42 @@ func RegisterRoutes(e *echo.Echo, agentHandler *handlers.AgentHandler) {
 	e.GET("/v1/agent/memory/thread/:thread_id", agentHandler.GetMemoryHandler)
 	e.POST("/v1/agent/memory/update", agentHandler.AddDocumentHandler)
 	e.POST("/v1/agent/memory/import/:org_id/:user_id", agentHandler.ImportMemoryHandler)
+	e.GET("/v1/agent/tools/:org_id", agentHandler.ListToolsHandler)
+	e.PUT("/v1/agent/tools/:org_id", agentHandler.SetOrgToolsHandler)
+	e.GET("/v1/agent/actions/:org_id/:user_id/:thread_id", agentHandler.GetPendingActionHandler)
+	e.POST("/v1/agent/actions/:org_id/:user_id/:thread_id/approve", agentHandler.ApproveActionHandler)
+	e.POST("/v1/agent/actions/:org_id/:user_id/:thread_id/reject", agentHandler.RejectActionHandler)
+	e.GET("/v1/agent/persona/:org_id", agentHandler.GetPersonaHandler)
+	e.PUT("/v1/agent/persona/:org_id", agentHandler.SetPersonaHandler)
+	e.DELETE("/v1/agent/persona/:org_id", agentHandler.DeletePersonaHandler)
+	e.GET("/v1/agent/prompts", agentHandler.ListPromptsHandler)
+	e.GET("/v1/agent/prompts/:name", agentHandler.GetPromptHandler)
+	e.POST("/v1/agent/prompts/:name", agentHandler.AddPromptVersionHandler)
+	e.PUT("/v1/agent/prompts/:name/orgs/:org_id", agentHandler.AssignPromptHandler)
+	e.DELETE("/v1/agent/prompts/:name/orgs/:org_id", agentHandler.UnassignPromptHandler)
+	e.GET("/v1/agent/ws/:org_id/:user_id/:thread_id", agentHandler.WebSocketHandler)
+	e.POST("/v1/agent/events/:org_id", agentHandler.PublishEventHandler)
+	e.POST("/v1/agent/threads/:org_id/:user_id/:thread_id/regenerate", agentHandler.RegenerateHandler)
+	e.POST("/v1/agent/threads/:org_id/:user_id/:thread_id/messages/:message_id/edit", agentHandler.EditMessageHandler)
+	e.GET("/v1/agent/threads/:org_id/:user_id/:thread_id/tree", agentHandler.GetThreadTreeHandler)
+	e.GET("/v1/agent/threads/:org_id/:user_id/:thread_id/branches/:message_id", agentHandler.GetBranchHandler)
+	e.PUT("/v1/agent/threads/:org_id/:user_id/:thread_id/branches/:message_id", agentHandler.ActivateBranchHandler)
+	e.GET("/v1/agent/messages/:org_id/:user_id/:message_id", agentHandler.GetAnswerHandler)
+	e.POST("/v1/agent/messages/:org_id/:user_id/:message_id/feedback", agentHandler.SubmitFeedbackHandler)
+	e.GET("/v1/agent/feedback/:org_id", agentHandler.ListFeedbackHandler)
+	e.POST("/v1/agent/batches/:org_id", agentHandler.CreateBatchHandler)
+	e.GET("/v1/agent/batches/:org_id/:batch_id", agentHandler.GetBatchHandler)
+	e.GET("/v1/agent/batches/:org_id/:batch_id/results", agentHandler.GetBatchResultsHandler)
+	e.POST("/v1/agent/batches/:org_id/:batch_id/cancel", agentHandler.CancelBatchHandler)
+	e.GET("/v1/agent/generations/:org_id/:user_id/:generation_id", agentHandler.GetGenerationHandler)
+	e.GET("/v1/agent/generations/:org_id/:user_id/:generation_id/stream", agentHandler.StreamGenerationHandler)
+	e.POST("/v1/agent/generations/:org_id/:user_id/:generation_id/cancel", agentHandler.CancelGenerationHandler)
+	e.POST("/v1/chat/completions", agentHandler.ChatCompletionsHandler)
+	e.Match([]string{"GET", "POST", "DELETE"}, "/v1/mcp/:org_id/:user_id", agentHandler.MCPHandler)
+}
+
+// RegisterGRPCServices registers the gRPC services, and reflection for tools such as grpcurl
+func RegisterGRPCServices(s *grpc.Server, agentHandler *handlers.AgentHandler) {
+	agentv1.RegisterAgentServiceServer(s, &handlers.GRPCServer{AgentHandler: agentHandler})
+	reflection.Register(s)
 }